@@ -0,0 +1,231 @@
+package main
+
+import (
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/ojrac/opensimplex-go"
+)
+
+/*
+ * Biomes. Two large scale noise maps, temperature and humidity, place every column somewhere in climate space;
+ * each biome sits at a point of that space and a column belongs to the closest one. Height profiles and tints
+ * are blended over every biome with weights that fall off with climate distance, so neighboring biomes fade
+ * into each other instead of meeting at a cliff. Surface blocks come from the closest biome alone.
+ */
+
+type BiomeID uint8
+
+const (
+	PlainsBiome BiomeID = iota
+	DesertBiome
+	MountainsBiome
+	OceanBiome
+	ForestBiome
+	TundraBiome
+)
+
+type Biome struct {
+	Name        string
+	Temperature float32 // climate the biome is centered on, both roughly -1..1
+	Humidity    float32
+
+	BaseHeight      float32 // average world height of the surface
+	HeightVariation float32 // how far the terrain noise moves the surface up or down
+
+	Surface     SurfaceRules
+	Vegetation  Vegetation
+	GrassTint   mgl32.Vec3
+	FoliageTint mgl32.Vec3
+}
+
+var Biomes = [...]Biome{
+	PlainsBiome: {
+		Name: "Plains", Temperature: 0.15, Humidity: -0.05,
+		BaseHeight: 40, HeightVariation: 6,
+		Surface:    SurfaceRules{Top: GrassID, Filler: DirtID, FillerDepth: 4},
+		Vegetation: Vegetation{TreeChance: 0.04, Trees: []TreeKind{OakTree, OakTree, BranchingTree}, GrassChance: 0.25, FlowerChance: 0.04},
+		GrassTint:  mgl32.Vec3{0.486, 0.741, 0.419}, FoliageTint: mgl32.Vec3{0.467, 0.671, 0.184},
+	},
+	DesertBiome: {
+		Name: "Desert", Temperature: 0.5, Humidity: -0.4,
+		BaseHeight: 42, HeightVariation: 4,
+		Surface:   SurfaceRules{Top: SandID, Filler: SandID, FillerDepth: 5},
+		GrassTint: mgl32.Vec3{0.749, 0.718, 0.333}, FoliageTint: mgl32.Vec3{0.682, 0.643, 0.165},
+	},
+	MountainsBiome: {
+		Name: "Mountains", Temperature: -0.2, Humidity: -0.35,
+		BaseHeight: 62, HeightVariation: 28,
+		Surface:    SurfaceRules{Top: StoneID, Filler: StoneID, FillerDepth: 1},
+		Vegetation: Vegetation{TreeChance: 0.08, Trees: []TreeKind{SpruceTree}},
+		GrassTint:  mgl32.Vec3{0.541, 0.714, 0.537}, FoliageTint: mgl32.Vec3{0.427, 0.639, 0.451},
+	},
+	OceanBiome: {
+		Name: "Ocean", Temperature: 0.3, Humidity: 0.5,
+		BaseHeight: 22, HeightVariation: 5,
+		Surface:   SurfaceRules{Top: SandID, Filler: SandID, FillerDepth: 3},
+		GrassTint: mgl32.Vec3{0.557, 0.725, 0.443}, FoliageTint: mgl32.Vec3{0.443, 0.655, 0.302},
+	},
+	ForestBiome: {
+		Name: "Forest", Temperature: 0.05, Humidity: 0.25,
+		BaseHeight: 44, HeightVariation: 10,
+		Surface:    SurfaceRules{Top: GrassID, Filler: DirtID, FillerDepth: 4},
+		Vegetation: Vegetation{TreeChance: 0.8, Trees: []TreeKind{OakTree, OakTree, OakTree, BranchingTree}, GrassChance: 0.15, FlowerChance: 0.02},
+		GrassTint:  mgl32.Vec3{0.475, 0.753, 0.353}, FoliageTint: mgl32.Vec3{0.349, 0.682, 0.188},
+	},
+	TundraBiome: {
+		Name: "Tundra", Temperature: -0.5, Humidity: 0.1,
+		BaseHeight: 38, HeightVariation: 5,
+		Surface:    SurfaceRules{Top: SnowID, Filler: DirtID, FillerDepth: 3},
+		Vegetation: Vegetation{TreeChance: 0.15, Trees: []TreeKind{SpruceTree}, GrassChance: 0.03},
+		GrassTint:  mgl32.Vec3{0.502, 0.706, 0.592}, FoliageTint: mgl32.Vec3{0.376, 0.631, 0.482},
+	},
+}
+
+type BiomeSource struct {
+	temperature, humidity opensimplex.Noise32
+
+	ClimateScale float32 // blocks per unit of climate noise, larger means bigger biomes
+	BlendWidth   float32 // squared climate distance over which neighboring biomes fade, smaller means sharper borders
+}
+
+// The biomes of the world being played, shared by the generator and the mesher
+var worldBiomes = NewBiomeSource(worldSettings.Seed)
+
+func NewBiomeSource(seed int64) *BiomeSource {
+	return &BiomeSource{
+		temperature:  opensimplex.New32(seed + 10),
+		humidity:     opensimplex.New32(seed + 11),
+		ClimateScale: 320,
+		BlendWidth:   0.06,
+	}
+}
+
+// biomeWeights holds how much each biome contributes to a column, summing to 1.
+type biomeWeights [len(Biomes)]float32
+
+func (s *BiomeSource) climate(worldX, worldZ int32) (temperature, humidity float32) {
+	temperature = fractalNoise2D(s.temperature, worldX, worldZ, 2, 2, 0.5, s.ClimateScale)
+	humidity = fractalNoise2D(s.humidity, worldX, worldZ, 2, 2, 0.5, s.ClimateScale)
+	return temperature, humidity
+}
+
+// sample returns the closest biome of a column along with the blend weights of every biome.
+func (s *BiomeSource) sample(worldX, worldZ int32) (BiomeID, biomeWeights) {
+	temperature, humidity := s.climate(worldX, worldZ)
+
+	var distances [len(Biomes)]float32
+	closest := BiomeID(0)
+	for id, biome := range Biomes {
+		dt, dh := temperature-biome.Temperature, humidity-biome.Humidity
+		distances[id] = dt*dt + dh*dh
+		if distances[id] < distances[closest] {
+			closest = BiomeID(id)
+		}
+	}
+
+	// Weights are relative to the closest biome so the exponent never underflows to all zeroes
+	var weights biomeWeights
+	var total float32
+	for id, distance := range distances {
+		weights[id] = float32(math.Exp(float64((distances[closest] - distance) / s.BlendWidth)))
+		total += weights[id]
+	}
+	for id := range weights {
+		weights[id] /= total
+	}
+	return closest, weights
+}
+
+// BiomeAt returns the biome of the column at world X/Z.
+func (s *BiomeSource) BiomeAt(worldX, worldZ int32) BiomeID {
+	id, _ := s.sample(worldX, worldZ)
+	return id
+}
+
+// heightProfile blends the base height and height variation of every biome for a column.
+func (s *BiomeSource) heightProfile(worldX, worldZ int32) (baseHeight, variation float32) {
+	_, weights := s.sample(worldX, worldZ)
+	for id, weight := range weights {
+		baseHeight += Biomes[id].BaseHeight * weight
+		variation += Biomes[id].HeightVariation * weight
+	}
+	return baseHeight, variation
+}
+
+func (s *BiomeSource) GrassTint(worldX, worldZ int32) mgl32.Vec3 {
+	_, weights := s.sample(worldX, worldZ)
+	return blendTint(weights, func(b *Biome) mgl32.Vec3 { return b.GrassTint })
+}
+
+func (s *BiomeSource) FoliageTint(worldX, worldZ int32) mgl32.Vec3 {
+	_, weights := s.sample(worldX, worldZ)
+	return blendTint(weights, func(b *Biome) mgl32.Vec3 { return b.FoliageTint })
+}
+
+// blendTint mixes a tint of every biome, rounded to the 8 bits a vertex stores so the faces of columns
+// deep inside one biome come out identical and still merge when greedy meshing.
+func blendTint(weights biomeWeights, tint func(b *Biome) mgl32.Vec3) mgl32.Vec3 {
+	var blended mgl32.Vec3
+	for id, weight := range weights {
+		blended = blended.Add(tint(&Biomes[id]).Mul(weight))
+	}
+	packed := packTint(blended)
+	return mgl32.Vec3{float32(packed[0]) / 255, float32(packed[1]) / 255, float32(packed[2]) / 255}
+}
+
+// BiomeAt returns the biome of the world being played at world X/Z, for debugging.
+func BiomeAt(worldX, worldZ int32) *Biome {
+	return &Biomes[worldBiomes.BiomeAt(worldX, worldZ)]
+}
+
+func cameraBiome() *Biome {
+	return BiomeAt(int32(math.Round(float64(cameraPosition[0]))), int32(math.Round(float64(cameraPosition[2]))))
+}
+
+// columnTint holds the biome colours of one column.
+type columnTint struct {
+	grass, foliage mgl32.Vec3
+}
+
+// columnTints returns the biome colours of every column of a pillar.
+func columnTints(pos PillarPos) *[CHUNK_SIZE][CHUNK_SIZE]columnTint {
+	var tints [CHUNK_SIZE][CHUNK_SIZE]columnTint
+	for x := range CHUNK_SIZE {
+		for z := range CHUNK_SIZE {
+			_, weights := worldBiomes.sample(pos.getWorldX()+int32(x), pos.getWorldZ()+int32(z))
+			tints[x][z] = columnTint{
+				grass:   blendTint(weights, func(b *Biome) mgl32.Vec3 { return b.GrassTint }),
+				foliage: blendTint(weights, func(b *Biome) mgl32.Vec3 { return b.FoliageTint }),
+			}
+		}
+	}
+	return &tints
+}
+
+// blockTint returns the colour a block's texture is multiplied by in a column.
+func blockTint(blockType uint16, column *columnTint) mgl32.Vec3 {
+	switch BlockProperties[blockType].Tint {
+	case grassBiomeTint:
+		return column.grass
+	case foliageBiomeTint:
+		return column.foliage
+	}
+	return noTint
+}
+
+// lazyColumnTints looks the column tints of a pillar up the first time a tinted block needs them.
+type lazyColumnTints struct {
+	pos   PillarPos
+	tints *[CHUNK_SIZE][CHUNK_SIZE]columnTint
+}
+
+func (l *lazyColumnTints) tint(blockType uint16, x, z uint8) mgl32.Vec3 {
+	if BlockProperties[blockType].Tint == noBiomeTint {
+		return noTint
+	}
+	if l.tints == nil {
+		l.tints = columnTints(l.pos)
+	}
+	return blockTint(blockType, &l.tints[x][z])
+}
@@ -4,10 +4,9 @@ import (
 	"image"
 	"image/draw"
 	"image/png"
-	"math"
+	"log"
 	"os"
 	"sync"
-	"time"
 
 	"github.com/go-gl/gl/v4.1-core/gl"
 	"github.com/go-gl/mathgl/mgl32"
@@ -16,93 +15,56 @@ import (
 var pillars = make(map[PillarPos]*Pillar)
 var pillarsMu sync.RWMutex
 
-var dirtyChunks = make(map[ChunkPosition]*[]float32)
+// A mesh built by a chunk worker, waiting to be uploaded on the render thread
+type builtMesh struct {
+	verts        *[]uint32
+	waterVerts   *[]uint32 // translucent pass, see water.go
+	blockedFaces faceConnectivity
+}
+
+var dirtyChunks = make(map[ChunkPosition]builtMesh)
 var dirtyChunksMu sync.Mutex
 
-func CreateChunkMeshData(chunk *Chunk, cP ChunkPosition) *[]float32 {
-	var verts *[]float32 = preProcessChunkVAO(chunk, cP)
+func CreateChunkMeshData(chunk *Chunk, cP ChunkPosition) *[]uint32 {
+	var verts *[]uint32 = preProcessChunkVAO(pillars, chunk, cP)
 	return verts
 }
 
 func createChunkData(chunkPos ChunkPosition) *Chunk {
-	var blocksData = [CHUNK_SIZE][CHUNK_SIZE][CHUNK_SIZE]*Block{}
-	for x := range CHUNK_SIZE_i32 {
-		for z := range CHUNK_SIZE_i32 {
-
-			noiseValue := fractalNoise(int32(x)+(chunkPos.pillarPos.getWorldX()), int32(z)+(chunkPos.pillarPos.getWorldZ()), amplitude, 2, 1.5, 0.5, scale) + 40
-
-			for y := range CHUNK_SIZE {
-
-				worldY := int32(y) + chunkPos.getWorldY()
-
-				if worldY > (noiseValue) {
-					// Air blocks above terrain
-					blocksData[x][y][z] = &Block{blockType: AirID}
-
-				} else {
-					// At or below terrain level
-					if worldY < 0 {
-						// Underground cave generation
-						/*
-							isCave := fractalNoise3D(int32(x)+(chunkPos.pillarPos.x*CHUNK_SIZE_i32), int32(y)+(chunkPos.getWorldY()*CHUNK_SIZE_i32), int32(z)+(chunkPos.pillarPos.z*CHUNK_SIZE_i32), 2, 12)
-
-							if isCave > 0.1 {
-
-								blocksData[x][y][z] = &Block{blockType: AirID}
-
-							} else {
-								// Solid underground blocks
-								if worldY == noiseValue {
-									blocksData[x][y][z] = &Block{blockType: DirtID}
-
-								} else {
-
-									blocksData[x][y][z] = &Block{blockType: StoneID}
-								}
-							}
-						*/
-						blocksData[x][y][z] = &Block{blockType: StoneID}
-					} else {
-						// Surface/above-ground terrain
-						if worldY == noiseValue {
-							blocksData[x][y][z] = &Block{blockType: StoneID}
-
-						} else {
-							blocksData[x][y][z] = &Block{blockType: DirtID}
-
-						}
-					}
-				}
-			}
-
-		}
-	}
-
-	return &Chunk{
-		blocksData:   blocksData,
-		lightSources: []blockPosition{},
-		vao:          0,
-		trisCount:    0,
-	}
+	return worldGenerator.GenerateChunk(chunkPos)
 }
+
+// queueChunkRebuild queues a new mesh for a chunk. Pillars that haven't had their first mesh yet are skipped,
+// they are meshed with up to date data once their neighbors are in.
 func queueChunkRebuild(cP ChunkPosition) {
-	// Grab the chunk safely
 	pillarsMu.RLock()
 	pl := pillars[cP.pillarPos]
-	var ch *Chunk
-	if pl != nil {
-		ch = pl.chunks[cP.index]
-	}
+	ready := pl != nil && pl.chunk(cP.y) != nil && pl.meshReady.Load()
 	pillarsMu.RUnlock()
-	if ch == nil {
+	if !ready {
 		return
 	}
 
-	verts := preProcessChunkVAO(ch, cP)
-	dirtyChunksMu.Lock()
-	dirtyChunks[cP] = verts
-	dirtyChunksMu.Unlock()
+	chunkJobs.push(chunkJobKey{meshChunkJob, cP})
+}
+
+// remeshLoadedChunks rebuilds the mesh of every loaded chunk, e.g. after a mesher setting was toggled.
+func remeshLoadedChunks() {
+	pillarsMu.RLock()
+	var positions []ChunkPosition
+	for pos, pillar := range pillars {
+		for i := range pillar.chunks {
+			positions = append(positions, ChunkPosition{pos, pillar.bottom + int32(i)})
+		}
+	}
+	pillarsMu.RUnlock()
+
+	for _, cP := range positions {
+		queueChunkRebuild(cP)
+	}
 }
+
+// CreatePillar loads or generates the pillar at pos and adds it to the world, returning false if it already was.
 func CreatePillar(pos PillarPos) bool {
 
 	pillarsMu.RLock()
@@ -112,46 +74,50 @@ func CreatePillar(pos PillarPos) bool {
 		return false
 	}
 
+	var pillar = &Pillar{pos: pos}
+
+	// Saved pillars are loaded from their region file, everything else is generated. A pillar that was just
+	// unloaded may still be being written out, it's read back once that's done.
+	waitForPillarSaves(pos)
+	stored, err := loadStoredPillar(pos)
+	if err != nil {
+		log.Printf("loading pillar %d,%d: %v", pos.x, pos.z, err)
+	}
+	if stored != nil {
+		pillar.chunks, pillar.bottom = stored.chunks, stored.bottom
+		pillar.lit.Store(true)
+	} else {
+		// Generated up to the highest block the generator may place, the air chunks above that are left out
+		pillar.bottom = GENERATION_BOTTOM_CHUNK_Y
+		for y := GENERATION_BOTTOM_CHUNK_Y; y <= chunkYFromWorldY(worldGenerator.HighestBlock(pos)); y++ {
+			pillar.chunks = append(pillar.chunks, createChunkData(ChunkPosition{pos, y}))
+		}
+		pillar.trimSky()
+		pillar.dirty.Store(true)
+	}
+
+	pillar.lod.Store(int32(lodLevel(pos, cameraPillar())))
+
+	// Only publish complete pillars, so nil chunks never show up inside a loaded pillar
 	pillarsMu.Lock()
 	if _, exists := pillars[pos]; exists {
 		pillarsMu.Unlock()
 		return false
 	}
-	var pillar = &Pillar{}
 	pillars[pos] = pillar
 	pillarsMu.Unlock()
 
-	// First create all Chunk data so nil neighbors inside the pillar won't happen
-	for y := uint8(0); y < 64; y++ {
-		chunkPos := ChunkPosition{pos, y}
-		pillar.chunks[y] = createChunkData(chunkPos)
-	}
-
-	// Then mesh each chunk and notify neighbors
-	for y := uint8(0); y < 64; y++ {
-		chunkPos := ChunkPosition{pos, y}
-		buildChunk(pillar.chunks[y], chunkPos) // queues current chunk upload
-
-		// Rebuild existing neighbors to cull border faces
-		for _, n := range neighborChunkPositions(chunkPos) {
-			pillarsMu.RLock()
-			neighborPillar := pillars[n.pillarPos]
-			var neighborExists bool
-			if neighborPillar != nil && neighborPillar.chunks[n.index] != nil {
-				neighborExists = true
-			}
-			pillarsMu.RUnlock()
-
-			if neighborExists {
-				queueChunkRebuild(n)
-			}
-		}
-
+	if !pillar.lit.Load() {
+		queueLightJob(lightJob{kind: lightPillarJob, pillar: pos})
 	}
+	queueReadyPillarMeshes(pos)
 
 	return true
 }
-func loadTextureAtlas(textureFilePath string) uint32 {
+
+// loadTextureArray splits the block atlas into one texture array layer per 16x16 tile, so faces can repeat
+// their texture across merged quads. Layers are numbered row by row, matching textureLayer.
+func loadTextureArray(textureFilePath string) uint32 {
 
 	file, err := os.Open(textureFilePath)
 	if err != nil {
@@ -159,129 +125,53 @@ func loadTextureAtlas(textureFilePath string) uint32 {
 	}
 	defer file.Close()
 
-	var textureID uint32
-	gl.GenTextures(1, &textureID)
-	gl.BindTexture(gl.TEXTURE_2D, textureID)
 	imageFile, err := png.Decode(file)
 	if err != nil {
 		panic(err)
 	}
 
-	rgba := image.NewRGBA(imageFile.Bounds())
-	draw.Draw(rgba, rgba.Bounds(), imageFile, image.Point{}, draw.Over)
+	rgba := image.NewNRGBA(imageFile.Bounds()) // straight alpha, blending multiplies by alpha itself
+	draw.Draw(rgba, rgba.Bounds(), imageFile, imageFile.Bounds().Min, draw.Src)
+
+	tile := int(ATLAS_TILE_SIZE)
+	columns := rgba.Bounds().Dx() / tile
+	rows := rgba.Bounds().Dy() / tile
+	layers := make([]uint8, 0, columns*rows*tile*tile*4)
+	for row := range rows {
+		for col := range columns {
+			for y := range tile {
+				offset := rgba.PixOffset(col*tile, row*tile+y)
+				layers = append(layers, rgba.Pix[offset:offset+tile*4]...)
+			}
+		}
+	}
 
-	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA, int32(rgba.Bounds().Dx()), int32(rgba.Bounds().Dy()), 0, gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(rgba.Pix))
+	var textureID uint32
+	gl.GenTextures(1, &textureID)
+	gl.BindTexture(gl.TEXTURE_2D_ARRAY, textureID)
+	gl.TexImage3D(gl.TEXTURE_2D_ARRAY, 0, gl.RGBA, ATLAS_TILE_SIZE, ATLAS_TILE_SIZE, int32(columns*rows), 0, gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(layers))
 
-	gl.GenerateMipmap(gl.TEXTURE_2D)
+	gl.GenerateMipmap(gl.TEXTURE_2D_ARRAY)
 
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST_MIPMAP_LINEAR)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D_ARRAY, gl.TEXTURE_WRAP_S, gl.REPEAT)
+	gl.TexParameteri(gl.TEXTURE_2D_ARRAY, gl.TEXTURE_WRAP_T, gl.REPEAT)
+	gl.TexParameteri(gl.TEXTURE_2D_ARRAY, gl.TEXTURE_MIN_FILTER, gl.NEAREST_MIPMAP_LINEAR)
+	gl.TexParameteri(gl.TEXTURE_2D_ARRAY, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
 
 	var maxAnisotropy int32
 	gl.GetIntegerv(gl.MAX_TEXTURE_MAX_ANISOTROPY, &maxAnisotropy)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAX_ANISOTROPY, maxAnisotropy)
+	gl.TexParameteri(gl.TEXTURE_2D_ARRAY, gl.TEXTURE_MAX_ANISOTROPY, maxAnisotropy)
 	return textureID
 
 }
 
-func getTextureCoords(blockID uint16, faceIndex uint8) []float32 {
+// textureLayer returns the texture array layer of a block face: one atlas row per block type, one column per face.
+func textureLayer(blockID uint16, faceIndex uint8) uint8 {
+	if isWater(blockID) {
+		blockID = WaterID // every flowing level looks like a source
+	}
 	blockID -= 1 // Adjust blockID to be zero-based( account for air block)
-	// Calculate UV coordinates
-	u1 := float32(faceIndex*16) / float32(96)
-	v1 := float32(blockID*16) / float32(48)
-	u2 := float32((faceIndex+1)*16) / float32(96)
-	v2 := float32((blockID+1)*16) / float32(48)
-
-	return []float32{u1, v1, u2, v2}
-
-}
-
-func BFSLightProp(lightSources []ChunkBlockPositions, inversePropagation bool) map[ChunkBlockPositions]struct{} {
-	//queue := []ChunkBlockPositions{}
-	visited := make(map[ChunkBlockPositions]struct{})
-	//head := 0
-	/*
-		chunksMu.RLock()
-
-		for _, source := range lightSources {
-			queue = append(queue, source)
-			visited[source] = struct{}{}
-		}
-
-		for head < len(queue) {
-			cur := queue[head]
-			head++
-
-			// Cache chunk lookup for performance
-			currentChunk, ok := chunks[cur.chunkPos]
-			if !ok {
-				continue
-			}
-
-			x, y, z := cur.blockPos.x, cur.blockPos.y, cur.blockPos.z
-			block := currentChunk.blocksData[x][y][z]
-
-			// Stop propagating if light is too dim
-			if block.sunLight <= 1 { // Changed from <= 0 to <= 1
-				continue
-			}
-
-			newLightLevel := block.sunLight - 1
-
-			// Use CardinalDirections from constants
-			for _, dir := range CardinalDirections {
-				newX := int16(x) + int16(dir.x)
-				newY := int16(y) + int16(dir.y)
-				newZ := int16(z) + int16(dir.z)
-
-				var neighbor ChunkBlockPositions
-				var targetBlock *Block
-
-				// Check if within current chunk bounds
-				if newX >= 0 && newX < int16(CHUNK_SIZE) &&
-					newY >= 0 && newY < int16(CHUNK_SIZE) &&
-					newZ >= 0 && newZ < int16(CHUNK_SIZE) {
-
-					// Within current chunk
-					neighborPos := blockPosition{uint8(newX), uint8(newY), uint8(newZ)}
-					neighbor = ChunkBlockPositions{cur.chunkPos, neighborPos}
-					targetBlock = currentChunk.blocksData[newX][newY][newZ]
-
-				} else {
-					// Cross-chunk boundary - calculate neighbor chunk manually
-					neighborChunk, neighborBlock := calculateCrossChunkNeighbor(cur.chunkPos, x, y, z, dir)
-
-					adjChunk, ok := chunks[neighborChunk]
-					if !ok {
-						continue // Skip if neighbor chunk doesn't exist
-					}
-
-					neighbor = ChunkBlockPositions{neighborChunk, neighborBlock}
-					targetBlock = adjChunk.blocksData[neighborBlock.x][neighborBlock.y][neighborBlock.z]
-				}
-
-				// Don't skip if already visited, check light level first
-				if targetBlock == nil || !targetBlock.isTransparent() {
-					continue
-				}
-
-				// Check if this block would receive more light
-				if targetBlock.sunLight < newLightLevel {
-					targetBlock.sunLight = newLightLevel
-
-					// Only add to queue if not already visited
-					if _, seen := visited[neighbor]; !seen {
-						queue = append(queue, neighbor)
-						visited[neighbor] = struct{}{}
-					}
-				}
-			}
-		}
-		chunksMu.RUnlock()
-	*/
-	return visited
+	return uint8(int(blockID)*ATLAS_COLUMNS + int(faceIndex))
 }
 
 // Updated helper function for cross-chunk calculations using Vec3Int8
@@ -307,16 +197,10 @@ func calculateCrossChunkNeighbor(chunkPos ChunkPosition, x, y, z uint8, dir Vec3
 
 	// Handle Y boundary
 	if newY < 0 {
-		if neighborChunk.index > 0 {
-			neighborChunk.index--
-		}
-
+		neighborChunk.y--
 		neighborBlock.y = CHUNK_SIZE - 1
 	} else if newY >= int16(CHUNK_SIZE) {
-
-		if neighborChunk.index < 63 {
-			neighborChunk.index++
-		}
+		neighborChunk.y++
 		neighborBlock.y = 0
 	} else {
 		neighborBlock.y = uint8(newY)
@@ -356,86 +240,18 @@ func getFaceFromDirection(dir struct{ dx, dy, dz int8 }) uint8 {
 	}
 }
 
-func createBlockShadow(newBlock ChunkBlockPositions) []ChunkBlockPositions {
-	var blocks []ChunkBlockPositions
-	/*
-
-		var chunkPos chunkPosition = newBlock.chunkPos
-
-		chunklets := lightingChunks[chunkPositionLighting{newBlock.chunkPos.x, newBlock.chunkPos.z}]
-		x := newBlock.blockPos.x
-		y := newBlock.blockPos.y - 1 // start from the air block below
-		z := newBlock.blockPos.z
-
-		i := int32(len(chunklets)/2) / (chunkPos.y * int32(CHUNK_SIZE))
-		chunkPos = chunklets[i]
-
-		for globalY := int32(256); globalY > -240; globalY-- {
-
-			y--
-			if y == 0 {
-				i++
-				y = CHUNK_SIZE
-				chunkPos = chunklets[i]
-			}
-
-			blockPos := blockPosition{x, y, z}
-
-			blocks = append(blocks, ChunkBlockPositions{chunkPos, blockPos})
-
-			continue
-
-		}
-
-
-	*/
-	return blocks
-}
-
-func fractalNoise(x int32, z int32, amplitude float32, octaves int, lacunarity float32, persistence float32, scale float32) int32 {
-	val := int32(0)
-	x1 := float32(x)
-	z1 := float32(z)
-
-	for i := 0; i < octaves; i++ {
-		val += int32(noise.Eval2(x1/scale, z1/scale) * amplitude)
-		z1 *= lacunarity
-		x1 *= lacunarity
-		amplitude *= persistence
-	}
-
-	return val
-
-}
-func fractalNoise3D(x int32, y int32, z int32, amplitude float32, scale float32) float32 {
-	val := float32(0)
-	x1 := float32(x)
-	y1 := float32(y)
-	z1 := float32(z)
-
-	val += noise.Eval3(x1/scale, y1/scale, z1/scale) * amplitude
-
-	if val < -1 {
-		return -1
-	}
-	if val > 1 {
-		return 1
-	}
-	return val
-
-}
-
 type adjBjockResult struct {
 	ok       bool
-	Block    *Block
+	Block    Block
 	chunkPos ChunkPosition
 	blockPos blockPosition
 }
 
-func getAdjBlockFromFace(key blockPosition, chunkPos ChunkPosition, face uint8) adjBjockResult {
+// getAdjBlockFromFace looks up the block across a face of key in world.
+func getAdjBlockFromFace(world map[PillarPos]*Pillar, key blockPosition, chunkPos ChunkPosition, face uint8) adjBjockResult {
 
 	var adjPillar PillarPos = chunkPos.pillarPos
-	var adjChunkIndex = chunkPos.index
+	var adjChunkY = chunkPos.y
 	var adjBlock blockPosition = key
 
 	switch face {
@@ -469,18 +285,14 @@ func getAdjBlockFromFace(key blockPosition, chunkPos ChunkPosition, face uint8)
 		}
 	case FACE_MAP.UP:
 		if key.y == CHUNK_SIZE-1 {
-			if adjChunkIndex < 63 {
-				adjChunkIndex += 1
-			}
+			adjChunkY += 1
 			adjBlock = blockPosition{key.x, 0, key.z}
 		} else {
 			adjBlock = blockPosition{key.x, key.y + 1, key.z}
 		}
 	case FACE_MAP.DOWN:
 		if key.y == 0 {
-			if adjChunkIndex > 0 {
-				adjChunkIndex -= 1
-			}
+			adjChunkY -= 1
 			adjBlock = blockPosition{key.x, CHUNK_SIZE - 1, key.z}
 		} else {
 			adjBlock = blockPosition{key.x, key.y - 1, key.z}
@@ -488,13 +300,13 @@ func getAdjBlockFromFace(key blockPosition, chunkPos ChunkPosition, face uint8)
 	}
 	//println(adjPillar.x, adjPillar.z)
 
-	if p, ok := pillars[adjPillar]; ok {
-		ch := p.chunks[adjChunkIndex]
+	if p, ok := world[adjPillar]; ok {
+		ch := p.chunkOrSky(adjChunkY)
 		if ch != nil {
 			return adjBjockResult{
 				ok:       true,
-				Block:    ch.blocksData[adjBlock.x][adjBlock.y][adjBlock.z],
-				chunkPos: ChunkPosition{adjPillar, adjChunkIndex},
+				Block:    ch.getBlock(adjBlock.x, adjBlock.y, adjBlock.z),
+				chunkPos: ChunkPosition{adjPillar, adjChunkY},
 				blockPos: adjBlock,
 			}
 		}
@@ -503,38 +315,111 @@ func getAdjBlockFromFace(key blockPosition, chunkPos ChunkPosition, face uint8)
 	return adjBjockResult{}
 }
 
-var grassTint = mgl32.Vec3{0.486, 0.741, 0.419}
 var noTint = mgl32.Vec3{1.0, 1.0, 1.0}
 
-func GenerateBlockFace(key blockPosition, chunkPos ChunkPosition, faceIndex uint8, verts *[]float32, self Block, y, z, x float32, curTint mgl32.Vec3, u, v uint8, vertexLight float32, useTextureOverlay bool) {
-	textureUV := getTextureCoords(self.blockType, faceIndex)
+// GenerateBlockFace appends one packed vertex at block corner x, y, z (0..CHUNK_SIZE).
+func GenerateBlockFace(verts *[]uint32, blockType uint16, faceIndex uint8, x, y, z uint8, curTint mgl32.Vec3, vertexLight float32) {
+	packed := chunkVertex{
+		x: x, y: y, z: z,
+		face:  faceIndex,
+		light: packLightLevel(vertexLight),
+		layer: textureLayer(blockType, faceIndex),
+		tint:  packTint(curTint),
+	}.pack()
+	*verts = append(*verts, packed[:]...)
+}
+
+// Each face in CubeVertices is two triangles v0 v1 v2, v0 v2 v3; these are the indices of its 4 corners
+var faceCornerVertices = [4]int{0, 1, 2, 5}
 
-	if useTextureOverlay {
-		textureUVOverlay := getTextureCoords(self.blockType, faceIndex)
-		*verts = append(*verts, x, y, z, textureUV[u], textureUV[v], vertexLight, curTint[0], curTint[1], curTint[2], textureUVOverlay[u], textureUVOverlay[v])
+// Corner orders for the two possible quad diagonals. The shared index buffer always splits a quad
+// 0 1 2, 0 2 3, so starting at corner 1 flips the diagonal.
+var quadCornerOrder = [2][4]int{
+	{0, 1, 2, 3},
+	{1, 2, 3, 0},
+}
 
+func preProcessChunkVAO(world map[PillarPos]*Pillar, _Chunk *Chunk, chunkPos ChunkPosition) *[]uint32 {
+	var verts *[]uint32
+	if GreedyMeshing {
+		verts = greedyMeshChunk(world, _Chunk, chunkPos)
 	} else {
-		*verts = append(*verts, x, y, z, textureUV[u], textureUV[v], vertexLight, curTint[0], curTint[1], curTint[2], 0, 0)
+		verts = naiveMeshChunk(world, _Chunk, chunkPos)
 	}
+	appendPlants(verts, _Chunk, chunkPos)
+	return verts
+}
+
+// Bottom corners of the two diagonal planes a plant is drawn with, as x, z offsets within the block
+var plantDiagonals = [2][2][2]uint8{
+	{{0, 0}, {1, 1}},
+	{{1, 0}, {0, 1}},
 }
 
-func preProcessChunkVAO(_Chunk *Chunk, chunkPos ChunkPosition) *[]float32 {
-	var verts []float32
+// appendPlants draws every plant as two crossed quads, each emitted once per side since a plant has no back.
+func appendPlants(verts *[]uint32, _Chunk *Chunk, chunkPos ChunkPosition) {
+	if blockType, ok := _Chunk.isUniform(); ok && !BlockProperties[blockType].IsPlant {
+		return
+	}
 
+	tints := lazyColumnTints{pos: chunkPos.pillarPos}
+	for x := range CHUNK_SIZE {
+		for y := range CHUNK_SIZE {
+			for z := range CHUNK_SIZE {
+				self := _Chunk.getBlock(x, y, z)
+				if !BlockProperties[self.blockType].IsPlant {
+					continue
+				}
+
+				curTint := tints.tint(self.blockType, x, z)
+				light := float32(self.lightLevel())
+				for _, diagonal := range plantDiagonals {
+					from, to := diagonal[0], diagonal[1]
+					corners := [4][3]uint8{
+						{x + from[0], y, z + from[1]},
+						{x + to[0], y, z + to[1]},
+						{x + to[0], y + 1, z + to[1]},
+						{x + from[0], y + 1, z + from[1]},
+					}
+					for _, order := range [2][4]int{{0, 1, 2, 3}, {1, 0, 3, 2}} {
+						for _, c := range order {
+							GenerateBlockFace(verts, self.blockType, FACE_MAP.FRONT, corners[c][0], corners[c][1], corners[c][2], curTint, light)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// naiveMeshChunk emits one quad for every visible block face.
+func naiveMeshChunk(world map[PillarPos]*Pillar, _Chunk *Chunk, chunkPos ChunkPosition) *[]uint32 {
+	var verts []uint32
+	visibleFaces(world, _Chunk, chunkPos, func(key blockPosition, face uint8, self Block, curTint mgl32.Vec3, cornerLight [4]float32) {
+		appendQuad(&verts, face, self.blockType, curTint, cornerLight, key, key)
+	})
+	return &verts
+}
+
+// visibleFaces calls emit for every solid block face that isn't covered by a solid neighbor, along with the
+// light at its 4 corners. Faces towards a pillar meshed at another level of detail are never covered, see lod.go.
+func visibleFaces(world map[PillarPos]*Pillar, _Chunk *Chunk, chunkPos ChunkPosition, emit func(key blockPosition, face uint8, self Block, curTint mgl32.Vec3, cornerLight [4]float32)) {
+	tints := lazyColumnTints{pos: chunkPos.pillarPos} // only looked up once the chunk turns out to have tinted blocks
+	var level int32
+	if pillar := world[chunkPos.pillarPos]; pillar != nil {
+		level = pillar.lod.Load()
+	}
 	for x := range CHUNK_SIZE {
 		for y := range CHUNK_SIZE {
 			for z := range CHUNK_SIZE {
 				key := blockPosition{x, y, z}
-				self := _Chunk.blocksData[x][y][z]
+				self := _Chunk.getBlock(x, y, z)
 
 				if self.isSolid() == false {
 					continue
 				}
 
-				curTint := noTint
-				if self.blockType == GrassID {
-					curTint = grassTint
-				}
+				curTint := tints.tint(self.blockType, x, z)
 
 				faces := []uint8{
 					FACE_MAP.FRONT, FACE_MAP.BACK,
@@ -542,12 +427,23 @@ func preProcessChunkVAO(_Chunk *Chunk, chunkPos ChunkPosition) *[]float32 {
 				}
 
 				shouldRender := make([]bool, len(faces))
+				faceLight := make([]uint8, len(faces)) // light of the open cell each face looks into
+				skirt := make([]bool, len(faces))      // faces hanging over a coarser neighbor's edge
 
 				hideEntireBlock := true
 
 				for _, face := range faces {
-					result := getAdjBlockFromFace(key, chunkPos, face)
+					result := getAdjBlockFromFace(world, key, chunkPos, face)
 					shouldRender[face] = !result.ok || !result.Block.isSolid()
+					faceLight[face] = maxLightLevel
+					if result.ok {
+						faceLight[face] = result.Block.lightLevel()
+					}
+					if result.ok && result.Block.isSolid() && result.chunkPos.pillarPos != chunkPos.pillarPos &&
+						world[result.chunkPos.pillarPos].lod.Load() != level {
+						shouldRender[face], skirt[face] = true, true
+						faceLight[face] = maxLightLevel // lit like the coarse skirts, the block it faces is buried
+					}
 					if hideEntireBlock && shouldRender[face] {
 						hideEntireBlock = false
 					}
@@ -558,123 +454,70 @@ func preProcessChunkVAO(_Chunk *Chunk, chunkPos ChunkPosition) *[]float32 {
 					continue
 				}
 
-				const FACE_SIZE = 18
-
-				for i := 0; i < len(CubeVertices); i += 3 {
-
-					x := CubeVertices[i] + float32(key.x)
-					y := CubeVertices[i+1] + float32(key.y)
-					z := CubeVertices[i+2] + float32(key.z)
-					uv := (i / 3) * 2
-					var u, v uint8 = CubeUVs[uv], CubeUVs[uv+1]
-
-					face := uint8(i / FACE_SIZE)
-
-					if shouldRender[face] {
-						/*
-							// Determine signs from relative vertex position
-							var sx int8 = -1
-							if CubeVertices[i] > 0 {
-								sx = 1
-							}
-							var sy int8 = -1
-							if CubeVertices[i+1] > 0 {
-								sy = 1
-							}
-							var sz int8 = -1
-							if CubeVertices[i+2] > 0 {
-								sz = 1
-							}
-							// Normal and in-plane axes for the current face
-							var nx, ny, nz int8 = 0, 0, 0
-							var a1x, a1y, a1z int8 = 0, 0, 0
-							var a2x, a2y, a2z int8 = 0, 0, 0
-							switch face {
-							case FACE_MAP.FRONT:
-								nz = 1
-								a1x = sx
-								a2y = sy
-							case FACE_MAP.BACK:
-								nz = -1
-								a1x = sx
-								a2y = sy
-							case FACE_MAP.LEFT:
-								nx = -1
-								a1z = sz
-								a2y = sy
-							case FACE_MAP.RIGHT:
-								nx = 1
-								a1z = sz
-								a2y = sy
-							case FACE_MAP.UP:
-								ny = 1
-								a1x = sx
-								a2z = sz
-							case FACE_MAP.DOWN:
-								ny = -1
-								a1x = sx
-								a2z = sz
-							}
-
-								// AO factor based on occluders
-								aoMul := float32(1.0)
-
-								// Smooth light from neighboring blocks around the vertex corner
-								getLight := func(dx, dy, dz int8) float32 {
-									nChunk, nBlock := calculateCrossChunkNeighbor(chunkPos, key.x, key.y, key.z, Vec3Int8{dx, dy, dz})
-									//pillarsMu.RLock()
-									pl, ok := pillars[nChunk.pillarPos]
-									if !ok {
-										//pillarsMu.RUnlock()
-										return 0
-									}
-									lightLevel := float32(pl.chunks[nChunk.index].blocksData[nBlock.x][nBlock.y][nBlock.z].lightLevel())
-									//pillarsMu.RUnlock()
-									return lightLevel
-								}
-								l0 := getLight(nx, ny, nz)
-								l1 := getLight(nx+a1x, ny+a1y, nz+a1z)
-								l2 := getLight(nx+a2x, ny+a2y, nz+a2z)
-								l3 := getLight(nx+a1x+a2x, ny+a1y+a2y, nz+a1z+a2z)
-								avgLight := (l0 + l1 + l2 + l3) * 0.25
-								// Directional face shading
-								dirMul := float32(1.0)
-								if face == FACE_MAP.DOWN {
-									dirMul *= 0.5
-								}
-								if face == FACE_MAP.UP {
-									dirMul *= 0.8
-								}
-								if face == FACE_MAP.FRONT || face == FACE_MAP.BACK {
-									dirMul *= 0.7
-								}
-								if face == FACE_MAP.RIGHT || face == FACE_MAP.LEFT {
-									dirMul *= 0.6
-								}
-								vertexLight := avgLight * dirMul * aoMul
-						*/
-						vertexLight := float32(self.lightLevel())
-						if vertexLight < 0 {
-							vertexLight = 0
-						}
-						if vertexLight > 15 {
-							vertexLight = 15
+				var around neighborhood
+				if AmbientOcclusion {
+					around = sampleNeighborhood(world, _Chunk, key, chunkPos)
+				}
+
+				const FACE_SIZE = 18 // 6 vertices * xyz
+
+				for _, face := range faces {
+					if !shouldRender[face] {
+						continue
+					}
+
+					var cornerLight [4]float32
+					for c, vi := range faceCornerVertices {
+						i := int(face)*FACE_SIZE + vi*3
+						switch {
+						case AmbientOcclusion && skirt[face]:
+							cornerLight[c] = float32(faceLight[face]) * faceShade[face]
+						case AmbientOcclusion:
+							cornerLight[c] = around.vertexLight(face, CubeVertices[i], CubeVertices[i+1], CubeVertices[i+2])
+						default:
+							cornerLight[c] = float32(faceLight[face])
 						}
-						GenerateBlockFace(key, chunkPos, face, &verts, *self, y, z, x, curTint, u, v, vertexLight, true)
+						cornerLight[c] = min(max(cornerLight[c], 0), 15)
 					}
 
+					emit(key, face, self, curTint, cornerLight)
 				}
 
 			}
 		}
 	}
-	return &verts
 }
 
-func createChunkVAO(verts *[]float32) (uint32, int32) {
+// appendQuad emits the two triangles of a face covering every block from..to (inclusive) in the face plane.
+func appendQuad(verts *[]uint32, face uint8, blockType uint16, curTint mgl32.Vec3, cornerLight [4]float32, from, to blockPosition) {
+	// Split the quad along the diagonal with the closest light values, otherwise AO gradients look anisotropic
+	order := quadCornerOrder[0]
+	if cornerLight[0]+cornerLight[2] < cornerLight[1]+cornerLight[3] {
+		order = quadCornerOrder[1]
+	}
+
+	// Block centers sit at integer positions, so a -0.5 offset is the low corner of a block and +0.5 the high one
+	extent := func(offset float32, lo, hi uint8) uint8 {
+		if offset < 0 {
+			return lo
+		}
+		return hi + 1
+	}
+
+	for _, c := range order {
+		vi := int(face)*6 + faceCornerVertices[c]
+		x := extent(CubeVertices[vi*3], from.x, to.x)
+		y := extent(CubeVertices[vi*3+1], from.y, to.y)
+		z := extent(CubeVertices[vi*3+2], from.z, to.z)
+
+		GenerateBlockFace(verts, blockType, face, x, y, z, curTint, cornerLight[c])
+	}
+}
+
+func createChunkVAO(verts *[]uint32) (uint32, uint32, int32) {
 
 	if len(*verts) == 0 {
-		return 0, 0
+		return 0, 0, 0
 	}
 	var vbo uint32
 	gl.GenBuffers(1, &vbo)
@@ -685,28 +528,19 @@ func createChunkVAO(verts *[]float32) (uint32, int32) {
 	gl.GenVertexArrays(1, &vao)
 	gl.BindVertexArray(vao)
 
-	//position
+	// position, face and light
 	gl.EnableVertexAttribArray(0)
-	gl.VertexAttribPointer(0, 3, gl.FLOAT, false, 11*4, nil)
+	gl.VertexAttribIPointerWithOffset(0, 1, gl.UNSIGNED_INT, vertexWords*4, 0)
 
-	// Enable vertex attribute array for texture coordinates (location 1)
+	// texture layer and tint
 	gl.EnableVertexAttribArray(1)
-	// Define the texture coordinate data layout: 2 components (u, v)
-	gl.VertexAttribPointerWithOffset(1, 2, gl.FLOAT, false, 11*4, uintptr(3*4))
+	gl.VertexAttribIPointerWithOffset(1, 1, gl.UNSIGNED_INT, vertexWords*4, 4)
 
-	//light level
-	gl.EnableVertexAttribArray(2)
-	gl.VertexAttribPointerWithOffset(2, 1, gl.FLOAT, false, 11*4, uintptr(5*4))
+	// The element buffer binding is part of the VAO state
+	gl.BindBuffer(gl.ELEMENT_ARRAY_BUFFER, sharedQuadIndexBuffer())
 
-	//texture tint
-	gl.EnableVertexAttribArray(3)
-	gl.VertexAttribPointerWithOffset(3, 3, gl.FLOAT, false, 11*4, uintptr(6*4))
-
-	//overlay texture
-	gl.EnableVertexAttribArray(4)
-	gl.VertexAttribPointerWithOffset(4, 2, gl.FLOAT, false, 11*4, uintptr(9*4))
-
-	return vao, int32(len(*verts) / 11)
+	quads := len(*verts) / vertexWords / 4
+	return vao, vbo, int32(quads * quadIndices)
 }
 func isBorderBlock(pos blockPosition) bool {
 	if pos.x == 0 || pos.x == CHUNK_SIZE || pos.y == 0 || pos.y == CHUNK_SIZE || pos.z == 0 || pos.z == CHUNK_SIZE {
@@ -716,130 +550,79 @@ func isBorderBlock(pos blockPosition) bool {
 	return false
 }
 
-func breakBlock(pos blockPosition, chunkPos ChunkPosition) {
-	/*
-		chunksMu.RLock()
-		chunks[chunkPos].blocksData[pos.x][pos.y][pos.z] = &Block{
-			blockType: AirID,
-		}
-		chunksMu.RUnlock()
-
-		// 2. Find the brightest neighboring light source to determine the new block's light level.
-		var newLightLevel uint8 = 0
-		repropagationQueue := []ChunkBlockPositions{}
-
-		for i := range uint8(6) {
-			// Check all 6 faces for adjacent light sources
-			adjBlock := getAdjBlockFromFace(pos, chunkPos, i)
-			if adjBlock.blockExists && adjBlock.Block.sunLight > 0 {
-
-				// Sunlight from directly above propagates downwards at full strength.
-				if i == FACE_MAP.UP && adjBlock.Block.sunLight == 15 {
-					newLightLevel = 15
-					break
-				}
-				// For other light, it diminishes by 1.
-				if adjBlock.Block.sunLight > newLightLevel {
-					newLightLevel = adjBlock.Block.sunLight - 1
-				}
-			}
-		}
-
-		// 3. Set the new light level and prepare for propagation.
-		if newLightLevel > 0 {
-			chunksMu.RLock()
-			chunks[chunkPos].blocksData[pos.x][pos.y][pos.z].sunLight = newLightLevel
-			chunksMu.RUnlock()
-			repropagationQueue = append(repropagationQueue, ChunkBlockPositions{chunkPos, pos})
-		}
-
-		// 4. Propagate the new light outwards from this block.
-		var visitedArea map[ChunkBlockPositions]struct{} = make(map[ChunkBlockPositions]struct{})
-		if len(repropagationQueue) > 0 {
-			visitedArea = BFSLightProp(repropagationQueue, false)
-		}
-		// Update affected chunks
-		chunksToUpdate := make(map[chunkPosition]*Chunk)
-		for visited := range visitedArea {
-			chunksToUpdate[visited.chunkPos] = chunks[visited.chunkPos]
-		}
-		_, borderingChunks := ReturnBorderingChunks(pos, chunkPos)
-		chunksMu.RLock()
-		for _, chunk := range borderingChunks {
-			if ch, ok := chunks[chunk]; ok {
-				chunksToUpdate[chunk] = ch
-			}
-		}
-		chunksMu.RUnlock()
-		chunksMu.RLock()
-		if ch, ok := chunks[chunkPos]; ok {
-			chunksToUpdate[chunkPos] = ch
-		}
-		chunksMu.RUnlock()
-		//GenerateChunkMeshes(chunksToUpdate)
-	*/
+// applyBlockEdits makes the edits a simulation step asked for in the loaded world.
+func applyBlockEdits(edits []blockEdit) {
+	for _, e := range edits {
+		pos := worldToChunkBlock(e.block[0], e.block[1], e.block[2])
+		setBlockAndRelight(pos.blockPos, pos.chunkPos, e.blockType)
+	}
 }
 
-func placeBlock(pos blockPosition, chunkPos ChunkPosition, blockType uint16) {
-
-	/*
-		chunksToUpdate := make(map[chunkPosition]Chunk)
-
-		chunks[chunkPos].blocksData[pos.x][pos.y][pos.z] = &Block{
-			blockType: blockType,
-		}
-
-				//make vertical shadow
-				shadowBlocks := createBlockShadow(ChunkBlockPositions{chunkPos, pos})
-				for _, shadowBlock := range shadowBlocks {
-					chunksToUpdate[shadowBlock.chunkPos] = chunks[shadowBlock.chunkPos]
-				}
-
-				//fill in shadow with reverse propagation
-				affectedBFSChunks := BFSLightProp(shadowBlocks, true)
-				for data := range affectedBFSChunks {
-					chunksToUpdate[data.chunkPos] = chunks[data.chunkPos]
-				}
-
-				chunksToUpdate[chunkPos] = chunks[chunkPos]
+// setBlockAndRelight edits a single block and remeshes it right away, the lighting worker fixes up the light after.
+func setBlockAndRelight(pos blockPosition, chunkPos ChunkPosition, blockType uint16) {
+	pillarsMu.Lock()
+	pillar := pillars[chunkPos.pillarPos]
+	var ch *Chunk
+	if pillar != nil {
+		ch = pillar.ensureChunk(chunkPos.y)
+	}
+	if ch == nil {
+		pillarsMu.Unlock()
+		return
+	}
+	oldType := ch.getBlockType(pos.x, pos.y, pos.z)
+	ch.setBlockType(pos.x, pos.y, pos.z, blockType)
+	pillar.dirty.Store(true)
+	uncovered := pos.y == 0 && chunkPos.y == pillar.bottom && !BlockProperties[blockType].IsSolid
+	pillarsMu.Unlock()
 
+	// A hole in the bottom layer uncovers the ground under the pillar
+	if uncovered {
+		growPillarDown(chunkPos.pillarPos, chunkPos.y)
+	}
 
-			GenerateChunkMeshes(&chunksToUpdate)
-	*/
+	blockChanged(ChunkBlockPositions{chunkPos, pos}, oldType)
+	fluids.scheduleBlockChange(ChunkBlockPositions{chunkPos, pos})
 }
 
-func propagateSunLight(pillar *Pillar) {
+// growPillarDown generates the chunk under the pillar at pos, whose bottom is at chunk Y bottom. It's generated
+// without holding pillarsMu and only added if the bottom is still where it was by then.
+func growPillarDown(pos PillarPos, bottom int32) {
+	below := createChunkData(ChunkPosition{pos, bottom - 1})
+
 	pillarsMu.Lock()
+	defer pillarsMu.Unlock()
+	pillar := pillars[pos]
+	if pillar == nil || pillar.bottom != bottom {
+		return
+	}
+	pillar.chunks, pillar.bottom = append([]*Chunk{below}, pillar.chunks...), bottom-1
+	pillar.dirty.Store(true)
+}
 
-	var blocks []ChunkBlockPositions
-
-	for x := uint8(0); x < CHUNK_SIZE; x++ {
-		for z := uint8(0); z < CHUNK_SIZE; z++ {
-			foundSolid := false
-			for ci := 63; ci >= 0 && !foundSolid; ci-- {
-				ch := pillar.chunks[ci]
-
-				for y := int(CHUNK_SIZE) - 1; y >= 0; y-- {
-					block := ch.blocksData[x][uint8(y)][z]
-					if !block.isSolid() {
-						block.sunLight = 15
-						blocks = append(blocks, ChunkBlockPositions{ChunkPosition{pillar.pos, uint8(ci)}, blockPosition{x, uint8(y), z}})
-					} else {
-						foundSolid = true
-						break
-					}
-				}
+// blockChanged relights and remeshes around a block that was already changed in the world.
+func blockChanged(block ChunkBlockPositions, oldType uint16) {
+	queueLightJob(lightJob{kind: lightBlockJob, block: block, oldType: oldType})
+
+	// Faces, ambient occlusion and smooth light look one block into the neighbors, edges and corners included, so
+	// a block on a chunk or pillar border changes the meshes on the other side too
+	x, y, z := block.worldPos()
+	logBlockEdit(x, y, z)
+	remesh := make(map[ChunkPosition]struct{})
+	for dx := int32(-1); dx <= 1; dx++ {
+		for dy := int32(-1); dy <= 1; dy++ {
+			for dz := int32(-1); dz <= 1; dz++ {
+				remesh[worldToChunkBlock(x+dx, y+dy, z+dz).chunkPos] = struct{}{}
 			}
 		}
 	}
-	pillarsMu.Unlock()
-	if len(blocks) > 0 {
-		//BFSLightProp(blocks, false)
+	for cP := range remesh {
+		queueChunkRebuild(cP)
 	}
-
 }
 
 func ProcessChunks() {
+	deleteFreedMeshes()
 
 	dirtyChunksMu.Lock()
 	if len(dirtyChunks) == 0 {
@@ -848,12 +631,25 @@ func ProcessChunks() {
 	}
 	pillarsMu.Lock()
 
-	for cP, verts := range dirtyChunks {
-		vao, trisCount := createChunkVAO(verts)
-		pillars[cP.pillarPos].chunks[cP.index].vao = vao
-		pillars[cP.pillarPos].chunks[cP.index].trisCount = trisCount
-
+	uploads := 0
+	for cP, mesh := range dirtyChunks {
+		if uploads >= MAX_CHUNK_UPLOADS_PER_FRAME {
+			break
+		}
+		uploads++
 		delete(dirtyChunks, cP)
+
+		// The pillar may have been unloaded while the mesh was being built
+		pillar := pillars[cP.pillarPos]
+		if pillar == nil || pillar.chunk(cP.y) == nil {
+			continue
+		}
+		ch := pillar.chunk(cP.y)
+		deleteChunkMesh(chunkMesh{ch.vao, ch.vbo})
+		deleteChunkMesh(chunkMesh{ch.waterVao, ch.waterVbo})
+		ch.vao, ch.vbo, ch.indexCount = createChunkVAO(mesh.verts)
+		ch.waterVao, ch.waterVbo, ch.waterIndexCount = createChunkVAO(mesh.waterVerts)
+		ch.blockedFaces = mesh.blockedFaces
 	}
 
 	pillarsMu.Unlock()
@@ -861,47 +657,16 @@ func ProcessChunks() {
 
 }
 
-func buildChunk(chunk *Chunk, pos ChunkPosition) {
-
-	//	propagateSunLightColumn(chunkPositionLighting{pos.x, pos.z})
-	//requestMeshRebuild(pos)
-
-	verts := preProcessChunkVAO(chunk, pos)
-	dirtyChunksMu.Lock()
-	dirtyChunks[pos] = verts
-	dirtyChunksMu.Unlock()
-	//	registerChunkInLighting(pos)
-
-}
 func neighborChunkPositions(c ChunkPosition) []ChunkPosition {
 	res := make([]ChunkPosition, 0, 6)
-	// Up/Down within pillar (0..63)
-	if c.index > 0 {
-		res = append(res, ChunkPosition{pillarPos: c.pillarPos, index: c.index - 1})
-	}
-	if c.index < 63 {
-		res = append(res, ChunkPosition{pillarPos: c.pillarPos, index: c.index + 1})
-	}
+	// Up/Down within pillar
+	res = append(res, ChunkPosition{pillarPos: c.pillarPos, y: c.y - 1})
+	res = append(res, ChunkPosition{pillarPos: c.pillarPos, y: c.y + 1})
 	// +/- X pillar
-	res = append(res, ChunkPosition{pillarPos: PillarPos{x: c.pillarPos.x - 1, z: c.pillarPos.z}, index: c.index})
-	res = append(res, ChunkPosition{pillarPos: PillarPos{x: c.pillarPos.x + 1, z: c.pillarPos.z}, index: c.index})
+	res = append(res, ChunkPosition{pillarPos: PillarPos{x: c.pillarPos.x - 1, z: c.pillarPos.z}, y: c.y})
+	res = append(res, ChunkPosition{pillarPos: PillarPos{x: c.pillarPos.x + 1, z: c.pillarPos.z}, y: c.y})
 	// +/- Z pillar
-	res = append(res, ChunkPosition{pillarPos: PillarPos{x: c.pillarPos.x, z: c.pillarPos.z - 1}, index: c.index})
-	res = append(res, ChunkPosition{pillarPos: PillarPos{x: c.pillarPos.x, z: c.pillarPos.z + 1}, index: c.index})
+	res = append(res, ChunkPosition{pillarPos: PillarPos{x: c.pillarPos.x, z: c.pillarPos.z - 1}, y: c.y})
+	res = append(res, ChunkPosition{pillarPos: PillarPos{x: c.pillarPos.x, z: c.pillarPos.z + 1}, y: c.y})
 	return res
 }
-func makeTestChunks() {
-
-	ticker := time.NewTicker(time.Duration(TICK_UPDATE_RATE * float32(time.Second)))
-	for range ticker.C {
-		cx := int32(math.Floor(float64(cameraPosition[0] / float32(CHUNK_SIZE))))
-		cz := int32(math.Floor(float64(cameraPosition[2] / float32(CHUNK_SIZE))))
-		for x := -RENDER_DISTANCE_i32; x <= RENDER_DISTANCE_i32; x++ {
-			for z := -RENDER_DISTANCE_i32; z <= RENDER_DISTANCE_i32; z++ {
-				pillarPos := PillarPos{x + cx, z + cz}
-				CreatePillar(pillarPos)
-			}
-		}
-	}
-
-}
@@ -0,0 +1,262 @@
+package main
+
+import "math/bits"
+
+/*
+ * Chunk block storage: each of the 4096 cells stores an index into a per chunk palette of block types.
+ * Indices are bit packed into uint64 words using the smallest power of two width that fits the palette.
+ * A palette with a single entry (all air, all stone...) needs no index data at all.
+ * Light is kept separately as sun<<4 | block nibbles, with the same single value fast path.
+ */
+
+const chunkVolume = int(CHUNK_SIZE) * int(CHUNK_SIZE) * int(CHUNK_SIZE)
+
+func blockIndex(x, y, z uint8) int {
+	return int(x)<<8 | int(y)<<4 | int(z)
+}
+
+type blockStorage struct {
+	palette []uint16
+	bits    uint8    // bits per index, 0 when every cell is palette[0]
+	data    []uint64 // packed palette indices
+}
+
+func newBlockStorage(blockType uint16) blockStorage {
+	return blockStorage{palette: []uint16{blockType}}
+}
+
+func (s *blockStorage) get(i int) uint16 {
+	if s.bits == 0 {
+		return s.palette[0]
+	}
+	perWord := 64 / int(s.bits)
+	word := s.data[i/perWord]
+	shift := uint(i%perWord) * uint(s.bits)
+	return s.palette[(word>>shift)&(1<<s.bits-1)]
+}
+
+func (s *blockStorage) set(i int, blockType uint16) {
+	if s.bits == 0 && s.palette[0] == blockType {
+		return
+	}
+	paletteIndex := -1
+	for p, t := range s.palette {
+		if t == blockType {
+			paletteIndex = p
+			break
+		}
+	}
+	if paletteIndex < 0 {
+		paletteIndex = len(s.palette)
+		s.palette = append(s.palette, blockType)
+		if len(s.palette) > 1<<s.bits {
+			s.resize(bitsForPalette(len(s.palette)))
+		}
+	}
+	s.setIndex(i, uint64(paletteIndex))
+}
+
+func (s *blockStorage) setIndex(i int, paletteIndex uint64) {
+	perWord := 64 / int(s.bits)
+	shift := uint(i%perWord) * uint(s.bits)
+	mask := uint64(1<<s.bits-1) << shift
+	s.data[i/perWord] = s.data[i/perWord]&^mask | paletteIndex<<shift
+}
+
+func (s *blockStorage) rawIndex(i int) uint64 {
+	if s.bits == 0 {
+		return 0
+	}
+	perWord := 64 / int(s.bits)
+	return (s.data[i/perWord] >> (uint(i%perWord) * uint(s.bits))) & (1<<s.bits - 1)
+}
+
+// bitsForPalette returns the power of two index width able to address n palette entries.
+func bitsForPalette(n int) uint8 {
+	if n <= 1 {
+		return 0
+	}
+	b := bits.Len(uint(n - 1))
+	for _, width := range []int{1, 2, 4, 8, 16} {
+		if b <= width {
+			return uint8(width)
+		}
+	}
+	return 16
+}
+
+// resize repacks the index data with a new index width, keeping the palette untouched.
+func (s *blockStorage) resize(newBits uint8) {
+	old := *s
+	s.bits = newBits
+	s.data = nil
+	if newBits == 0 {
+		return
+	}
+	s.data = make([]uint64, chunkVolume*int(newBits)/64)
+	for i := range chunkVolume {
+		s.setIndex(i, old.rawIndex(i))
+	}
+}
+
+// compact drops unused palette entries and shrinks the index width to match.
+func (s *blockStorage) compact() {
+	if s.bits == 0 {
+		s.palette = s.palette[:1]
+		return
+	}
+
+	used := make([]bool, len(s.palette))
+	for i := range chunkVolume {
+		used[s.rawIndex(i)] = true
+	}
+	remap := make([]uint64, len(s.palette))
+	var palette []uint16
+	for p, t := range s.palette {
+		if used[p] {
+			remap[p] = uint64(len(palette))
+			palette = append(palette, t)
+		}
+	}
+	if len(palette) == len(s.palette) {
+		return
+	}
+
+	old := *s
+	s.palette = palette
+	s.bits = bitsForPalette(len(palette))
+	s.data = nil
+	if s.bits == 0 {
+		return
+	}
+	s.data = make([]uint64, chunkVolume*int(s.bits)/64)
+	for i := range chunkVolume {
+		s.setIndex(i, remap[old.rawIndex(i)])
+	}
+}
+
+func (s *blockStorage) clone() blockStorage {
+	return blockStorage{
+		palette: append([]uint16(nil), s.palette...),
+		bits:    s.bits,
+		data:    append([]uint64(nil), s.data...),
+	}
+}
+
+type lightStorage struct {
+	uniform uint8   // sun<<4 | block light of every cell while data is nil
+	data    []uint8 // per cell sun<<4 | block light
+}
+
+func (s *lightStorage) get(i int) uint8 {
+	if s.data == nil {
+		return s.uniform
+	}
+	return s.data[i]
+}
+
+func (s *lightStorage) set(i int, packed uint8) {
+	if s.data == nil {
+		if packed == s.uniform {
+			return
+		}
+		s.data = make([]uint8, chunkVolume)
+		for j := range s.data {
+			s.data[j] = s.uniform
+		}
+	}
+	s.data[i] = packed
+}
+
+// compact frees the per cell data if every cell ended up with the same light.
+func (s *lightStorage) compact() {
+	if s.data == nil {
+		return
+	}
+	first := s.data[0]
+	for _, l := range s.data {
+		if l != first {
+			return
+		}
+	}
+	s.uniform = first
+	s.data = nil
+}
+
+func (s *lightStorage) clone() lightStorage {
+	return lightStorage{uniform: s.uniform, data: append([]uint8(nil), s.data...)}
+}
+
+func packLight(sunLight, blockLight uint8) uint8 {
+	return sunLight<<4 | blockLight&0x0F
+}
+
+func newChunk(blockType uint16) *Chunk {
+	return &Chunk{
+		blocks:       newBlockStorage(blockType),
+		lightSources: []blockPosition{},
+	}
+}
+
+func (c *Chunk) getBlock(x, y, z uint8) Block {
+	i := blockIndex(x, y, z)
+	light := c.light.get(i)
+	return Block{
+		blockType:  c.blocks.get(i),
+		blockLight: light & 0x0F,
+		sunLight:   light >> 4,
+	}
+}
+
+func (c *Chunk) getBlockType(x, y, z uint8) uint16 {
+	return c.blocks.get(blockIndex(x, y, z))
+}
+
+func (c *Chunk) setBlock(x, y, z uint8, block Block) {
+	i := blockIndex(x, y, z)
+	c.blocks.set(i, block.blockType)
+	c.light.set(i, packLight(block.sunLight, block.blockLight))
+}
+
+func (c *Chunk) setBlockType(x, y, z uint8, blockType uint16) {
+	c.blocks.set(blockIndex(x, y, z), blockType)
+}
+
+func (c *Chunk) getSunLight(x, y, z uint8) uint8 {
+	return c.light.get(blockIndex(x, y, z)) >> 4
+}
+
+func (c *Chunk) getBlockLight(x, y, z uint8) uint8 {
+	return c.light.get(blockIndex(x, y, z)) & 0x0F
+}
+
+func (c *Chunk) setSunLight(x, y, z uint8, level uint8) {
+	i := blockIndex(x, y, z)
+	c.light.set(i, c.light.get(i)&0x0F|level<<4)
+}
+
+func (c *Chunk) setBlockLight(x, y, z uint8, level uint8) {
+	i := blockIndex(x, y, z)
+	c.light.set(i, c.light.get(i)&0xF0|level&0x0F)
+}
+
+// isUniform reports whether every cell holds the same block type, e.g. all air or all stone.
+func (c *Chunk) isUniform() (uint16, bool) {
+	if c.blocks.bits == 0 {
+		return c.blocks.palette[0], true
+	}
+	return 0, false
+}
+
+// snapshot copies the block and light data, leaving out the GPU state.
+func (c *Chunk) snapshot() *Chunk {
+	return &Chunk{
+		blocks: c.blocks.clone(),
+		light:  c.light.clone(),
+	}
+}
+
+func (c *Chunk) compact() {
+	c.blocks.compact()
+	c.light.compact()
+}
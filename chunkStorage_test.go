@@ -0,0 +1,222 @@
+package main
+
+import (
+	"math/rand/v2"
+	"runtime"
+	"testing"
+)
+
+// assertStorage checks every cell of s against want along with the index width.
+func assertStorage(t *testing.T, s *blockStorage, want *[chunkVolume]uint16, bits uint8) {
+	t.Helper()
+	if s.bits != bits {
+		t.Fatalf("palette of %d uses %d bit indices, want %d", len(s.palette), s.bits, bits)
+	}
+	if words := chunkVolume * int(bits) / 64; len(s.data) != words {
+		t.Fatalf("%d bit indices take %d words, want %d", bits, len(s.data), words)
+	}
+	for i := range chunkVolume {
+		if got := s.get(i); got != want[i] {
+			t.Fatalf("cell %d holds %d, want %d", i, got, want[i])
+		}
+	}
+}
+
+func TestBlockStorageGrowsAndCompacts(t *testing.T) {
+	s := newBlockStorage(StoneID)
+	var want [chunkVolume]uint16
+	for i := range want {
+		want[i] = StoneID
+	}
+	assertStorage(t, &s, &want, 0)
+
+	// Setting the only block type there is stays on the fast path
+	s.set(10, StoneID)
+	assertStorage(t, &s, &want, 0)
+
+	// 1 -> 2 -> 3 -> 17 entries, every resize keeps what was set before
+	set := func(i int, blockType uint16) {
+		s.set(i, blockType)
+		want[i] = blockType
+	}
+	set(0, DirtID)
+	assertStorage(t, &s, &want, 1)
+	set(chunkVolume-1, GrassID)
+	assertStorage(t, &s, &want, 2)
+	set(1, SandID)
+	assertStorage(t, &s, &want, 2)
+	for blockType := uint16(100); len(s.palette) < 17; blockType++ {
+		set(int(blockType)*7, blockType)
+	}
+	assertStorage(t, &s, &want, 8)
+	if len(s.palette) != 17 {
+		t.Fatalf("palette has %d entries, want 17", len(s.palette))
+	}
+
+	// Overwriting all but three block types leaves 2 bit indices after compacting
+	for i := range want {
+		if want[i] >= 100 {
+			set(i, StoneID)
+		}
+	}
+	s.compact()
+	assertStorage(t, &s, &want, 2)
+	if len(s.palette) != 4 {
+		t.Fatalf("compacted palette %v, want stone, dirt, grass and sand", s.palette)
+	}
+
+	// Down to a single block type again, which needs no index data
+	for i := range want {
+		set(i, AirID)
+	}
+	s.compact()
+	assertStorage(t, &s, &want, 0)
+	if len(s.palette) != 1 || s.palette[0] != AirID {
+		t.Fatalf("compacted palette %v, want just air", s.palette)
+	}
+}
+
+func TestBlockStorageRandomEdits(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	s := newBlockStorage(AirID)
+	var want [chunkVolume]uint16
+	for round := range 8 {
+		// Up to 300 block types needs the widest indices
+		for range 5000 {
+			i, blockType := rng.IntN(chunkVolume), uint16(rng.IntN(300))
+			s.set(i, blockType)
+			want[i] = blockType
+		}
+		if round%2 == 1 {
+			s.compact()
+		}
+		for i := range chunkVolume {
+			if got := s.get(i); got != want[i] {
+				t.Fatalf("round %d: cell %d holds %d, want %d", round, i, got, want[i])
+			}
+		}
+	}
+	if s.bits != 16 {
+		t.Errorf("%d block types use %d bit indices, want 16", len(s.palette), s.bits)
+	}
+}
+
+func TestBitsForPalette(t *testing.T) {
+	for n, want := range map[int]uint8{1: 0, 2: 1, 3: 2, 4: 2, 5: 4, 16: 4, 17: 8, 256: 8, 257: 16, 4096: 16} {
+		if got := bitsForPalette(n); got != want {
+			t.Errorf("bitsForPalette(%d) = %d, want %d", n, got, want)
+		}
+	}
+}
+
+func TestLightStorageUniform(t *testing.T) {
+	s := lightStorage{uniform: packLight(maxLightLevel, 0)}
+	s.set(5, packLight(maxLightLevel, 0))
+	if s.data != nil {
+		t.Fatal("setting the uniform light allocated per cell data")
+	}
+	s.set(5, packLight(3, 9))
+	if s.get(5) != packLight(3, 9) || s.get(6) != packLight(maxLightLevel, 0) {
+		t.Fatalf("cells hold %#x and %#x", s.get(5), s.get(6))
+	}
+	s.compact()
+	if s.data == nil {
+		t.Fatal("compacted light that isn't uniform")
+	}
+	s.set(5, packLight(maxLightLevel, 0))
+	s.compact()
+	if s.data != nil || s.uniform != packLight(maxLightLevel, 0) {
+		t.Fatalf("uniform light wasn't compacted, uniform %#x", s.uniform)
+	}
+}
+
+/*
+ * Benchmarks against the layout chunks had before the palette: a pointer to a separately allocated Block for every
+ * cell. Both fill the blocks a generator placed in a column of chunks, from the bottom of the world to the sky.
+ */
+
+type pointerChunk struct {
+	blocksData [CHUNK_SIZE][CHUNK_SIZE][CHUNK_SIZE]*Block
+}
+
+func benchmarkColumn(b *testing.B) []*Chunk {
+	b.Helper()
+	generator := newBiomeWorldGenerator(1, worldBiomes)
+	var column []*Chunk
+	for y := GENERATION_BOTTOM_CHUNK_Y; y < GENERATION_BOTTOM_CHUNK_Y+12; y++ {
+		column = append(column, generator.GenerateChunk(ChunkPosition{PillarPos{3, -7}, y}))
+	}
+	return column
+}
+
+func fillPaletteChunk(from *Chunk) *Chunk {
+	ch := newChunk(AirID)
+	for x := range CHUNK_SIZE {
+		for y := range CHUNK_SIZE {
+			for z := range CHUNK_SIZE {
+				ch.setBlock(x, y, z, from.getBlock(x, y, z))
+			}
+		}
+	}
+	ch.compact()
+	return ch
+}
+
+func fillPointerChunk(from *Chunk) *pointerChunk {
+	ch := &pointerChunk{}
+	for x := range CHUNK_SIZE {
+		for y := range CHUNK_SIZE {
+			for z := range CHUNK_SIZE {
+				block := from.getBlock(x, y, z)
+				ch.blocksData[x][y][z] = &block
+			}
+		}
+	}
+	return ch
+}
+
+// heapPerChunk returns how many bytes of heap the chunks fill keeps alive take, on average.
+func heapPerChunk[T any](column []*Chunk, fill func(*Chunk) T) float64 {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	kept := make([]T, 0, len(column))
+	for _, ch := range column {
+		kept = append(kept, fill(ch))
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(kept)
+	return float64(after.HeapAlloc-before.HeapAlloc) / float64(len(column))
+}
+
+func BenchmarkChunkStoragePalette(b *testing.B) {
+	column := benchmarkColumn(b)
+	heap := heapPerChunk(column, fillPaletteChunk)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fillPaletteChunk(column[i%len(column)])
+	}
+	b.ReportMetric(heap, "heap-bytes/chunk")
+}
+
+func BenchmarkChunkStoragePointers(b *testing.B) {
+	column := benchmarkColumn(b)
+	heap := heapPerChunk(column, fillPointerChunk)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fillPointerChunk(column[i%len(column)])
+	}
+	b.ReportMetric(heap, "heap-bytes/chunk")
+}
+
+// BenchmarkGenerateChunk is the whole of generating a chunk into palette storage, for scale.
+func BenchmarkGenerateChunk(b *testing.B) {
+	generator := newBiomeWorldGenerator(1, worldBiomes)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		generator.GenerateChunk(ChunkPosition{PillarPos{int32(i / 12), 0}, GENERATION_BOTTOM_CHUNK_Y + int32(i%12)})
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"math"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+/*
+ * Packed chunk vertex, two uint32 per vertex, decoded in blockShaderVertex.vert:
+ *   word 0: corner x, y, z (5 bits each, 0..16) | face (3 bits) | light level * 16 (8 bits)
+ *   word 1: texture array layer (8 bits) | tint r, g, b (8 bits each)
+ * Every quad is 4 vertices drawn through one index buffer shared by all chunks; texture coordinates
+ * are rebuilt in the shader from the corner position and the face.
+ */
+
+const (
+	vertexWords   = 2               // uint32 per vertex
+	maxChunkQuads = chunkVolume * 6 // every face of every block, more than any real mesh needs
+	quadIndices   = 6               // indices per quad, two triangles
+	lightFraction = 16              // light keeps 4 fractional bits for smooth lighting
+
+	positionBits = 5 // enough for corner 16 of a 16 block chunk
+	positionMask = 1<<positionBits - 1
+	faceShift    = positionBits * 3
+	lightShift   = faceShift + 3
+)
+
+type chunkVertex struct {
+	x, y, z uint8 // block corner, 0..CHUNK_SIZE
+	face    uint8
+	light   uint8 // light level * lightFraction
+	layer   uint8
+	tint    [3]uint8
+}
+
+func (v chunkVertex) pack() [vertexWords]uint32 {
+	position := uint32(v.x)&positionMask | uint32(v.y)&positionMask<<positionBits | uint32(v.z)&positionMask<<(positionBits*2)
+	return [vertexWords]uint32{
+		position | uint32(v.face&7)<<faceShift | uint32(v.light)<<lightShift,
+		uint32(v.layer) | uint32(v.tint[0])<<8 | uint32(v.tint[1])<<16 | uint32(v.tint[2])<<24,
+	}
+}
+
+func unpackChunkVertex(words [vertexWords]uint32) chunkVertex {
+	return chunkVertex{
+		x:     uint8(words[0] & positionMask),
+		y:     uint8(words[0] >> positionBits & positionMask),
+		z:     uint8(words[0] >> (positionBits * 2) & positionMask),
+		face:  uint8(words[0] >> faceShift & 7),
+		light: uint8(words[0] >> lightShift),
+		layer: uint8(words[1]),
+		tint:  [3]uint8{uint8(words[1] >> 8), uint8(words[1] >> 16), uint8(words[1] >> 24)},
+	}
+}
+
+// packLightLevel stores a 0..15 light level with 4 fractional bits.
+func packLightLevel(light float32) uint8 {
+	return uint8(min(max(math.Round(float64(light)*lightFraction), 0), math.MaxUint8))
+}
+
+func packTint(tint mgl32.Vec3) [3]uint8 {
+	var packed [3]uint8
+	for i, c := range tint {
+		packed[i] = uint8(min(max(math.Round(float64(c)*255), 0), 255))
+	}
+	return packed
+}
+
+var quadIndexBuffer uint32
+
+// sharedQuadIndexBuffer returns the element buffer holding 0 1 2 0 2 3 for every quad a chunk can have,
+// creating it on first use. Must be called from the render thread.
+func sharedQuadIndexBuffer() uint32 {
+	if quadIndexBuffer != 0 {
+		return quadIndexBuffer
+	}
+	indices := make([]uint32, 0, maxChunkQuads*quadIndices)
+	for q := range uint32(maxChunkQuads) {
+		base := q * 4
+		indices = append(indices, base, base+1, base+2, base, base+2, base+3)
+	}
+	gl.GenBuffers(1, &quadIndexBuffer)
+	gl.BindBuffer(gl.ELEMENT_ARRAY_BUFFER, quadIndexBuffer)
+	gl.BufferData(gl.ELEMENT_ARRAY_BUFFER, 4*len(indices), gl.Ptr(indices), gl.STATIC_DRAW)
+	return quadIndexBuffer
+}
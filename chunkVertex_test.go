@@ -0,0 +1,58 @@
+package main
+
+import (
+	"math/rand/v2"
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+func TestChunkVertexRoundTrip(t *testing.T) {
+	// Every field at its limits, the highest corner of a chunk at full light and tint included
+	vertices := []chunkVertex{
+		{},
+		{x: CHUNK_SIZE, y: CHUNK_SIZE, z: CHUNK_SIZE, face: 5, light: 15 * lightFraction, layer: 255, tint: [3]uint8{255, 255, 255}},
+		{x: CHUNK_SIZE, y: 0, z: CHUNK_SIZE, face: 0, light: 255, layer: 0, tint: [3]uint8{255, 0, 255}},
+		{x: 0, y: CHUNK_SIZE, z: 0, face: 3, light: 1, layer: 1, tint: [3]uint8{0, 255, 0}},
+	}
+	rng := rand.New(rand.NewPCG(7, 7))
+	for range 10000 {
+		vertices = append(vertices, chunkVertex{
+			x:     uint8(rng.IntN(int(CHUNK_SIZE) + 1)),
+			y:     uint8(rng.IntN(int(CHUNK_SIZE) + 1)),
+			z:     uint8(rng.IntN(int(CHUNK_SIZE) + 1)),
+			face:  uint8(rng.IntN(6)),
+			light: uint8(rng.IntN(256)),
+			layer: uint8(rng.IntN(256)),
+			tint:  [3]uint8{uint8(rng.IntN(256)), uint8(rng.IntN(256)), uint8(rng.IntN(256))},
+		})
+	}
+	for _, v := range vertices {
+		if got := unpackChunkVertex(v.pack()); got != v {
+			t.Fatalf("%+v came back as %+v", v, got)
+		}
+	}
+}
+
+func TestPackLightLevel(t *testing.T) {
+	for light, want := range map[float32]uint8{0: 0, -1: 0, 1: 16, 7.5: 120, 15: 240, 15.9375: 255, 20: 255, 0.03: 0, 0.04: 1} {
+		if got := packLightLevel(light); got != want {
+			t.Errorf("packLightLevel(%v) = %d, want %d", light, got, want)
+		}
+	}
+}
+
+func TestPackTint(t *testing.T) {
+	for _, c := range []struct {
+		tint mgl32.Vec3
+		want [3]uint8
+	}{
+		{noTint, [3]uint8{255, 255, 255}},
+		{mgl32.Vec3{0, 0.5, 1}, [3]uint8{0, 128, 255}},
+		{mgl32.Vec3{-0.2, 1.5, 0.2}, [3]uint8{0, 255, 51}},
+	} {
+		if got := packTint(c.tint); got != c.want {
+			t.Errorf("packTint(%v) = %v, want %v", c.tint, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"container/heap"
+	"runtime"
+	"sync"
+)
+
+/*
+ * Chunk worker pool. Pillar generation and chunk meshing are jobs in one priority queue, nearest to the
+ * camera first, worked by a fixed number of goroutines.
+ * A pillar is only meshed once it is lit and its 4 horizontal neighbors exist, so border faces are culled
+ * correctly on the first try instead of every neighbor being rebuilt as pillars trickle in.
+ * Queued jobs that have left the generation radius are dropped before they run.
+ */
+
+type chunkJobKind uint8
+
+const (
+	generatePillarJob chunkJobKind = iota // load or generate a pillar
+	meshChunkJob                          // build the mesh of one chunk
+)
+
+type chunkJobKey struct {
+	kind  chunkJobKind
+	chunk ChunkPosition // index is unused for pillar jobs
+}
+
+type chunkJob struct {
+	chunkJobKey
+	priority float32 // squared distance to the camera, lower runs first
+	index    int     // position in the heap
+}
+
+type chunkJobHeap []*chunkJob
+
+func (h chunkJobHeap) Len() int           { return len(h) }
+func (h chunkJobHeap) Less(i, j int) bool { return h[i].priority < h[j].priority }
+func (h chunkJobHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *chunkJobHeap) Push(x any) {
+	job := x.(*chunkJob)
+	job.index = len(*h)
+	*h = append(*h, job)
+}
+func (h *chunkJobHeap) Pop() any {
+	old := *h
+	job := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return job
+}
+
+type chunkJobQueue struct {
+	mu      sync.Mutex
+	ready   *sync.Cond
+	jobs    chunkJobHeap
+	pending map[chunkJobKey]*chunkJob // queued jobs, so the same chunk is never queued twice
+}
+
+var chunkJobs = newChunkJobQueue()
+
+func newChunkJobQueue() *chunkJobQueue {
+	q := &chunkJobQueue{pending: make(map[chunkJobKey]*chunkJob)}
+	q.ready = sync.NewCond(&q.mu)
+	return q
+}
+
+// jobPriority is the squared distance from the camera to the pillar or chunk center.
+func jobPriority(key chunkJobKey) float32 {
+	dx := float32(key.chunk.getWorldX()+int32(CHUNK_SIZE)/2) - cameraPosition[0]
+	dz := float32(key.chunk.getWorldZ()+int32(CHUNK_SIZE)/2) - cameraPosition[2]
+	priority := dx*dx + dz*dz
+	if key.kind == meshChunkJob {
+		dy := float32(key.chunk.getWorldY()+int32(CHUNK_SIZE)/2) - cameraPosition[1]
+		priority += dy * dy
+	}
+	return priority
+}
+
+// jobCancelled reports whether a job is no longer worth running for a camera in pillar center.
+func jobCancelled(key chunkJobKey, center PillarPos) bool {
+	pos := key.chunk.pillarPos
+	return max(abs32(pos.x-center.x), abs32(pos.z-center.z)) > GENERATION_DISTANCE_i32
+}
+
+func (q *chunkJobQueue) push(key chunkJobKey) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, queued := q.pending[key]; queued {
+		return // the queued job will read the latest data when it runs
+	}
+	job := &chunkJob{chunkJobKey: key, priority: jobPriority(key)}
+	heap.Push(&q.jobs, job)
+	q.pending[key] = job
+	q.ready.Signal()
+}
+
+// pop blocks until a job is available and returns the nearest one.
+func (q *chunkJobQueue) pop() chunkJobKey {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.jobs) == 0 {
+		q.ready.Wait()
+	}
+	job := heap.Pop(&q.jobs).(*chunkJob)
+	delete(q.pending, job.chunkJobKey)
+	return job.chunkJobKey
+}
+
+// reprioritize re-sorts the queue after the camera moved and drops jobs that left the generation radius.
+func (q *chunkJobQueue) reprioritize(center PillarPos) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	kept := q.jobs[:0]
+	for _, job := range q.jobs {
+		if jobCancelled(job.chunkJobKey, center) {
+			delete(q.pending, job.chunkJobKey)
+			continue
+		}
+		job.priority = jobPriority(job.chunkJobKey)
+		kept = append(kept, job)
+	}
+	for i := len(kept); i < len(q.jobs); i++ {
+		q.jobs[i] = nil
+	}
+	q.jobs = kept
+	for i, job := range q.jobs {
+		job.index = i
+	}
+	heap.Init(&q.jobs)
+}
+
+func chunkWorkerCount() int {
+	return max(1, runtime.NumCPU()-1)
+}
+
+func runChunkWorkers(count int) {
+	for range count {
+		go chunkWorker()
+	}
+}
+
+func chunkWorker() {
+	for {
+		job := chunkJobs.pop()
+		if jobCancelled(job, cameraPillar()) {
+			continue
+		}
+		switch job.kind {
+		case generatePillarJob:
+			if CreatePillar(job.chunk.pillarPos) {
+				streamingStats.loaded.Add(1)
+			}
+		case meshChunkJob:
+			meshChunk(job.chunk)
+		}
+	}
+}
+
+func queuePillarGeneration(pos PillarPos) {
+	chunkJobs.push(chunkJobKey{generatePillarJob, ChunkPosition{pillarPos: pos}})
+}
+
+// meshChunk builds the mesh of a chunk. The mesher reads the chunk and the neighbors around it, which are copied
+// under pillarsMu and meshed without it, so edits and the frame never wait on a mesh.
+func meshChunk(cP ChunkPosition) {
+	pillarsMu.RLock()
+	pl := pillars[cP.pillarPos]
+	if pl == nil || pl.chunk(cP.y) == nil {
+		pillarsMu.RUnlock()
+		return // unloaded in the meantime
+	}
+	world := make(map[PillarPos]*Pillar, 9)
+	for dx := int32(-1); dx <= 1; dx++ {
+		for dz := int32(-1); dz <= 1; dz++ {
+			pos := PillarPos{cP.pillarPos.x + dx, cP.pillarPos.z + dz}
+			if pillar := pillars[pos]; pillar != nil {
+				world[pos] = pillar.window(cP.y)
+			}
+		}
+	}
+	pillarsMu.RUnlock()
+
+	ch := world[cP.pillarPos].chunk(cP.y)
+	var verts, waterVerts *[]uint32
+	if level := uint8(world[cP.pillarPos].lod.Load()); level > 0 {
+		verts, waterVerts = lodMeshChunk(world, ch, cP, level)
+	} else {
+		verts = preProcessChunkVAO(world, ch, cP)
+		waterVerts = waterMeshChunk(world, ch, cP)
+	}
+	mesh := builtMesh{verts, waterVerts, computeConnectivity(ch)}
+
+	dirtyChunksMu.Lock()
+	dirtyChunks[cP] = mesh
+	dirtyChunksMu.Unlock()
+}
+
+// queueReadyPillarMeshes queues the first meshes of pos and its neighbors once they have everything they need.
+func queueReadyPillarMeshes(pos PillarPos) {
+	candidates := []PillarPos{pos}
+	for _, dir := range CardinalDirections[2:] {
+		candidates = append(candidates, PillarPos{pos.x + int32(dir.x), pos.z + int32(dir.z)})
+	}
+
+	for _, p := range candidates {
+		pillarsMu.RLock()
+		pillar := pillars[p]
+		ready := pillar != nil && pillar.lit.Load()
+		for _, dir := range CardinalDirections[2:] {
+			if !ready {
+				break
+			}
+			ready = pillars[PillarPos{p.x + int32(dir.x), p.z + int32(dir.z)}] != nil
+		}
+		pillarsMu.RUnlock()
+
+		if !ready || !pillar.meshReady.CompareAndSwap(false, true) {
+			continue
+		}
+		for i := range pillar.chunks {
+			chunkJobs.push(chunkJobKey{meshChunkJob, ChunkPosition{p, pillar.bottom + int32(i)}})
+		}
+	}
+}
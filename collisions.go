@@ -6,214 +6,196 @@ import (
 	"github.com/go-gl/mathgl/mgl32"
 )
 
-func collisions() {
-	/*
-		isOnGround = false
-
-		playerBox := AABB(
-			cameraPosition.Sub(mgl32.Vec3{PLAYER_WIDTH / 2, 1.5, PLAYER_WIDTH / 2}),
-			cameraPosition.Add(mgl32.Vec3{PLAYER_WIDTH / 2, 0.25, PLAYER_WIDTH / 2}),
-		)
-
-		pIntX, pIntY, pIntZ := int32(cameraPosition[0]), int32(cameraPosition[1]), int32(cameraPosition[2])
-
-		for x := -1; x <= 1; x++ {
-			for z := -1; z <= 1; z++ {
-				for y := -3; y <= 3; y++ {
-					currentPlayerChunkPos := ChunkPosition{int32(math.Floor(float64(cameraPosition[0]/float32(CHUNK_SIZE)))) + int32(x), int32(math.Floor(float64(cameraPosition[1]/float32(CHUNK_SIZE)))) + int32(y), int32(math.Floor(float64(cameraPosition[2]/float32(CHUNK_SIZE)))) + int32(z)}
-
-					chunksMu.RLock()
-					chunk, ok := chunks[currentPlayerChunkPos]
-					chunksMu.RUnlock()
-					if ok {
-						for range 3 {
-							var colliders []collider
-							for blockX := pIntX - 3; blockX < pIntX+3; blockX++ {
-								for blockZ := pIntZ - 3; blockZ < pIntZ+3; blockZ++ {
-									for blockY := pIntY - 3; blockY < pIntY+3; blockY++ {
-
-										relativeBlockPosition := blockPosition{uint8(blockX - (currentPlayerChunkPos.x * int32(CHUNK_SIZE))), uint8(blockY - int32(currentPlayerChunkPos.y*int32(CHUNK_SIZE))), uint8(blockZ - (currentPlayerChunkPos.z * int32(CHUNK_SIZE)))}
-										if relativeBlockPosition.x >= CHUNK_SIZE ||
-											relativeBlockPosition.y >= CHUNK_SIZE ||
-											relativeBlockPosition.z >= CHUNK_SIZE {
-											continue // Skip out-of-bounds blocks
-										}
-										if block := chunk.blocksData[relativeBlockPosition.x][relativeBlockPosition.y][relativeBlockPosition.z]; block.isSolid() {
-
-											floatBlockPos := mgl32.Vec3{float32(relativeBlockPosition.x), float32(relativeBlockPosition.y), float32(relativeBlockPosition.z)}
-											absoluteBlockPosition := mgl32.Vec3{float32(currentPlayerChunkPos.x*int32(CHUNK_SIZE)) + floatBlockPos.X(), float32(currentPlayerChunkPos.y*int32(CHUNK_SIZE)) + floatBlockPos.Y(), float32(currentPlayerChunkPos.z*int32(CHUNK_SIZE)) + floatBlockPos.Z()}
-
-											blockAABB := AABB(
-												absoluteBlockPosition.Sub(mgl32.Vec3{0.5, 0.5, 0.5}),
-												absoluteBlockPosition.Add(mgl32.Vec3{0.5, 0.5, 0.5}),
-											)
-											entry, normal := collide(playerBox, blockAABB)
-
-											if normal == nil {
-												continue
-											}
-
-											colliders = append(colliders, collider{entry, normal})
-										}
-									}
-								}
-							}
-
-							if len(colliders) <= 0 {
-								break
-							}
-							var minEntry float32 = mgl32.InfPos
-							var minNormal []int
-							for _, collider := range colliders {
-								if collider.Time < minEntry {
-									minEntry = collider.Time
-									minNormal = collider.Normal
-								}
-							}
-
-							minEntry -= 0.001
-							if len(minNormal) > 0 {
-								if minNormal[0] != 0 {
-
-									cameraPosition[0] += velocity.X() * minEntry
-									velocity[0] = 0
-								}
-								if minNormal[1] != 0 {
-
-									cameraPosition[1] += velocity.Y() * minEntry
-									velocity[1] = 0
-
-									if minNormal[1] >= 0 {
-										isOnGround = true
-									}
-
-								}
-								if minNormal[2] != 0 {
-
-									cameraPosition[2] += velocity.Z() * minEntry
-									velocity[2] = 0
-								}
-							}
-						}
-
-					}
-				}
-			}
-
-		}
-	*/
-
-}
-func getTime(x float32, y float32) float32 {
-	if y == 0 {
-		if x > 0 {
-			return float32(math.Inf(-1)) // Positive infinity
-		}
-		return float32(math.Inf(1)) // Negative infinity
+/*
+ * Player collisions. The player box is swept through the blocks one axis at a time, Y first: each axis is cut
+ * short at the first block in the way while the others keep their motion, which is what lets the player slide
+ * along walls. A player on the ground steps up onto ledges up to PLAYER_STEP_HEIGHT high, and a crouching one
+ * doesn't walk off edges. Pillars that aren't loaded yet count as solid so nobody falls out of the world while it
+ * streams in.
+ */
+
+// Boxes closer than this count as touching, not overlapping, so float rounding can't wedge the player in a block
+const collisionEpsilon float32 = 1e-3
+
+// collide cuts the velocity of this tick short where it would move the player into a block.
+func (p *playerState) collide(solid func(x, y, z int32) bool) {
+	box := p.box()
+	motion := p.velocity
+	if p.crouching && p.onGround {
+		motion = keepOnEdge(box, motion, crouchEdgeDrop, solid)
 	}
-	return x / y
-}
-func sign(x float32) float32 {
-	if x > 0 {
-		return 1
-	} else if x == 0 {
-		return 0
-	} else {
-		return -1
+	var stepHeight float32
+	if (p.onGround || p.swimming()) && motion[1] <= 0 {
+		stepHeight = PLAYER_STEP_HEIGHT
 	}
-
-}
-func frac0(x float32) float32 {
-	return x - float32(math.Floor(float64(x)))
-}
-func frac1(x float32) float32 {
-	return 1 - x + float32(math.Floor(float64(x)))
+	moved, blocked := stepUpAABB(box, motion, stepHeight, solid)
+	p.settle(motion, moved, blocked)
 }
 
-func IsCollidingWithPlacedBlock(absBlockPos mgl32.Vec3) bool {
-	playerBox := AABB(
-		cameraPosition.Sub(mgl32.Vec3{PLAYER_WIDTH / 2, 1.5, PLAYER_WIDTH / 2}),
-		cameraPosition.Add(mgl32.Vec3{PLAYER_WIDTH / 2, 0.25, PLAYER_WIDTH / 2}),
+// playerAABB returns the box of a player whose eyes are at position, eyeHeight above the feet.
+func playerAABB(position mgl32.Vec3, eyeHeight float32) aabb {
+	return AABB(
+		position.Sub(mgl32.Vec3{PLAYER_WIDTH / 2, eyeHeight, PLAYER_WIDTH / 2}),
+		position.Add(mgl32.Vec3{PLAYER_WIDTH / 2, 0.25, PLAYER_WIDTH / 2}),
 	)
-	blockAABB := AABB(
-		absBlockPos.Sub(mgl32.Vec3{0.5, 0.5, 0.5}),
-		absBlockPos.Add(mgl32.Vec3{0.5, 0.5, 0.5}),
-	)
-	return Intersects(playerBox, blockAABB)
+}
 
+// sweepAABB moves box by motion through the blocks solid reports, axis by axis, returning the motion that is
+// left after every axis was cut short at the first block in its way, and which axes were cut.
+func sweepAABB(box aabb, motion mgl32.Vec3, solid func(x, y, z int32) bool) (mgl32.Vec3, [3]bool) {
+	var blocked [3]bool
+	for _, axis := range [3]int{1, 0, 2} {
+		if motion[axis] == 0 {
+			continue
+		}
+		wanted := motion[axis]
+		motion[axis] = clipAxis(box, axis, wanted, solid)
+		blocked[axis] = motion[axis] != wanted
+		box.Min[axis] += motion[axis]
+		box.Max[axis] += motion[axis]
+	}
+	return motion, blocked
 }
 
-func collide(box1, box2 aabb) (float32, []int) {
-	var xEntry, xExit, yEntry, yExit, zEntry, zExit float32
-	var vx, vy, vz = velocity.X(), velocity.Y(), velocity.Z()
+// stepUpAABB is sweepAABB for a box that can step onto ledges up to stepHeight high. When a wall cuts the
+// horizontal motion short, the move is tried again lifted by stepHeight and then settled back down, and
+// whichever gets further is kept.
+func stepUpAABB(box aabb, motion mgl32.Vec3, stepHeight float32, solid func(x, y, z int32) bool) (mgl32.Vec3, [3]bool) {
+	moved, blocked := sweepAABB(box, motion, solid)
+	if stepHeight <= 0 || !blocked[0] && !blocked[2] {
+		return moved, blocked
+	}
 
-	if vx > 0 {
-		xEntry = getTime(box2.Min.X()-box1.Max.X(), vx)
-		xExit = getTime(box2.Max.X()-box1.Min.X(), vx)
-	} else {
-		xEntry = getTime(box2.Max.X()-box1.Min.X(), vx)
-		xExit = getTime(box2.Min.X()-box1.Max.X(), vx)
+	lift := clipAxis(box, 1, stepHeight, solid)
+	lifted := offsetAABB(box, mgl32.Vec3{0, lift, 0})
+	across, acrossBlocked := sweepAABB(lifted, mgl32.Vec3{motion[0], 0, motion[2]}, solid)
+	settle := min(motion[1], 0) - lift
+	down := clipAxis(offsetAABB(lifted, across), 1, settle, solid)
+	if across[0]*across[0]+across[2]*across[2] <= moved[0]*moved[0]+moved[2]*moved[2] {
+		return moved, blocked
 	}
-	if vy > 0 {
-		yEntry = getTime(box2.Min.Y()-box1.Max.Y(), vy)
-		yExit = getTime(box2.Max.Y()-box1.Min.Y(), vy)
-	} else {
-		yEntry = getTime(box2.Max.Y()-box1.Min.Y(), vy)
-		yExit = getTime(box2.Min.Y()-box1.Max.Y(), vy)
+	return mgl32.Vec3{across[0], lift + down, across[2]}, [3]bool{acrossBlocked[0], down != settle, acrossBlocked[2]}
+}
+
+// keepOnEdge shortens the horizontal motion of a box standing on the ground until there is still ground less
+// than drop below it afterwards, which is how a crouching player is kept from walking off a ledge.
+func keepOnEdge(box aabb, motion mgl32.Vec3, drop float32, solid func(x, y, z int32) bool) mgl32.Vec3 {
+	supported := func(dx, dz float32) bool {
+		return clipAxis(offsetAABB(box, mgl32.Vec3{dx, 0, dz}), 1, -drop, solid) > -drop
 	}
-	if vz > 0 {
-		zEntry = getTime(box2.Min.Z()-box1.Max.Z(), vz)
-		zExit = getTime(box2.Max.Z()-box1.Min.Z(), vz)
-	} else {
-		zEntry = getTime(box2.Max.Z()-box1.Min.Z(), vz)
-		zExit = getTime(box2.Min.Z()-box1.Max.Z(), vz)
+	shorten := func(v float32) float32 {
+		switch {
+		case v > edgeProbeStep:
+			return v - edgeProbeStep
+		case v < -edgeProbeStep:
+			return v + edgeProbeStep
+		}
+		return 0
 	}
 
-	if xEntry < 0 && yEntry < 0 && zEntry < 0 {
-		return float32(1), []int(nil)
+	for motion[0] != 0 && !supported(motion[0], 0) {
+		motion[0] = shorten(motion[0])
+	}
+	for motion[2] != 0 && !supported(0, motion[2]) {
+		motion[2] = shorten(motion[2])
 	}
-	if xEntry > 1 || yEntry > 1 || zEntry > 1 {
-		return float32(1), []int(nil)
+	for motion[0] != 0 && motion[2] != 0 && !supported(motion[0], motion[2]) {
+		motion[0], motion[2] = shorten(motion[0]), shorten(motion[2])
 	}
+	return motion
+}
 
-	entry := float32(math.Max(math.Max(float64(xEntry), float64(yEntry)), float64(zEntry)))
-	exit := float32(math.Min(math.Min(float64(xExit), float64(yExit)), float64(zExit)))
+// boxFits reports whether a box overlaps no solid block.
+func boxFits(box aabb, solid func(x, y, z int32) bool) bool {
+	fits := true
+	forEachBlockIn(box, func(x, y, z int32) {
+		if fits && overlapsAcross(box, blockAABB(x, y, z), -1) && solid(x, y, z) {
+			fits = false
+		}
+	})
+	return fits
+}
 
-	if entry > exit {
-		return float32(1), []int(nil)
+// clipAxis returns how far box can move along axis, up to distance, before it runs into a solid block.
+func clipAxis(box aabb, axis int, distance float32, solid func(x, y, z int32) bool) float32 {
+	// Every block the box touches on its way
+	swept := box
+	if distance > 0 {
+		swept.Max[axis] += distance
+	} else {
+		swept.Min[axis] += distance
 	}
-	//normals
-	nx := 0
-	if entry == xEntry {
-		if vx > 0 {
-			nx = -1
-		} else {
-			nx = 1
+	forEachBlockIn(swept, func(x, y, z int32) {
+		block := blockAABB(x, y, z)
+		if !overlapsAcross(box, block, axis) || !solid(x, y, z) {
+			return
 		}
-	}
+		if distance > 0 && block.Min[axis] >= box.Max[axis]-collisionEpsilon {
+			distance = min(distance, block.Min[axis]-box.Max[axis])
+		} else if distance < 0 && block.Max[axis] <= box.Min[axis]+collisionEpsilon {
+			distance = max(distance, block.Max[axis]-box.Min[axis])
+		}
+	})
+	return distance
+}
 
-	// Equivalent logic for ny
-	ny := 0
-	if entry == yEntry {
-		if vy > 0 {
-			ny = -1
-		} else {
-			ny = 1
+// forEachBlockIn calls block for every block a box touches.
+func forEachBlockIn(box aabb, block func(x, y, z int32)) {
+	for x := blockCoord(box.Min[0]); x <= blockCoord(box.Max[0]); x++ {
+		for y := blockCoord(box.Min[1]); y <= blockCoord(box.Max[1]); y++ {
+			for z := blockCoord(box.Min[2]); z <= blockCoord(box.Max[2]); z++ {
+				block(x, y, z)
+			}
 		}
 	}
+}
 
-	// Equivalent logic for nz
-	nz := 0
-	if entry == zEntry {
-		if vz > 0 {
-			nz = -1
-		} else {
-			nz = 1
+// overlapsAcross reports whether two boxes overlap on the two axes other than axis, or on all three for -1.
+func overlapsAcross(a, b aabb, axis int) bool {
+	for i := range 3 {
+		if i != axis && (a.Max[i] <= b.Min[i]+collisionEpsilon || a.Min[i] >= b.Max[i]-collisionEpsilon) {
+			return false
 		}
 	}
-	return entry, []int{nx, ny, nz}
+	return true
+}
+
+// blockCoord returns the block holding a world coordinate, block centers sit at integer positions.
+func blockCoord(v float32) int32 {
+	return int32(math.Floor(float64(v) + 0.5))
+}
+
+func offsetAABB(box aabb, offset mgl32.Vec3) aabb {
+	return AABB(box.Min.Add(offset), box.Max.Add(offset))
+}
+
+func blockAABB(x, y, z int32) aabb {
+	center := mgl32.Vec3{float32(x), float32(y), float32(z)}
+	return AABB(center.Sub(mgl32.Vec3{0.5, 0.5, 0.5}), center.Add(mgl32.Vec3{0.5, 0.5, 0.5}))
+}
+
+func sign(x float32) float32 {
+	if x > 0 {
+		return 1
+	} else if x == 0 {
+		return 0
+	} else {
+		return -1
+	}
 
 }
+
+// collidesWithPlacedBlock reports whether a block placed at a position would end up inside the player or another
+// solid entity.
+func (es *Entities) collidesWithPlacedBlock(block [3]int32) bool {
+	for _, e := range es.overlapping(blockAABB(block[0], block[1], block[2])) {
+		if e.solid {
+			return true
+		}
+	}
+	return false
+}
+
 func AABB(min, max mgl32.Vec3) aabb {
 	return aabb{Min: min, Max: max}
 }
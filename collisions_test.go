@@ -0,0 +1,191 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// terrain is a hand-built world, loaded everywhere.
+type terrain func(x, y, z int32) uint16
+
+func (t terrain) BlockAt(x, y, z int32) (uint16, bool) {
+	return t(x, y, z), true
+}
+
+// stoneWhere builds terrain that is stone wherever solid says so.
+func stoneWhere(solid func(x, y, z int32) bool) terrain {
+	return func(x, y, z int32) uint16 {
+		if solid(x, y, z) {
+			return StoneID
+		}
+		return AirID
+	}
+}
+
+// walk lands a walking player with its eyes at start and steps it ticks times holding cmd.
+func walk(world BlockReader, start mgl32.Vec3, ticks int, cmd InputCommand) *playerState {
+	sim := NewSimulation(start, defaultEngineSettings())
+	first := cmd
+	first.ToggleFlying = true
+	sim.Queue(first)
+	for range ticks {
+		sim.Step(world)
+	}
+	return sim.player
+}
+
+func assertRests(t *testing.T, name string, p *playerState, eyes mgl32.Vec3) {
+	t.Helper()
+	if !p.onGround {
+		t.Errorf("%s: not on the ground at %v", name, p.position)
+	}
+	for i := range 3 {
+		if mgl32.Abs(p.position[i]-eyes[i]) > 0.002 {
+			t.Errorf("%s: resting at %v, want %v", name, p.position, eyes)
+			return
+		}
+	}
+}
+
+func TestCollisionRestsOnGround(t *testing.T) {
+	// A floor with its top at 10.5, the eyes end up standing 1.5 over it without drifting sideways
+	floor := stoneWhere(func(x, y, z int32) bool { return y <= 10 })
+	assertRests(t, "floor", walk(floor, mgl32.Vec3{0.3, 40, -7.2}, 300, InputCommand{}), mgl32.Vec3{0.3, 12, -7.2})
+
+	// Across negative coordinates and on a floor below zero
+	floor = stoneWhere(func(x, y, z int32) bool { return y <= -20 })
+	assertRests(t, "below zero", walk(floor, mgl32.Vec3{-40.5, -3, -0.5}, 300, InputCommand{}), mgl32.Vec3{-40.5, -18, -0.5})
+
+	// However fast it falls, a box doesn't go through a single layer of blocks
+	thin := func(x, y, z int32) bool { return y == 0 }
+	moved, blocked := sweepAABB(playerAABB(mgl32.Vec3{0, 30, 0}, PLAYER_EYE_HEIGHT), mgl32.Vec3{0, -100, 0}, thin)
+	if !blocked[1] || mgl32.Abs(30+moved[1]-2) > 1e-4 {
+		t.Errorf("falling 100 blocks onto a thin floor moved %v", moved)
+	}
+
+	// Jumping into a ceiling stops the head right under it
+	ceiling := func(x, y, z int32) bool { return y == 4 || y <= 0 }
+	moved, blocked = sweepAABB(playerAABB(mgl32.Vec3{0, 2, 0}, PLAYER_EYE_HEIGHT), mgl32.Vec3{0, 3, 0}, ceiling)
+	if !blocked[1] || mgl32.Abs(2+moved[1]+0.25-3.5) > 1e-4 {
+		t.Errorf("jumping into a ceiling moved %v", moved)
+	}
+}
+
+func TestCollisionSlidesAlongWalls(t *testing.T) {
+	// Walking diagonally into a wall at x 5 stops against it and slides on along z
+	wall := stoneWhere(func(x, y, z int32) bool { return y <= 0 || x == 5 })
+	p := walk(wall, mgl32.Vec3{3, 2, 0}, 200, InputCommand{Forward: 1, Yaw: 45})
+	against := 4.5 - PLAYER_WIDTH/2
+	if !p.onGround || p.position[0] > against || p.position[0] < against-0.01 {
+		t.Errorf("walking into the wall ended at %v", p.position)
+	}
+	if p.position[2] < 5 {
+		t.Errorf("didn't slide along the wall, ended at %v", p.position)
+	}
+
+	// Into a corner it stops against both walls
+	corner := stoneWhere(func(x, y, z int32) bool { return y <= 0 || x == 5 || z == 5 })
+	p = walk(corner, mgl32.Vec3{3, 2, 0}, 200, InputCommand{Forward: 1, Yaw: 45})
+	assertRests(t, "corner", p, mgl32.Vec3{against, 2, against})
+
+	// Along a wall it never touches it is never slowed: the same walk with and without the wall ends alike
+	open := stoneWhere(func(x, y, z int32) bool { return y <= 0 })
+	along := stoneWhere(func(x, y, z int32) bool { return y <= 0 || x == 2 })
+	a := walk(open, mgl32.Vec3{0, 2, 0}, 100, InputCommand{Forward: 1, Yaw: 90})
+	b := walk(along, mgl32.Vec3{0, 2, 0}, 100, InputCommand{Forward: 1, Yaw: 90})
+	if a.position != b.position {
+		t.Errorf("walking past a wall ended at %v, without it at %v", b.position, a.position)
+	}
+}
+
+func TestCollisionLedges(t *testing.T) {
+	// A ledge one block high is stepped onto, a two block high one stops the player
+	step := stoneWhere(func(x, y, z int32) bool { return y <= 0 || (y == 1 && x >= 3) })
+	p := walk(step, mgl32.Vec3{0, 2, 0}, 200, InputCommand{Forward: 1})
+	if !p.onGround || mgl32.Abs(p.position[1]-3) > 0.002 || p.position[0] < 4 {
+		t.Errorf("walking onto a one block ledge ended at %v", p.position)
+	}
+	wall := stoneWhere(func(x, y, z int32) bool { return y <= 0 || (y <= 2 && x >= 3) })
+	p = walk(wall, mgl32.Vec3{0, 2, 0}, 200, InputCommand{Forward: 1})
+	assertRests(t, "two block ledge", p, mgl32.Vec3{2.5 - PLAYER_WIDTH/2, 2, 0})
+
+	// Walking off the edge of a pit falls to its bottom, crouching stops at the edge
+	pit := stoneWhere(func(x, y, z int32) bool { return (y <= 0 && x < 3) || y <= -5 })
+	p = walk(pit, mgl32.Vec3{0, 2, 0}, 200, InputCommand{Forward: 1})
+	if !p.onGround || mgl32.Abs(p.position[1]+3) > 0.002 {
+		t.Errorf("walking into a pit ended at %v", p.position)
+	}
+	p = walk(pit, mgl32.Vec3{0, 2, 0}, 200, InputCommand{Forward: 1, Crouch: true})
+	edge := 2.5 + PLAYER_WIDTH/2
+	if !p.onGround || mgl32.Abs(p.position[1]-(2-PLAYER_EYE_HEIGHT+PLAYER_CROUCH_EYE_HEIGHT)) > 0.002 || p.position[0] >= edge || p.position[0] < edge-0.1 {
+		t.Errorf("crouching to the edge of a pit ended at %v", p.position)
+	}
+}
+
+func TestCollisionAcrossPillars(t *testing.T) {
+	// Stone up to world height 15 in the pillars around 0,0, with their corner at -0.5, -0.5
+	world := groundWorld(1, -1, 0, 1)
+	useWorld(t, world)
+	pillarsMu.RLock()
+	defer pillarsMu.RUnlock()
+
+	// Straddling four pillars, the player lands on all of them at once
+	p := walk(loadedWorld{}, mgl32.Vec3{-0.5, 30, -0.5}, 300, InputCommand{})
+	assertRests(t, "on the corner", p, mgl32.Vec3{-0.5, 17, -0.5})
+
+	// Walking from pillar 0,0 over the ground of pillar 1,0 into the pillars that aren't loaded, which are a wall
+	p = walk(loadedWorld{}, mgl32.Vec3{8, 17, 3}, 600, InputCommand{Forward: 1})
+	assertRests(t, "at the unloaded pillars", p, mgl32.Vec3{31.5 - PLAYER_WIDTH/2, 17, 3})
+
+	// A step dug out across the border between pillars is stepped down into and back out of
+	for x := int32(-3); x <= 3; x++ {
+		world[worldToChunkBlock(x, 15, 8).chunkPos.pillarPos].chunk(0).setBlockType(uint8(floorMod(x, 16)), 15, 8, AirID)
+	}
+	p = walk(loadedWorld{}, mgl32.Vec3{-6, 17, 8}, 60, InputCommand{Forward: 1})
+	if p.position[0] < -0.5 || p.position[0] > 3.5 || mgl32.Abs(p.position[1]-16) > 0.002 {
+		t.Errorf("walking into the trench across the border ended at %v", p.position)
+	}
+	p = walk(loadedWorld{}, mgl32.Vec3{-6, 17, 8}, 200, InputCommand{Forward: 1})
+	if !p.onGround || p.position[0] < 6 || mgl32.Abs(p.position[1]-17) > 0.002 {
+		t.Errorf("walking through the trench across the border ended at %v", p.position)
+	}
+}
+
+func TestCollisionClimbing(t *testing.T) {
+	// No block is climbable yet, so a ladder is made up for the test
+	const ladderID uint16 = 1000
+	BlockProperties[ladderID] = BlockProperty{Name: "Ladder", IsTransparent: true, Friction: 1, Climbable: true}
+	defer delete(BlockProperties, ladderID)
+
+	// A wall at x 4 with something in front of it from the ground up to 8
+	wallWith := func(front uint16) terrain {
+		return func(x, y, z int32) uint16 {
+			switch {
+			case y <= 0 || (x == 4 && y <= 8):
+				return StoneID
+			case x == 3 && y <= 8:
+				return front
+			}
+			return AirID
+		}
+	}
+
+	// Walking into the ladder climbs it, holding still on it sinks slowly, crouching holds on
+	p := walk(wallWith(ladderID), mgl32.Vec3{1, 2, 0.5}, 40, InputCommand{Forward: 1})
+	if !p.surroundings.climbing || p.position[1] < 4 {
+		t.Errorf("walking into a ladder ended at %v", p.position)
+	}
+	p = walk(wallWith(ladderID), mgl32.Vec3{3.5, 6, 0.5}, 10, InputCommand{})
+	if p.position[1] > 6 || p.position[1] < 6-10*climbSpeed-0.01 {
+		t.Errorf("letting go of a ladder fell to %v", p.position)
+	}
+	p = walk(wallWith(ladderID), mgl32.Vec3{3.5, 6, 0.5}, 10, InputCommand{Crouch: true})
+	if p.position[1] < 6-PLAYER_EYE_HEIGHT+PLAYER_CROUCH_EYE_HEIGHT-0.01 {
+		t.Errorf("crouching on a ladder sank to %v", p.position)
+	}
+
+	// Leaves are only stood on, walking into them stops at them
+	p = walk(wallWith(LeavesID), mgl32.Vec3{1, 2, 0.5}, 40, InputCommand{Forward: 1})
+	assertRests(t, "against leaves", p, mgl32.Vec3{2.5 - PLAYER_WIDTH/2, 2, 0.5})
+}
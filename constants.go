@@ -1,18 +1,49 @@
 package main
 
+import "time"
+
 const (
-	SEED             int64   = 1
 	TICK_UPDATE_RATE float32 = float32(1.0 / 30.0)
-	RUNNING_SPEED    float32 = 1.3
-	FLYING_SPEED     float32 = 10
-	WALKING_SPEED    float32 = 2.3
-	JUMP_HEIGHT      float32 = 0.25
 	PLAYER_WIDTH     float32 = 0.9
+	BLOCK_REACH      float32 = 5 // blocks the player can break or place blocks at
+
+	PLAYER_EYE_HEIGHT        float32 = 1.5 // feet to eyes while standing
+	PLAYER_CROUCH_EYE_HEIGHT float32 = 1.2
+	PLAYER_STEP_HEIGHT       float32 = 1 // ledges up to this high are walked onto without jumping
+
+	ENTITY_MAX_SIZE           float32 = 2    // no entity is wider or taller than this
+	ITEM_SIZE                 float32 = 0.25 // dropped items are cubes this big
+	ITEM_LIFETIME_TICKS               = 5 * 60 * 30
+	PROJECTILE_SPEED          float32 = 1 // blocks per tick a projectile is thrown at
+	PROJECTILE_KNOCKBACK      float32 = 0.4
+	PROJECTILE_LIFETIME_TICKS         = 60 * 30
+
+	MOB_CAP                    = 6   // mobs spawn until there are this many around the player
+	MOB_SPAWN_INTERVAL         = 100 // ticks between two rounds of spawning
+	MOB_SPAWN_RADIUS   float32 = 32
+	MOB_DESPAWN_RADIUS float32 = 64
+	PATH_MAX_NODES             = 2000 // blocks a path search explores before giving up
+
+	CHUNK_SIZE     uint8 = 16 // 16^3 block sized chunks
+	CHUNK_SIZE_i32 int32 = 16
+
+	GENERATION_BOTTOM_CHUNK_Y int32 = -2  // chunk Y new pillars are generated down to, digging grows them further down
+	FEATURE_BOTTOM_Y          int32 = -32 // deepest world height of caves, ores and lakes, below it the ground is plain stone
+
+	MAX_CHUNK_UPLOADS_PER_FRAME = 64
+	MAX_PILLAR_UNLOADS_PER_TICK = 4
+	MAX_FLUID_UPDATES_PER_STEP  = 4096
+
+	SEA_LEVEL           int32 = 34 // low ground is filled with water up to this height
+	FLUID_TICK_INTERVAL       = 5  // ticks between two fluid simulation steps
+
+	WORLD_SAVE_DIR     string        = "world"
+	REGION_SIZE        int32         = 32 // 32x32 pillars per region file
+	REGION_SECTOR_SIZE               = 4096
+	AUTOSAVE_INTERVAL  time.Duration = 30 * time.Second
 
-	CHUNK_SIZE          uint8 = 16 // 16^3 block sized chunks
-	CHUNK_SIZE_i32      int32 = 16
-	RENDER_DISTANCE_i32 int32 = 4
-	RENDER_DISTANCE     uint8 = 4
+	ATLAS_TILE_SIZE int32 = 16 // pixels per block texture in the atlas
+	ATLAS_COLUMNS         = 6  // one texture per face on each atlas row
 )
 
 type faceMapStruct struct {
@@ -34,33 +65,155 @@ var FACE_MAP = faceMapStruct{
 }
 
 const (
-	AirID   uint16 = 0
-	DirtID  uint16 = 1
-	GrassID uint16 = 2
-	StoneID uint16 = 3
+	AirID        uint16 = 0
+	DirtID       uint16 = 1
+	GrassID      uint16 = 2
+	StoneID      uint16 = 3
+	SandID       uint16 = 4
+	SnowID       uint16 = 5
+	WaterID      uint16 = 6 // a water source, flowing water has its own block types, see water.go
+	LogID        uint16 = 7
+	LeavesID     uint16 = 8
+	TallGrassID  uint16 = 9
+	FlowerID     uint16 = 10
+	CoalOreID    uint16 = 11
+	IronOreID    uint16 = 12
+	GoldOreID    uint16 = 13
+	DiamondOreID uint16 = 14
+	GravelID     uint16 = 15
+	LavaID       uint16 = 16
+)
+
+// Which biome colour a block texture is multiplied by
+type biomeTint uint8
+
+const (
+	noBiomeTint biomeTint = iota
+	grassBiomeTint
+	foliageBiomeTint
 )
 
 type BlockProperty struct {
+	Name          string
 	IsSolid       bool
 	IsTransparent bool
+	IsPlant       bool  // drawn as two crossed quads instead of a cube
+	LightEmission uint8 // block light level the block gives off, 0 for none
+	Tint          biomeTint
+
+	// How the block moves the player, see playerPhysics.go
+	Friction  float32 // how quickly walking on the block slows you down, 1 for normal ground; for a liquid, its drag
+	Climbable bool    // touching it lets you climb up and down
+	Liquid    bool    // you swim in it instead of walking
 }
 
 var BlockProperties = map[uint16]BlockProperty{
 	AirID: {
+		Name:          "Air",
 		IsSolid:       false,
 		IsTransparent: true,
 	},
 	DirtID: {
+		Name:          "Dirt",
 		IsSolid:       true,
 		IsTransparent: false,
+		Friction:      1,
 	},
 	GrassID: {
+		Name:          "Grass",
 		IsSolid:       true,
 		IsTransparent: false,
+		Tint:          grassBiomeTint,
+		Friction:      1,
 	},
 	StoneID: {
+		Name:          "Stone",
 		IsSolid:       true,
 		IsTransparent: false,
+		Friction:      1,
+	},
+	SandID: {
+		Name:          "Sand",
+		IsSolid:       true,
+		IsTransparent: false,
+		Friction:      1,
+	},
+	SnowID: {
+		Name:          "Snow",
+		IsSolid:       true,
+		IsTransparent: false,
+		Friction:      0.6,
+	},
+	WaterID: {
+		Name:          "Water",
+		IsSolid:       false,
+		IsTransparent: true,
+		Friction:      0.2,
+		Liquid:        true,
+	},
+	LogID: {
+		Name:          "Log",
+		IsSolid:       true,
+		IsTransparent: false,
+		Friction:      1,
+	},
+	LeavesID: {
+		Name:          "Leaves",
+		IsSolid:       true,
+		IsTransparent: true,
+		Tint:          foliageBiomeTint,
+		Friction:      1,
+	},
+	TallGrassID: {
+		Name:          "Tall grass",
+		IsSolid:       false,
+		IsTransparent: true,
+		IsPlant:       true,
+		Tint:          grassBiomeTint,
+	},
+	FlowerID: {
+		Name:          "Flower",
+		IsSolid:       false,
+		IsTransparent: true,
+		IsPlant:       true,
+	},
+	CoalOreID: {
+		Name:          "Coal ore",
+		IsSolid:       true,
+		IsTransparent: false,
+		Friction:      1,
+	},
+	IronOreID: {
+		Name:          "Iron ore",
+		IsSolid:       true,
+		IsTransparent: false,
+		Friction:      1,
+	},
+	GoldOreID: {
+		Name:          "Gold ore",
+		IsSolid:       true,
+		IsTransparent: false,
+		Friction:      1,
+	},
+	DiamondOreID: {
+		Name:          "Diamond ore",
+		IsSolid:       true,
+		IsTransparent: false,
+		Friction:      1,
+	},
+	GravelID: {
+		Name:          "Gravel",
+		IsSolid:       true,
+		IsTransparent: false,
+		Friction:      1,
+	},
+	LavaID: {
+		Name:          "Lava",
+		IsSolid:       false,
+		IsTransparent: false,
+		LightEmission: 15,
+		Friction:      0.5,
+		Liquid:        true,
 	},
 }
 
@@ -70,12 +223,18 @@ var CardinalDirections = []Vec3Int8{
 	{0, 0, 1}, {0, 0, -1}, // Z-axis
 }
 
-var AntiAliasing bool = false
-var Vsync bool = false
-var AmbientOcclusion bool = true
+// Pillar distances around the camera, set from the render distance by setRenderDistance
+var (
+	RENDER_DISTANCE_i32     int32 = 4
+	LOD_DISTANCE_i32        int32 = LOD_DISTANCES[len(LOD_DISTANCES)-1] // far terrain is drawn at reduced detail up to here, see lod.go
+	GENERATION_DISTANCE_i32 int32 = LOD_DISTANCE_i32 + 1                // one extra ring so every rendered pillar has its neighbors
+	UNLOAD_DISTANCE_i32     int32 = GENERATION_DISTANCE_i32 + 2         // pillars further than this are saved and freed
+)
 
-var scale float32 = 30
-var amplitude float32 = 10
+// Features that can be toggled while playing, starting out as the engine settings say
+var AmbientOcclusion bool = true
+var GreedyMeshing bool = true
+var CaveCulling bool = true
 
 var CubeVertices []float32 = []float32{
 
@@ -128,56 +287,6 @@ var CubeVertices []float32 = []float32{
 	-0.5, -0.5, 0.5, // Top-left
 
 }
-var CubeUVs []uint8 = []uint8{
-	// Front face
-	0, 3, // Bottom-left
-	2, 3, // Bottom-right
-	2, 1, // Top-right
-	0, 3, // Bottom-left
-	2, 1, // Top-right
-	0, 1, // Top-left
-
-	// Back face
-	2, 3, // Bottom-left
-	2, 1, // Top-left
-	0, 1, // Top-right
-	2, 3, // Bottom-left
-	0, 1, // Top-right
-	0, 3, // Bottom-right
-
-	// Left face
-	0, 3, // Bottom-left
-	2, 3, // Bottom-right
-	2, 1, // Top-right
-	0, 3, // Bottom-left
-	2, 1, // Top-right
-	0, 1, // Top-left
-
-	// Right face
-	0, 3, // Bottom-left
-	0, 1, // Top-left
-	2, 1, // Top-right
-	0, 3, // Bottom-left
-	2, 1, // Top-right
-	2, 3, // Bottom-right
-
-	// Top face
-	0, 3, // Bottom-left
-	0, 1, // Bottom-right
-	2, 1, // Top-right
-	0, 3, // Bottom-left
-	2, 1, // Top-right
-	2, 3, // Top-left
-
-	// Bottom face
-	0, 3, // Bottom-left
-	2, 3, // Bottom-right
-	2, 1, // Top-right
-	0, 3, // Bottom-left
-	2, 1, // Top-right
-	0, 1, // Top-left
-}
-
 var PreProccessedUVs [][][]float32 = [][][]float32{{{0.0, 0.0, 0.16666666666666666, 0.3333333333333333}, {0.16666666666666666, 0.0, 0.3333333333333333, 0.3333333333333333}, {0.3333333333333333, 0.0, 0.5, 0.3333333333333333}, {0.5, 0.0, 0.6666666666666666, 0.3333333333333333}, {0.6666666666666666, 0.0, 0.8333333333333334, 0.3333333333333333}, {0.8333333333333334, 0.0, 1.0, 0.3333333333333333}}, {{0.0, 0.3333333333333333, 0.16666666666666666, 0.6666666666666666}, {0.16666666666666666, 0.3333333333333333, 0.3333333333333333, 0.6666666666666666}, {0.3333333333333333, 0.3333333333333333, 0.5, 0.6666666666666666}, {0.5, 0.3333333333333333, 0.6666666666666666, 0.6666666666666666}, {0.6666666666666666, 0.3333333333333333, 0.8333333333333334, 0.6666666666666666}, {0.8333333333333334, 0.3333333333333333, 1.0, 0.6666666666666666}}, {{0.0, 0.6666666666666666, 0.16666666666666666, 1.0}, {0.16666666666666666, 0.6666666666666666, 0.3333333333333333, 1.0}, {0.3333333333333333, 0.6666666666666666, 0.5, 1.0}, {0.5, 0.6666666666666666, 0.6666666666666666, 1.0}, {0.6666666666666666, 0.6666666666666666, 0.8333333333333334, 1.0}, {0.8333333333333334, 0.6666666666666666, 1.0, 1.0}}, {{0.0, 1.0, 0.16666666666666666, 1.3333333333333333}, {0.16666666666666666, 1.0, 0.3333333333333333, 1.3333333333333333}, {0.3333333333333333, 1.0, 0.5, 1.3333333333333333}, {0.5, 1.0, 0.6666666666666666, 1.3333333333333333}, {0.6666666666666666, 1.0, 0.8333333333333334, 1.3333333333333333}, {0.8333333333333334, 1.0, 1.0, 1.3333333333333333}}, {{0.0, 1.3333333333333333, 0.16666666666666666, 1.6666666666666667}, {0.16666666666666666, 1.3333333333333333, 0.3333333333333333, 1.6666666666666667}, {0.3333333333333333, 1.3333333333333333, 0.5, 1.6666666666666667}, {0.5, 1.3333333333333333, 0.6666666666666666, 1.6666666666666667}, {0.6666666666666666, 1.3333333333333333, 0.8333333333333334, 1.6666666666666667}, {0.8333333333333334, 1.3333333333333333, 1.0, 1.6666666666666667}}, {{0.0, 1.6666666666666667, 0.16666666666666666, 2.0}, {0.16666666666666666, 1.6666666666666667, 0.3333333333333333, 2.0}, {0.3333333333333333, 1.6666666666666667, 0.5, 2.0}, {0.5, 1.6666666666666667, 0.6666666666666666, 2.0}, {0.6666666666666666, 1.6666666666666667, 0.8333333333333334, 2.0}, {0.8333333333333334,
 	1.6666666666666667, 1.0, 2.0}}, {{0.0, 2.0, 0.16666666666666666, 2.3333333333333335}, {0.16666666666666666, 2.0, 0.3333333333333333, 2.3333333333333335}, {0.3333333333333333, 2.0, 0.5, 2.3333333333333335}, {0.5, 2.0, 0.6666666666666666, 2.3333333333333335}, {0.6666666666666666, 2.0, 0.8333333333333334, 2.3333333333333335}, {0.8333333333333334, 2.0, 1.0,
 	2.3333333333333335}}, {{0.0, 2.3333333333333335, 0.16666666666666666, 2.6666666666666665}, {0.16666666666666666, 2.3333333333333335, 0.3333333333333333, 2.6666666666666665}, {0.3333333333333333, 2.3333333333333335, 0.5, 2.6666666666666665}, {0.5, 2.3333333333333335,
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"sync"
+)
+
+/*
+ * Vegetation decorator: trees, tall grass and flowers. Every random choice comes from a generator seeded with
+ * the world seed and the world position it is made for, so the same seed always grows the same trees no matter
+ * which chunk is generated first.
+ * A chunk grows every tree that can reach into it, including those standing on the ground of neighboring
+ * chunks: their columns are probed through a columnSource instead of being generated, and only the blocks that
+ * land inside the chunk are kept. Both sides of a border grow the same tree, so nothing has to be handed over.
+ * Logs replace leaves and plants, leaves replace plants, so overlapping trees come out the same in any order.
+ */
+
+type TreeKind uint8
+
+const (
+	OakTree       TreeKind = iota // short trunk with a round crown
+	SpruceTree                    // tall trunk with a layered cone of leaves
+	BranchingTree                 // large tree grown from an L-system
+)
+
+type Vegetation struct {
+	TreeChance   float32    // chance of each tree cell growing a tree
+	Trees        []TreeKind // kinds picked from evenly
+	GrassChance  float32    // chance of a grass column growing tall grass
+	FlowerChance float32    // chance of a grass column growing a flower
+}
+
+// canDecorate reports whether a decoration block may overwrite existing.
+func canDecorate(existing, blockType uint16) bool {
+	switch {
+	case existing == AirID || BlockProperties[existing].IsPlant:
+		return true
+	case existing == LeavesID:
+		return blockType == LogID
+	}
+	return false
+}
+
+// placeDecoration writes a decoration block into a chunk if the block there lets it, returning whether it did.
+func placeDecoration(ch *Chunk, pos blockPosition, blockType uint16) bool {
+	if !canDecorate(ch.getBlockType(pos.x, pos.y, pos.z), blockType) {
+		return false
+	}
+	ch.setBlockType(pos.x, pos.y, pos.z, blockType)
+	return true
+}
+
+// decorationWriter places the blocks of decorations that land in one chunk, dropping the rest.
+type decorationWriter struct {
+	ch  *Chunk
+	pos ChunkPosition
+}
+
+func (w *decorationWriter) place(worldX, worldY, worldZ int32, blockType uint16) {
+	if target := worldToChunkBlock(worldX, worldY, worldZ); target.chunkPos == w.pos {
+		placeDecoration(w.ch, target.blockPos, blockType)
+	}
+}
+
+// Salts so the choices made for one position don't repeat each other
+const (
+	treeCellSalt uint64 = iota + 1
+	treeSalt
+	plantSalt
+)
+
+type VegetationDecorator struct {
+	seed     uint64
+	CellSize int32 // trees grow at most once per CellSize x CellSize cell, which keeps trunks apart
+	SeaLevel int32 // nothing grows on ground below it
+	Columns  columnSource
+	recent   surroundingsCache
+}
+
+func NewVegetationDecorator(seed int64, columns columnSource) *VegetationDecorator {
+	return &VegetationDecorator{
+		seed:     uint64(seed),
+		CellSize: 5,
+		SeaLevel: math.MinInt32,
+		Columns:  columns,
+	}
+}
+
+// positionRand returns a random source for a world column (or cell), the same for the same seed and salt.
+func (d *VegetationDecorator) positionRand(x, z int32, salt uint64) *rand.Rand {
+	return rand.New(rand.NewPCG(d.seed, mixSeed(uint64(uint32(x))<<32|uint64(uint32(z)), salt)))
+}
+
+// mixSeed scrambles a position key and a salt into a well spread seed (the splitmix64 finalizer).
+func mixSeed(key, salt uint64) uint64 {
+	h := key ^ salt*0x9E3779B97F4A7C15
+	h ^= h >> 30
+	h *= 0xBF58476D1CE4E5B9
+	h ^= h >> 27
+	h *= 0x94D049BB133111EB
+	h ^= h >> 31
+	return h
+}
+
+// treeAt reports whether a tree grows in the given column, and which kind.
+func (d *VegetationDecorator) treeAt(worldX, worldZ int32, vegetation *Vegetation) (TreeKind, bool) {
+	if len(vegetation.Trees) == 0 {
+		return 0, false
+	}
+	cellX, cellZ := floorDiv(worldX, d.CellSize), floorDiv(worldZ, d.CellSize)
+	rng := d.positionRand(cellX, cellZ, treeCellSalt)
+	if cellX*d.CellSize+rng.Int32N(d.CellSize) != worldX || cellZ*d.CellSize+rng.Int32N(d.CellSize) != worldZ {
+		return 0, false
+	}
+	if rng.Float32() >= vegetation.TreeChance {
+		return 0, false
+	}
+	return vegetation.Trees[rng.IntN(len(vegetation.Trees))], true
+}
+
+// The biggest tree, a branching one with its leaves, stays well within this many blocks over its ground and
+// this many blocks sideways of its trunk
+const (
+	maxTreeHeight = 24
+	maxTreeRadius = 8
+)
+
+func (d *VegetationDecorator) reach(surface int32) int32 {
+	return surface + maxTreeHeight
+}
+
+func (d *VegetationDecorator) spread() int32 {
+	return maxTreeRadius
+}
+
+// The surface heights of the columns whose trees may reach into a pillar, maxTreeRadius on each side of it
+type pillarSurroundings [CHUNK_SIZE + 2*maxTreeRadius][CHUNK_SIZE + 2*maxTreeRadius]int32
+
+// The chunks of a pillar are generated one after the other, so the surroundings of the last few are kept
+const surroundingsCacheSize = 16
+
+type surroundingsCache struct {
+	mu      sync.Mutex
+	pos     [surroundingsCacheSize]PillarPos
+	heights [surroundingsCacheSize]*pillarSurroundings
+	next    int
+}
+
+func (d *VegetationDecorator) surroundings(pos PillarPos) *pillarSurroundings {
+	c := &d.recent
+	c.mu.Lock()
+	for i, heights := range c.heights {
+		if heights != nil && c.pos[i] == pos {
+			c.mu.Unlock()
+			return heights
+		}
+	}
+	c.mu.Unlock()
+
+	heights := new(pillarSurroundings)
+	for x := range int32(len(heights)) {
+		for z := range int32(len(heights)) {
+			heights[x][z] = d.Columns.Height(pos.getWorldX()+x-maxTreeRadius, pos.getWorldZ()+z-maxTreeRadius)
+		}
+	}
+	c.mu.Lock()
+	c.pos[c.next], c.heights[c.next] = pos, heights
+	c.next = (c.next + 1) % surroundingsCacheSize
+	c.mu.Unlock()
+	return heights
+}
+
+func (d *VegetationDecorator) Apply(ch *Chunk, ctx *generationContext) {
+	w := &decorationWriter{ch: ch, pos: ctx.pos}
+	bottom := ctx.pos.getWorldY()
+	top := bottom + CHUNK_SIZE_i32 - 1
+	heights := d.surroundings(ctx.pos.pillarPos)
+
+	for x := range int32(len(heights)) {
+		for z := range int32(len(heights)) {
+			// Everything a column grows sits between the block over its ground and maxTreeHeight above that
+			height := heights[x][z]
+			if height >= top || height+maxTreeHeight < bottom || height < d.SeaLevel {
+				continue
+			}
+			d.decorateColumn(w, ctx.pos.getWorldX()+x-maxTreeRadius, height, ctx.pos.getWorldZ()+z-maxTreeRadius)
+		}
+	}
+}
+
+// decorateColumn grows the tree or plant of the column whose ground is at worldX, height, worldZ.
+func (d *VegetationDecorator) decorateColumn(w *decorationWriter, worldX, height, worldZ int32) {
+	biome, ground := d.Columns.Ground(worldX, worldZ, height)
+	vegetation := &Biomes[biome].Vegetation
+	if ground == GrassID || ground == DirtID || ground == SnowID {
+		if kind, ok := d.treeAt(worldX, worldZ, vegetation); ok {
+			growTree(w, kind, d.positionRand(worldX, worldZ, treeSalt), worldX, height+1, worldZ)
+			return
+		}
+	}
+
+	if ground == GrassID {
+		roll := d.positionRand(worldX, worldZ, plantSalt).Float32()
+		switch {
+		case roll < vegetation.GrassChance:
+			w.place(worldX, height+1, worldZ, TallGrassID)
+		case roll < vegetation.GrassChance+vegetation.FlowerChance:
+			w.place(worldX, height+1, worldZ, FlowerID)
+		}
+	}
+}
+
+// growTree grows a tree whose trunk starts at x, y, z.
+func growTree(w *decorationWriter, kind TreeKind, rng *rand.Rand, x, y, z int32) {
+	switch kind {
+	case OakTree:
+		growOak(w, rng, x, y, z)
+	case SpruceTree:
+		growSpruce(w, rng, x, y, z)
+	case BranchingTree:
+		growBranchingTree(w, rng, x, y, z)
+	}
+}
+
+func growOak(w *decorationWriter, rng *rand.Rand, x, y, z int32) {
+	height := 4 + rng.Int32N(3)
+	top := y + height - 1
+
+	// Two wide layers under the top of the trunk and two narrow ones over it, with randomly trimmed corners
+	for dy := int32(-2); dy <= 1; dy++ {
+		radius := int32(2)
+		if dy >= 0 {
+			radius = 1
+		}
+		for dx := -radius; dx <= radius; dx++ {
+			for dz := -radius; dz <= radius; dz++ {
+				corner := abs32(dx) == radius && abs32(dz) == radius
+				if corner && (dy == 1 || rng.IntN(2) == 0) {
+					continue
+				}
+				w.place(x+dx, top+dy, z+dz, LeavesID)
+			}
+		}
+	}
+
+	for i := range height {
+		w.place(x, y+i, z, LogID)
+	}
+}
+
+func growSpruce(w *decorationWriter, rng *rand.Rand, x, y, z int32) {
+	height := 6 + rng.Int32N(5)
+	top := y + height - 1
+	leafBottom := y + 1 + rng.Int32N(2)
+
+	// The cone widens downwards, every other layer pulled in a step so it looks layered
+	for ly := top + 1; ly >= leafBottom; ly-- {
+		depth := top + 1 - ly
+		radius := min(3, (depth+1)/2)
+		if depth%2 == 1 && radius > 1 {
+			radius--
+		}
+		for dx := -radius; dx <= radius; dx++ {
+			for dz := -radius; dz <= radius; dz++ {
+				if dx*dx+dz*dz <= radius*radius+1 {
+					w.place(x+dx, ly, z+dz, LeavesID)
+				}
+			}
+		}
+	}
+
+	for i := range height {
+		w.place(x, y+i, z, LogID)
+	}
+}
+
+/*
+ * Branching trees come from an L-system. A is a growing tip; each iteration it becomes a piece of trunk and
+ * three branches spread around it. The string is then walked by a turtle:
+ *   F  grow a log segment along the current direction
+ *   &  tilt the direction away from vertical
+ *   /  turn around the trunk by about a third of a circle
+ *   [  ] save and restore the turtle, branches inside get shorter
+ *   A  a tip that stopped growing, it gets a ball of leaves
+ */
+const (
+	branchingAxiom      = "FFA"
+	branchingRule       = "F[&FA]/[&FA]/[&FA]"
+	branchingIterations = 2
+)
+
+func expandLSystem(axiom string, rules map[byte]string, iterations int) string {
+	current := axiom
+	for range iterations {
+		next := make([]byte, 0, len(current)*4)
+		for i := 0; i < len(current); i++ {
+			if rule, ok := rules[current[i]]; ok {
+				next = append(next, rule...)
+			} else {
+				next = append(next, current[i])
+			}
+		}
+		current = string(next)
+	}
+	return current
+}
+
+type turtle struct {
+	x, y, z    float64
+	yaw, pitch float64 // pitch 0 points straight up
+	length     float64
+}
+
+func growBranchingTree(w *decorationWriter, rng *rand.Rand, x, y, z int32) {
+	program := expandLSystem(branchingAxiom, map[byte]string{'A': branchingRule}, branchingIterations)
+
+	var leaves [][3]int32
+	state := turtle{x: float64(x), y: float64(y), z: float64(z), yaw: rng.Float64() * 2 * math.Pi, length: 2 + float64(rng.IntN(2))}
+	var stack []turtle
+	for i := 0; i < len(program); i++ {
+		switch program[i] {
+		case 'F':
+			dx := math.Sin(state.pitch) * math.Cos(state.yaw)
+			dy := math.Cos(state.pitch)
+			dz := math.Sin(state.pitch) * math.Sin(state.yaw)
+			steps := int(math.Ceil(state.length * 2))
+			for s := range steps {
+				t := float64(s) / 2
+				w.place(int32(math.Round(state.x+dx*t)), int32(math.Round(state.y+dy*t)), int32(math.Round(state.z+dz*t)), LogID)
+			}
+			state.x += dx * state.length
+			state.y += dy * state.length
+			state.z += dz * state.length
+		case '&':
+			state.pitch = min(state.pitch+(25+rng.Float64()*20)*math.Pi/180, 75*math.Pi/180)
+		case '/':
+			state.yaw += (120 + (rng.Float64()-0.5)*30) * math.Pi / 180
+		case '[':
+			stack = append(stack, state)
+			state.length *= 0.75
+		case ']':
+			state = stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+		case 'A':
+			leaves = append(leaves, [3]int32{int32(math.Round(state.x)), int32(math.Round(state.y)), int32(math.Round(state.z))})
+		}
+	}
+
+	for _, leaf := range leaves {
+		for dx := int32(-2); dx <= 2; dx++ {
+			for dy := int32(-1); dy <= 2; dy++ {
+				for dz := int32(-2); dz <= 2; dz++ {
+					if dx*dx+dy*dy+dz*dz <= 5 {
+						w.place(leaf[0]+dx, leaf[1]+dy, leaf[2]+dz, LeavesID)
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"math/rand/v2"
+	"testing"
+)
+
+// treeBlocks grows a whole tree with its trunk starting at x, y, z, by growing it again from the same random
+// source into every chunk it may reach as the decorator does, and returns its blocks by world position.
+func treeBlocks(kind TreeKind, newRand func() *rand.Rand, x, y, z int32) map[[3]int32]uint16 {
+	blocks := make(map[[3]int32]uint16)
+	for chunkX := floorDiv(x-maxTreeRadius, CHUNK_SIZE_i32) - 1; chunkX <= floorDiv(x+maxTreeRadius, CHUNK_SIZE_i32)+1; chunkX++ {
+		for chunkZ := floorDiv(z-maxTreeRadius, CHUNK_SIZE_i32) - 1; chunkZ <= floorDiv(z+maxTreeRadius, CHUNK_SIZE_i32)+1; chunkZ++ {
+			for chunkY := chunkYFromWorldY(y) - 1; chunkY <= chunkYFromWorldY(y+maxTreeHeight)+1; chunkY++ {
+				pos := ChunkPosition{PillarPos{chunkX, chunkZ}, chunkY}
+				w := &decorationWriter{ch: newChunk(AirID), pos: pos}
+				growTree(w, kind, newRand(), x, y, z)
+				for bx := range CHUNK_SIZE {
+					for by := range CHUNK_SIZE {
+						for bz := range CHUNK_SIZE {
+							if blockType := w.ch.getBlockType(bx, by, bz); blockType != AirID {
+								blocks[[3]int32{pos.getWorldX() + int32(bx), pos.getWorldY() + int32(by), pos.getWorldZ() + int32(bz)}] = blockType
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	return blocks
+}
+
+func TestTreesStayWithinReach(t *testing.T) {
+	// Trunks right by chunk corners, so every tree is split over several chunks
+	for _, kind := range []TreeKind{OakTree, SpruceTree, BranchingTree} {
+		for seed := range uint64(40) {
+			x, y, z := int32(15), int32(14), int32(-1)
+			blocks := treeBlocks(kind, func() *rand.Rand { return rand.New(rand.NewPCG(seed, seed)) }, x, y, z)
+			if blocks[[3]int32{x, y, z}] != LogID {
+				t.Fatalf("tree kind %d, seed %d: no trunk at its root", kind, seed)
+			}
+			for pos := range blocks {
+				if abs32(pos[0]-x) > maxTreeRadius || abs32(pos[2]-z) > maxTreeRadius || pos[1] < y || pos[1] >= y+maxTreeHeight {
+					t.Fatalf("tree kind %d, seed %d: block at %v is out of reach of a trunk at %d,%d,%d", kind, seed, pos, x, y, z)
+				}
+			}
+		}
+	}
+}
+
+func TestTreesGrowAcrossPillarBorders(t *testing.T) {
+	// Every tree standing near the border between pillars 0,0 and 1,0 is found whole in the generated chunks,
+	// whichever pillar it stands on and whichever is generated first
+	generator := newBiomeWorldGenerator(worldgenSeed, NewBiomeSource(worldgenSeed))
+	vegetation := generator.Decorators[0].(*VegetationDecorator)
+	chunks := make(map[ChunkPosition]*Chunk)
+	generated := func(x, y, z int32) uint16 {
+		pos := worldToChunkBlock(x, y, z)
+		ch := chunks[pos.chunkPos]
+		if ch == nil {
+			// A fresh generator each time, so no chunk can lean on what another one left behind
+			ch = newBiomeWorldGenerator(worldgenSeed, NewBiomeSource(worldgenSeed)).GenerateChunk(pos.chunkPos)
+			chunks[pos.chunkPos] = ch
+		}
+		return ch.getBlockType(pos.blockPos.x, pos.blockPos.y, pos.blockPos.z)
+	}
+
+	trees := 0
+	for x := CHUNK_SIZE_i32 - maxTreeRadius; x < CHUNK_SIZE_i32+maxTreeRadius; x++ {
+		for z := int32(-64); z < 64; z++ {
+			height := generator.Height(x, z)
+			biome, ground := generator.Ground(x, z, height)
+			if height < SEA_LEVEL || (ground != GrassID && ground != DirtID && ground != SnowID) {
+				continue
+			}
+			kind, ok := vegetation.treeAt(x, z, &Biomes[biome].Vegetation)
+			if !ok {
+				continue
+			}
+			trees++
+			if got := generated(x, height, z); got != ground {
+				t.Fatalf("tree at %d,%d stands on %d, the columns said %d", x, z, got, ground)
+			}
+			newRand := func() *rand.Rand { return vegetation.positionRand(x, z, treeSalt) }
+			for pos := range treeBlocks(kind, newRand, x, height+1, z) {
+				if got := generated(pos[0], pos[1], pos[2]); got == AirID || BlockProperties[got].IsPlant {
+					t.Fatalf("block %v of the tree at %d,%d is missing", pos, x, z)
+				}
+			}
+		}
+	}
+	if trees == 0 {
+		t.Fatal("no trees near the border")
+	}
+}
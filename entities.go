@@ -0,0 +1,284 @@
+package main
+
+import (
+	"math"
+	"slices"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+/*
+ * Entities: everything in the world that moves on its own, the player included. An entity is a box with a
+ * position and a velocity plus the components it needs: an entityController that decides what it does every
+ * tick, entityPhysics for falling and bumping into blocks with the same sweep the player uses, and a boxModel to
+ * draw it with. Entities are filed by the pillar they are in so nearby ones can be found without looking at all
+ * of them. They belong to a Simulation and are stepped with it, in the order they were spawned.
+ */
+
+type EntityID uint32
+
+type Entity struct {
+	id        EntityID
+	position  mgl32.Vec3 // the eyes, or the middle for entities that don't look around
+	previous  mgl32.Vec3 // position before the last tick, for interpolation
+	velocity  mgl32.Vec3 // blocks per tick
+	width     float32
+	height    float32
+	eyeHeight float32 // feet to position
+	onGround  bool
+	solid     bool   // blocks can't be placed where it stands
+	age       uint32 // ticks since it spawned
+	lifetime  uint32 // ticks until it despawns, 0 to stay
+	removed   bool
+	pillar    PillarPos // the pillar it is filed under
+
+	// Components, nil where the entity doesn't have one
+	controller entityController
+	physics    *entityPhysics
+	model      *boxModel
+}
+
+// An entityController runs an entity every tick, before its physics and velocity are applied.
+type entityController interface {
+	update(e *Entity, world BlockReader, entities *Entities)
+}
+
+// How the world pushes back on an entity that doesn't handle its own collisions
+type entityPhysics struct {
+	gravity        float32 // blocks per tick, per tick
+	drag           float32 // share of the velocity lost every tick
+	groundFriction float32 // share of the horizontal velocity lost every tick on the ground
+	stepHeight     float32 // ledges up to this high are walked onto
+	maxDrop        float32 // on the ground it doesn't walk off drops deeper than this, 0 for any
+	sticks         bool    // stops dead in the first block it hits, like an arrow
+	stuck          bool
+}
+
+// box returns the box an entity takes up.
+func (e *Entity) box() aabb {
+	return AABB(
+		e.position.Sub(mgl32.Vec3{e.width / 2, e.eyeHeight, e.width / 2}),
+		e.position.Add(mgl32.Vec3{e.width / 2, e.height - e.eyeHeight, e.width / 2}),
+	)
+}
+
+// interpolated returns the entity's position alpha of the way through the last tick.
+func (e *Entity) interpolated(alpha float32) mgl32.Vec3 {
+	return lerp(e.previous, e.position, mgl32.Clamp(alpha, 0, 1))
+}
+
+// move applies gravity and drag, then cuts the velocity short where it would run into a block.
+func (e *Entity) move(world BlockReader) {
+	ph := e.physics
+	if ph.stuck {
+		e.velocity = mgl32.Vec3{}
+		return
+	}
+	e.velocity[1] -= ph.gravity
+	e.velocity = e.velocity.Mul(1 - ph.drag)
+	if e.onGround {
+		e.velocity[0] *= 1 - ph.groundFriction
+		e.velocity[2] *= 1 - ph.groundFriction
+	}
+
+	solid := collidable(world)
+	motion := e.velocity
+	if ph.maxDrop > 0 && e.onGround {
+		motion = keepOnEdge(e.box(), motion, ph.maxDrop, solid)
+	}
+	var stepHeight float32
+	if e.onGround && motion[1] <= 0 {
+		stepHeight = ph.stepHeight
+	}
+	moved, blocked := stepUpAABB(e.box(), motion, stepHeight, solid)
+	if ph.sticks && blocked != [3]bool{} {
+		ph.stuck = true
+	}
+	e.settle(motion, moved, blocked)
+}
+
+// settle takes the result of sweeping motion, the entity's velocity as far as the blocks allow, as its velocity.
+func (e *Entity) settle(motion, moved mgl32.Vec3, blocked [3]bool) {
+	e.onGround = blocked[1] && e.velocity[1] < 0
+	if moved[1] > 0 && motion[1] <= 0 {
+		// Stepped onto a ledge, the lift happens right away instead of being kept as speed
+		e.position[1] += moved[1]
+		moved[1] = 0
+	}
+	e.velocity = moved
+}
+
+// pillarOf returns the pillar a world position is in.
+func pillarOf(position mgl32.Vec3) PillarPos {
+	return PillarPos{
+		int32(math.Floor(float64(position[0]+0.5) / float64(CHUNK_SIZE))),
+		int32(math.Floor(float64(position[2]+0.5) / float64(CHUNK_SIZE))),
+	}
+}
+
+// Entities is every entity of a simulation, filed by pillar.
+type Entities struct {
+	all      []*Entity // in the order they were spawned, which is the order they are stepped in
+	byPillar map[PillarPos][]*Entity
+	nextID   EntityID
+}
+
+func newEntities() *Entities {
+	return &Entities{byPillar: map[PillarPos][]*Entity{}}
+}
+
+// spawn adds an entity to the world and gives it its ID.
+func (es *Entities) spawn(e *Entity) *Entity {
+	es.nextID++
+	e.id = es.nextID
+	e.previous = e.position
+	e.pillar = pillarOf(e.position)
+	es.all = append(es.all, e)
+	es.byPillar[e.pillar] = append(es.byPillar[e.pillar], e)
+	return e
+}
+
+// remove takes an entity out of the world at the end of the tick.
+func (es *Entities) remove(e *Entity) {
+	e.removed = true
+}
+
+// inPillar returns the entities in a pillar.
+func (es *Entities) inPillar(pos PillarPos) []*Entity {
+	return es.byPillar[pos]
+}
+
+// near returns the entities within radius of a position, in the order they were spawned.
+func (es *Entities) near(center mgl32.Vec3, radius float32) []*Entity {
+	var found []*Entity
+	low := pillarOf(center.Sub(mgl32.Vec3{radius, 0, radius}))
+	high := pillarOf(center.Add(mgl32.Vec3{radius, 0, radius}))
+	for x := low.x; x <= high.x; x++ {
+		for z := low.z; z <= high.z; z++ {
+			for _, e := range es.inPillar(PillarPos{x, z}) {
+				if !e.removed && e.position.Sub(center).Len() <= radius {
+					found = append(found, e)
+				}
+			}
+		}
+	}
+	slices.SortFunc(found, func(a, b *Entity) int {
+		return int(a.id) - int(b.id)
+	})
+	return found
+}
+
+// overlapping returns the entities whose box overlaps box.
+func (es *Entities) overlapping(box aabb) []*Entity {
+	center := box.Min.Add(box.Max).Mul(0.5)
+	radius := box.Max.Sub(box.Min).Len()/2 + ENTITY_MAX_SIZE
+	var found []*Entity
+	for _, e := range es.near(center, radius) {
+		if Intersects(e.box(), box) {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+// step moves every entity through one tick. Entities that fall and collide wait where the world isn't loaded.
+func (es *Entities) step(world BlockReader) {
+	for _, e := range es.all {
+		if e.removed {
+			continue
+		}
+		e.previous = e.position
+		if _, loaded := world.BlockAt(blockCoord(e.position[0]), blockCoord(e.position[1]), blockCoord(e.position[2])); e.physics != nil && !loaded {
+			continue // waits for its pillar to stream in
+		}
+		if e.controller != nil {
+			e.controller.update(e, world, es)
+		}
+		if e.physics != nil {
+			e.move(world)
+		}
+		e.position = e.position.Add(e.velocity)
+		e.age++
+		if e.lifetime != 0 && e.age >= e.lifetime {
+			e.removed = true
+		}
+	}
+
+	es.all = slices.DeleteFunc(es.all, func(e *Entity) bool { return e.removed })
+	for pos, filed := range es.byPillar {
+		filed = slices.DeleteFunc(filed, func(e *Entity) bool { return e.removed || pillarOf(e.position) != pos })
+		if len(filed) == 0 {
+			delete(es.byPillar, pos)
+		} else {
+			es.byPillar[pos] = filed
+		}
+	}
+	for _, e := range es.all {
+		if pos := pillarOf(e.position); pos != e.pillar {
+			e.pillar = pos
+			es.byPillar[pos] = append(es.byPillar[pos], e)
+		}
+	}
+}
+
+// newDroppedItem is a block that pops out of the world where it was broken and lies there for a while.
+func newDroppedItem(position mgl32.Vec3, blockType uint16) *Entity {
+	return &Entity{
+		position:  position,
+		velocity:  mgl32.Vec3{0, 0.15, 0},
+		width:     ITEM_SIZE,
+		height:    ITEM_SIZE,
+		eyeHeight: ITEM_SIZE / 2,
+		lifetime:  ITEM_LIFETIME_TICKS,
+		physics:   &entityPhysics{gravity: gravity, drag: 0.02, groundFriction: 0.4},
+		model:     &boxModel{size: mgl32.Vec3{ITEM_SIZE, ITEM_SIZE, ITEM_SIZE}, color: itemColor(blockType), spin: 0.05},
+	}
+}
+
+// newProjectile is something thrown from position along velocity. It sticks in the first block it hits and
+// knocks back the first entity it hits other than its thrower.
+func newProjectile(position, velocity mgl32.Vec3, thrower EntityID) *Entity {
+	const size float32 = 0.15
+	return &Entity{
+		position:   position,
+		velocity:   velocity,
+		width:      size,
+		height:     size,
+		eyeHeight:  size / 2,
+		lifetime:   PROJECTILE_LIFETIME_TICKS,
+		controller: projectile{thrower: thrower},
+		physics:    &entityPhysics{gravity: gravity / 2, drag: 0.01, sticks: true},
+		model:      &boxModel{size: mgl32.Vec3{size, size, size}, color: mgl32.Vec3{0.9, 0.9, 0.95}},
+	}
+}
+
+type projectile struct {
+	thrower EntityID
+}
+
+func (p projectile) update(e *Entity, world BlockReader, entities *Entities) {
+	if e.physics.stuck {
+		return
+	}
+	// Everything the projectile passes through this tick
+	swept := e.box()
+	swept = AABB(
+		mgl32.Vec3{min(swept.Min[0], swept.Min[0]+e.velocity[0]), min(swept.Min[1], swept.Min[1]+e.velocity[1]), min(swept.Min[2], swept.Min[2]+e.velocity[2])},
+		mgl32.Vec3{max(swept.Max[0], swept.Max[0]+e.velocity[0]), max(swept.Max[1], swept.Max[1]+e.velocity[1]), max(swept.Max[2], swept.Max[2]+e.velocity[2])},
+	)
+	for _, hit := range entities.overlapping(swept) {
+		if hit == e || hit.id == p.thrower || !hit.solid {
+			continue
+		}
+		push := mgl32.Vec3{e.velocity[0], 0, e.velocity[2]}
+		if push.Len() > 0 {
+			push = push.Normalize().Mul(PROJECTILE_KNOCKBACK)
+		}
+		hit.velocity = hit.velocity.Add(push.Add(mgl32.Vec3{0, PROJECTILE_KNOCKBACK / 2, 0}))
+		if h, ok := hit.controller.(hurtable); ok {
+			h.hurt(e.position)
+		}
+		entities.remove(e)
+		return
+	}
+}
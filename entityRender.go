@@ -0,0 +1,113 @@
+package main
+
+import (
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// Entity rendering resources
+var (
+	entityVAO     uint32
+	entityVBO     uint32
+	entityProgram uint32
+	entityInit    bool
+)
+
+// A boxModel draws an entity as a single plain colored box around the middle of its collision box.
+type boxModel struct {
+	size  mgl32.Vec3
+	color mgl32.Vec3
+	spin  float32 // radians the box turns around its Y axis every tick
+}
+
+// What dropped blocks look like, blocks that aren't listed drop grey boxes
+var itemColors = map[uint16]mgl32.Vec3{
+	DirtID:       {0.53, 0.38, 0.26},
+	GrassID:      {0.37, 0.62, 0.25},
+	StoneID:      {0.5, 0.5, 0.5},
+	SandID:       {0.86, 0.81, 0.6},
+	SnowID:       {0.95, 0.97, 1},
+	LogID:        {0.4, 0.3, 0.18},
+	LeavesID:     {0.25, 0.5, 0.2},
+	TallGrassID:  {0.4, 0.65, 0.3},
+	FlowerID:     {0.9, 0.3, 0.3},
+	CoalOreID:    {0.3, 0.3, 0.3},
+	IronOreID:    {0.7, 0.6, 0.5},
+	GoldOreID:    {0.9, 0.8, 0.3},
+	DiamondOreID: {0.4, 0.85, 0.85},
+	GravelID:     {0.55, 0.52, 0.5},
+}
+
+func itemColor(blockType uint16) mgl32.Vec3 {
+	if color, ok := itemColors[blockType]; ok {
+		return color
+	}
+	return mgl32.Vec3{0.6, 0.6, 0.6}
+}
+
+// initEntityRendering sets up the cube VAO/VBO and compiles the entity shaders.
+func initEntityRendering() {
+	gl.GenVertexArrays(1, &entityVAO)
+	gl.BindVertexArray(entityVAO)
+
+	gl.GenBuffers(1, &entityVBO)
+	gl.BindBuffer(gl.ARRAY_BUFFER, entityVBO)
+	gl.BufferData(gl.ARRAY_BUFFER, len(cubeVertices)*4, gl.Ptr(cubeVertices), gl.STATIC_DRAW)
+
+	gl.EnableVertexAttribArray(0)
+	gl.VertexAttribPointer(0, 3, gl.FLOAT, false, 3*4, nil)
+
+	gl.BindVertexArray(0)
+	gl.BindBuffer(gl.ARRAY_BUFFER, 0)
+
+	vert := loadShader("shaders/entityShaderVertex.vert", gl.VERTEX_SHADER)
+	frag := loadShader("shaders/entityShaderFragment.frag", gl.FRAGMENT_SHADER)
+	entityProgram = gl.CreateProgram()
+	gl.AttachShader(entityProgram, vert)
+	gl.AttachShader(entityProgram, frag)
+	gl.LinkProgram(entityProgram)
+	gl.DetachShader(entityProgram, vert)
+	gl.DetachShader(entityProgram, frag)
+
+	entityInit = true
+}
+
+// renderEntities draws the box model of every entity in view, alpha of the way through the current tick, and
+// returns how many it drew.
+func renderEntities(entities *Entities, f *frustum, projection, view mgl32.Mat4, alpha float32) int {
+	if !entityInit {
+		initEntityRendering()
+	}
+
+	gl.UseProgram(entityProgram)
+	gl.UniformMatrix4fv(gl.GetUniformLocation(entityProgram, gl.Str("projection\x00")), 1, false, &projection[0])
+	gl.UniformMatrix4fv(gl.GetUniformLocation(entityProgram, gl.Str("view\x00")), 1, false, &view[0])
+	modelLoc := gl.GetUniformLocation(entityProgram, gl.Str("model\x00"))
+	colorLoc := gl.GetUniformLocation(entityProgram, gl.Str("color\x00"))
+
+	gl.Disable(gl.CULL_FACE)
+	gl.BindVertexArray(entityVAO)
+	drawn := 0
+	for _, e := range entities.all {
+		if e.model == nil || e.removed {
+			continue
+		}
+		position := e.interpolated(alpha)
+		center := position.Add(mgl32.Vec3{0, e.height/2 - e.eyeHeight, 0})
+		half := e.model.size.Mul(0.5)
+		if !f.intersectsAABB(AABB(center.Sub(half), center.Add(half))) {
+			continue
+		}
+		turn := e.model.spin * (float32(e.age) + alpha)
+		model := mgl32.Translate3D(center[0], center[1], center[2]).
+			Mul4(mgl32.HomogRotate3DY(turn)).
+			Mul4(mgl32.Scale3D(half[0], half[1], half[2]))
+		gl.UniformMatrix4fv(modelLoc, 1, false, &model[0])
+		gl.Uniform3f(colorLoc, e.model.color[0], e.model.color[1], e.model.color[2])
+		gl.DrawArrays(gl.TRIANGLES, 0, 36)
+		drawn++
+	}
+	gl.BindVertexArray(0)
+	gl.Enable(gl.CULL_FACE)
+	return drawn
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+)
+
+/*
+ * Fluid simulation. Block edits queue the cells around them; every FLUID_TICK_INTERVAL ticks a step re-checks
+ * the queued cells. Sources never change. Air and flowing water take their level from their neighbors: water
+ * right above makes a cell fall at maxFlowLevel, otherwise it gets the highest horizontal neighbor's level
+ * minus one, counting only neighbors that rest on something (water that can fall doesn't spread sideways).
+ * A cell nothing feeds anymore drains to air, so breaking a source empties its stream one level per step.
+ * Every cell of a step is checked against the world as it was when the step started and changes are applied
+ * in a fixed order, so a step gives the same result whatever order the cells were queued in.
+ */
+
+type fluidChange struct {
+	pos     ChunkBlockPositions
+	oldType uint16
+}
+
+type fluidSim struct {
+	mu     sync.Mutex
+	queued map[ChunkBlockPositions]struct{}
+}
+
+var fluids = newFluidSim()
+
+func newFluidSim() *fluidSim {
+	return &fluidSim{queued: make(map[ChunkBlockPositions]struct{})}
+}
+
+var horizontalDirections = CardinalDirections[2:]
+
+// fluidNeighbor steps one block in dir.
+func fluidNeighbor(pos ChunkBlockPositions, dir Vec3Int8) ChunkBlockPositions {
+	chunkPos, blockPos := calculateCrossChunkNeighbor(pos.chunkPos, pos.blockPos.x, pos.blockPos.y, pos.blockPos.z, dir)
+	return ChunkBlockPositions{chunkPos, blockPos}
+}
+
+// fluidChunk returns the chunk at cP for reading, skyChunk above the top of its pillar and nil below its bottom,
+// where the ground isn't generated yet.
+func fluidChunk(world map[PillarPos]*Pillar, cP ChunkPosition) *Chunk {
+	if pillar := world[cP.pillarPos]; pillar != nil {
+		return pillar.chunkOrSky(cP.y)
+	}
+	return nil
+}
+
+// fluidBlockAt returns the block type one step from pos, and false if that cell isn't loaded.
+func fluidBlockAt(world map[PillarPos]*Pillar, pos ChunkBlockPositions, dir Vec3Int8) (uint16, ChunkBlockPositions, bool) {
+	n := fluidNeighbor(pos, dir)
+	ch := fluidChunk(world, n.chunkPos)
+	if ch == nil {
+		return AirID, n, false
+	}
+	return ch.getBlockType(n.blockPos.x, n.blockPos.y, n.blockPos.z), n, true
+}
+
+func canFlowInto(blockType uint16) bool {
+	return blockType == AirID || isFlowingWater(blockType)
+}
+
+// nextFluidState returns the block a cell holding current should turn into this step.
+func nextFluidState(world map[PillarPos]*Pillar, pos ChunkBlockPositions, current uint16) uint16 {
+	if !canFlowInto(current) {
+		return current
+	}
+
+	if above, _, ok := fluidBlockAt(world, pos, Vec3Int8{0, 1, 0}); ok && isWater(above) {
+		return flowingWater(maxFlowLevel)
+	}
+
+	var level uint8
+	for _, dir := range horizontalDirections {
+		blockType, n, ok := fluidBlockAt(world, pos, dir)
+		if !ok || fluidLevel(blockType) <= 1 {
+			continue
+		}
+		if below, _, ok := fluidBlockAt(world, n, Vec3Int8{0, -1, 0}); ok && canFlowInto(below) {
+			continue
+		}
+		level = max(level, fluidLevel(blockType)-1)
+	}
+
+	if level == 0 {
+		return AirID
+	}
+	return flowingWater(level)
+}
+
+// scheduleBlockChange queues every cell whose fluid state depends on the cell at pos: the cell itself, its 6
+// neighbors, and the horizontal neighbors of the cell above, which may spread sideways only while pos is full.
+func (s *fluidSim) scheduleBlockChange(pos ChunkBlockPositions) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.queued[pos] = struct{}{}
+	for _, dir := range CardinalDirections {
+		s.queued[fluidNeighbor(pos, dir)] = struct{}{}
+	}
+	above := fluidNeighbor(pos, Vec3Int8{0, 1, 0})
+	for _, dir := range horizontalDirections {
+		s.queued[fluidNeighbor(above, dir)] = struct{}{}
+	}
+}
+
+func (s *fluidSim) hasWork() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.queued) > 0
+}
+
+func compareCells(a, b ChunkBlockPositions) int {
+	return cmp.Or(
+		cmp.Compare(a.chunkPos.pillarPos.x, b.chunkPos.pillarPos.x),
+		cmp.Compare(a.chunkPos.pillarPos.z, b.chunkPos.pillarPos.z),
+		cmp.Compare(a.chunkPos.y, b.chunkPos.y),
+		cmp.Compare(blockIndex(a.blockPos.x, a.blockPos.y, a.blockPos.z), blockIndex(b.blockPos.x, b.blockPos.y, b.blockPos.z)),
+	)
+}
+
+// step runs one fluid step over world and returns the cells it changed. The caller must hold the world's lock.
+func (s *fluidSim) step(world map[PillarPos]*Pillar) []fluidChange {
+	s.mu.Lock()
+	cells := make([]ChunkBlockPositions, 0, len(s.queued))
+	for pos := range s.queued {
+		cells = append(cells, pos)
+	}
+	slices.SortFunc(cells, compareCells)
+	if len(cells) > MAX_FLUID_UPDATES_PER_STEP {
+		// The rest stays queued for the next step
+		cells = cells[:MAX_FLUID_UPDATES_PER_STEP]
+	}
+	for _, pos := range cells {
+		delete(s.queued, pos)
+	}
+	s.mu.Unlock()
+
+	type update struct {
+		pos       ChunkBlockPositions
+		blockType uint16
+	}
+	var updates []update
+	for _, pos := range cells {
+		ch := fluidChunk(world, pos.chunkPos)
+		if ch == nil {
+			continue // unloaded since it was queued
+		}
+		current := ch.getBlockType(pos.blockPos.x, pos.blockPos.y, pos.blockPos.z)
+		if next := nextFluidState(world, pos, current); next != current {
+			updates = append(updates, update{pos, next})
+		}
+	}
+
+	changes := make([]fluidChange, 0, len(updates))
+	for _, u := range updates {
+		// Water spreading into the open sky gets a chunk to flow into
+		ch := world[u.pos.chunkPos.pillarPos].ensureChunk(u.pos.chunkPos.y)
+		changes = append(changes, fluidChange{u.pos, ch.getBlockType(u.pos.blockPos.x, u.pos.blockPos.y, u.pos.blockPos.z)})
+		ch.setBlockType(u.pos.blockPos.x, u.pos.blockPos.y, u.pos.blockPos.z, u.blockType)
+		world[u.pos.chunkPos.pillarPos].dirty.Store(true)
+		s.scheduleBlockChange(u.pos)
+	}
+	return changes
+}
+
+func runFluidSim() {
+	ticker := time.NewTicker(time.Duration(TICK_UPDATE_RATE * FLUID_TICK_INTERVAL * float32(time.Second)))
+	for range ticker.C {
+		if !fluids.hasWork() {
+			continue
+		}
+
+		pillarsMu.Lock()
+		changes := fluids.step(pillars)
+		pillarsMu.Unlock()
+
+		for _, change := range changes {
+			blockChanged(change.pos, change.oldType)
+		}
+	}
+}
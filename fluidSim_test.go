@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// setFluidBlock edits a block of world and queues the cells around it, as blockChanged does.
+func setFluidBlock(t *testing.T, world map[PillarPos]*Pillar, sim *fluidSim, x, y, z int32, blockType uint16) {
+	t.Helper()
+	pos := worldToChunkBlock(x, y, z)
+	world[pos.chunkPos.pillarPos].ensureChunk(pos.chunkPos.y).setBlockType(pos.blockPos.x, pos.blockPos.y, pos.blockPos.z, blockType)
+	sim.scheduleBlockChange(pos)
+}
+
+func fluidLevelAt(t *testing.T, world map[PillarPos]*Pillar, x, y, z int32) uint8 {
+	t.Helper()
+	pos := worldToChunkBlock(x, y, z)
+	ch := fluidChunk(world, pos.chunkPos)
+	return fluidLevel(ch.getBlockType(pos.blockPos.x, pos.blockPos.y, pos.blockPos.z))
+}
+
+// fluidRow writes the levels along x from x0 to x1 at height y and depth z as one digit each.
+func fluidRow(t *testing.T, world map[PillarPos]*Pillar, x0, x1, y, z int32) string {
+	t.Helper()
+	var row strings.Builder
+	for x := x0; x <= x1; x++ {
+		fmt.Fprint(&row, fluidLevelAt(t, world, x, y, z))
+	}
+	return row.String()
+}
+
+// settle steps the simulation until nothing is queued, failing if it takes more than steps.
+func settle(t *testing.T, world map[PillarPos]*Pillar, sim *fluidSim, steps int) {
+	t.Helper()
+	for range steps {
+		if !sim.hasWork() {
+			return
+		}
+		sim.step(world)
+	}
+	if sim.hasWork() {
+		t.Fatalf("fluids still moving after %d steps", steps)
+	}
+}
+
+func TestFluidSpreadsAndDrains(t *testing.T) {
+	// Flat stone with its surface at -1, the source sits on it two blocks from the border of pillar 0,0
+	world := groundWorld(1, -1, -1, 0)
+	sim := newFluidSim()
+	setFluidBlock(t, world, sim, 2, 0, 8, WaterID)
+
+	// One step spreads one block further, losing a level per block
+	sim.step(world)
+	if got := fluidRow(t, world, -1, 5, 0, 8); got != "0078700" {
+		t.Errorf("after one step: %s", got)
+	}
+	settle(t, world, sim, 20)
+	if got := fluidRow(t, world, -7, 11, 0, 8); got != "0012345678765432100" {
+		t.Errorf("settled: %s", got)
+	}
+	// Levels drop with the walking distance, around corners too
+	if level := fluidLevelAt(t, world, 4, 0, 10); level != 4 {
+		t.Errorf("level %d two blocks off on both axes, want 4", level)
+	}
+	if level := fluidLevelAt(t, world, 2, 1, 8); level != 0 {
+		t.Errorf("water climbed on top of its source")
+	}
+
+	// Without its source the stream sinks from the middle out until it is gone
+	setFluidBlock(t, world, sim, 2, 0, 8, AirID)
+	sim.step(world)
+	if got := fluidRow(t, world, -7, 11, 0, 8); got != "0012345656565432100" {
+		t.Errorf("one step after the source is gone: %s", got)
+	}
+	settle(t, world, sim, 20)
+	for z := int32(-8); z <= 24; z++ {
+		if got := fluidRow(t, world, -16, 31, 0, z); strings.Trim(got, "0") != "" {
+			t.Fatalf("water left at z %d after draining: %s", z, got)
+		}
+	}
+}
+
+func TestFluidFalls(t *testing.T) {
+	// A source on a one block ledge at height 4, over ground whose surface is at -17 so the fall crosses a chunk
+	world := groundWorld(1, -2, -2, 0)
+	sim := newFluidSim()
+	setFluidBlock(t, world, sim, 4, 4, 4, StoneID)
+	setFluidBlock(t, world, sim, 4, 5, 4, WaterID)
+	settle(t, world, sim, 60)
+
+	// It runs off the ledge, falls at full strength and only spreads again at the bottom
+	if got := fluidRow(t, world, 3, 7, 5, 4); got != "78700" {
+		t.Errorf("on the ledge: %s", got)
+	}
+	for y := int32(-16); y <= 4; y++ {
+		if level := fluidLevelAt(t, world, 5, y, 4); level != maxFlowLevel {
+			t.Fatalf("falling water at height %d has level %d", y, level)
+		}
+	}
+	if level := fluidLevelAt(t, world, 4, 3, 4); level != 0 {
+		t.Errorf("water went through the ledge")
+	}
+	if got := fluidRow(t, world, 5, 12, -16, 4); got != "76543210" {
+		t.Errorf("at the bottom: %s", got)
+	}
+
+	// Taking the ledge away lets the source fall straight down, and the old fall drains
+	setFluidBlock(t, world, sim, 4, 4, 4, AirID)
+	settle(t, world, sim, 80)
+	for y := int32(-16); y <= 4; y++ {
+		if level := fluidLevelAt(t, world, 4, y, 4); level != maxFlowLevel {
+			t.Fatalf("water falling from the source at height %d has level %d", y, level)
+		}
+		if level := fluidLevelAt(t, world, 5, y, 4); y > -16 && level != 0 {
+			t.Fatalf("the old fall still has level %d at height %d", level, y)
+		}
+	}
+	if got := fluidRow(t, world, 3, 12, -16, 4); got != "6765432100" {
+		t.Errorf("at the bottom: %s", got)
+	}
+}
+
+func TestFluidStepIsDeterministic(t *testing.T) {
+	// The same edits give the same water whatever order the cells are queued in, map order included
+	var results []string
+	for range 5 {
+		world := groundWorld(1, -1, -1, 0)
+		sim := newFluidSim()
+		setFluidBlock(t, world, sim, 3, 0, 3, WaterID)
+		setFluidBlock(t, world, sim, 12, 0, 5, WaterID)
+		setFluidBlock(t, world, sim, 7, 0, 8, StoneID)
+		for i := range 10 {
+			sim.step(world)
+			if i == 4 {
+				setFluidBlock(t, world, sim, 7, 0, 8, AirID)
+			}
+		}
+		var result strings.Builder
+		for z := int32(-4); z < 20; z++ {
+			result.WriteString(fluidRow(t, world, -4, 19, 0, z))
+		}
+		results = append(results, result.String())
+	}
+	for _, result := range results[1:] {
+		if result != results[0] {
+			t.Fatal("the same edits gave different water")
+		}
+	}
+}
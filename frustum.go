@@ -0,0 +1,65 @@
+package main
+
+import "github.com/go-gl/mathgl/mgl32"
+
+// Frustum culling for chunk draw calls. Pillars are tested first so a pillar behind the camera skips
+// all 64 of its chunk tests.
+
+type plane struct {
+	normal mgl32.Vec3
+	d      float32 // normal·p + d >= 0 for points on the inner side
+}
+
+// left, right, bottom, top, near, far
+type frustum [6]plane
+
+// extractFrustum pulls the 6 clip planes out of a projection * view matrix (Gribb/Hartmann).
+func extractFrustum(viewProjection mgl32.Mat4) frustum {
+	r0, r1, r2, r3 := viewProjection.Row(0), viewProjection.Row(1), viewProjection.Row(2), viewProjection.Row(3)
+	rows := [6]mgl32.Vec4{
+		r3.Add(r0), r3.Sub(r0),
+		r3.Add(r1), r3.Sub(r1),
+		r3.Add(r2), r3.Sub(r2),
+	}
+
+	var f frustum
+	for i, r := range rows {
+		normal := r.Vec3()
+		length := normal.Len()
+		f[i] = plane{normal: normal.Mul(1 / length), d: r[3] / length}
+	}
+	return f
+}
+
+// intersectsAABB reports whether any part of box may be inside the frustum. For each plane it checks the box
+// corner furthest along the plane normal; if even that one is outside, the whole box is.
+func (f *frustum) intersectsAABB(box aabb) bool {
+	for _, p := range f {
+		var corner mgl32.Vec3
+		for axis := range 3 {
+			if p.normal[axis] >= 0 {
+				corner[axis] = box.Max[axis]
+			} else {
+				corner[axis] = box.Min[axis]
+			}
+		}
+		if p.normal.Dot(corner)+p.d < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// chunkAABB is the world space box of a chunk mesh; block centers sit at integer positions.
+func chunkAABB(cP ChunkPosition) aabb {
+	origin := mgl32.Vec3{float32(cP.getWorldX()), float32(cP.getWorldY()), float32(cP.getWorldZ())}
+	far := float32(CHUNK_SIZE) - 0.5
+	return AABB(origin.Sub(mgl32.Vec3{0.5, 0.5, 0.5}), origin.Add(mgl32.Vec3{far, far, far}))
+}
+
+// pillarAABB bounds every chunk of a pillar.
+func pillarAABB(pillar *Pillar) aabb {
+	box := chunkAABB(ChunkPosition{pillar.pos, pillar.bottom})
+	box.Max[1] = chunkAABB(ChunkPosition{pillar.pos, pillar.top()}).Max[1]
+	return box
+}
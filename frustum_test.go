@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+func cube(center mgl32.Vec3, half float32) aabb {
+	return AABB(center.Sub(mgl32.Vec3{half, half, half}), center.Add(mgl32.Vec3{half, half, half}))
+}
+
+func TestExtractFrustumPlanes(t *testing.T) {
+	// An orthographic box from -2..3 on x, -1..1 on y and 1..10 in front of a camera looking along -z
+	f := extractFrustum(mgl32.Ortho(-2, 3, -1, 1, 1, 10))
+	want := frustum{
+		{mgl32.Vec3{1, 0, 0}, 2},
+		{mgl32.Vec3{-1, 0, 0}, 3},
+		{mgl32.Vec3{0, 1, 0}, 1},
+		{mgl32.Vec3{0, -1, 0}, 1},
+		{mgl32.Vec3{0, 0, -1}, -1},
+		{mgl32.Vec3{0, 0, 1}, 10},
+	}
+	for i := range want {
+		if !f[i].normal.ApproxEqualThreshold(want[i].normal, 1e-5) || mgl32.Abs(f[i].d-want[i].d) > 1e-5 {
+			t.Errorf("plane %d is %v, want %v", i, f[i], want[i])
+		}
+	}
+
+	// Perspective planes come out normalized and with the camera position on their inner side, near plane aside
+	view := mgl32.LookAtV(mgl32.Vec3{5, 6, 7}, mgl32.Vec3{5, 6, 0}, mgl32.Vec3{0, 1, 0})
+	f = extractFrustum(mgl32.Perspective(mgl32.DegToRad(70), 16.0/9, 0.1, 350).Mul4(view))
+	for i, p := range f {
+		if l := p.normal.Len(); mgl32.Abs(l-1) > 1e-4 {
+			t.Errorf("plane %d normal has length %v", i, l)
+		}
+		if inside := p.normal.Dot(mgl32.Vec3{5, 6, 7})+p.d >= -1e-3; i != 4 && !inside {
+			t.Errorf("camera is outside plane %d", i)
+		}
+	}
+}
+
+func TestFrustumIntersectsAABB(t *testing.T) {
+	projection := mgl32.Perspective(mgl32.DegToRad(70), 16.0/9, 0.1, 350)
+	for _, c := range []struct {
+		name string
+		eye  mgl32.Vec3
+		look mgl32.Vec3
+		box  aabb
+		want bool
+	}{
+		{"ahead", mgl32.Vec3{}, mgl32.Vec3{0, 0, -1}, cube(mgl32.Vec3{0, 0, -10}, 1), true},
+		{"behind", mgl32.Vec3{}, mgl32.Vec3{0, 0, -1}, cube(mgl32.Vec3{0, 0, 10}, 1), false},
+		{"around the camera", mgl32.Vec3{}, mgl32.Vec3{0, 0, -1}, cube(mgl32.Vec3{}, 1), true},
+		{"off to the side", mgl32.Vec3{}, mgl32.Vec3{0, 0, -1}, cube(mgl32.Vec3{100, 0, -10}, 1), false},
+		{"above", mgl32.Vec3{}, mgl32.Vec3{0, 0, -1}, cube(mgl32.Vec3{0, 50, -10}, 1), false},
+		{"past the far plane", mgl32.Vec3{}, mgl32.Vec3{0, 0, -1}, cube(mgl32.Vec3{0, 0, -400}, 1), false},
+		{"across the far plane", mgl32.Vec3{}, mgl32.Vec3{0, 0, -1}, cube(mgl32.Vec3{0, 0, -350}, 1), true},
+		// Every corner is outside some plane, but not all of them outside the same one
+		{"spanning the view", mgl32.Vec3{}, mgl32.Vec3{0, 0, -1}, AABB(mgl32.Vec3{-1000, -1, -5}, mgl32.Vec3{1000, 1, -4}), true},
+		{"straddling the left plane", mgl32.Vec3{}, mgl32.Vec3{0, 0, -1}, cube(mgl32.Vec3{-13, 0, -10}, 1), true},
+		// Turned to look along +x from somewhere else
+		{"turned ahead", mgl32.Vec3{-20, 3, 40}, mgl32.Vec3{1, 0, 0}, cube(mgl32.Vec3{0, 3, 40}, 2), true},
+		{"turned behind", mgl32.Vec3{-20, 3, 40}, mgl32.Vec3{1, 0, 0}, cube(mgl32.Vec3{-40, 3, 40}, 2), false},
+		{"turned away from -z", mgl32.Vec3{-20, 3, 40}, mgl32.Vec3{1, 0, 0}, cube(mgl32.Vec3{-20, 3, 30}, 2), false},
+	} {
+		view := mgl32.LookAtV(c.eye, c.eye.Add(c.look), mgl32.Vec3{0, 1, 0})
+		f := extractFrustum(projection.Mul4(view))
+		if got := f.intersectsAABB(c.box); got != c.want {
+			t.Errorf("%s: intersectsAABB = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestChunkAndPillarAABB(t *testing.T) {
+	// Block centers sit at integer positions, so the boxes start half a block early
+	box := chunkAABB(ChunkPosition{PillarPos{-1, 2}, -3})
+	if want := AABB(mgl32.Vec3{-16.5, -48.5, 31.5}, mgl32.Vec3{-0.5, -32.5, 47.5}); box != want {
+		t.Errorf("chunk box %v, want %v", box, want)
+	}
+	box = pillarAABB(&Pillar{pos: PillarPos{1, 2}, bottom: -2, chunks: make([]*Chunk, 5)})
+	if want := AABB(mgl32.Vec3{15.5, -32.5, 31.5}, mgl32.Vec3{31.5, 47.5, 47.5}); box != want {
+		t.Errorf("pillar box %v, want %v", box, want)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+/*
+ * Greedy meshing: visible faces are sorted into 16x16 slices per face direction, then each slice is covered
+ * with as few rectangles as possible. Two faces only merge when they share block type, tint and light, and the
+ * light is the same at all 4 corners, so a merged quad interpolates exactly like the faces it replaces.
+ * Textures come from a texture array with repeating UVs, which lets one quad tile over several blocks.
+ */
+
+type greedyFace struct {
+	set       bool
+	blockType uint16
+	tint      mgl32.Vec3
+	light     [4]float32
+}
+
+func (f *greedyFace) mergeable(o *greedyFace) bool {
+	return f.set && o.set && f.blockType == o.blockType && f.tint == o.tint && f.light == o.light &&
+		f.light[0] == f.light[1] && f.light[0] == f.light[2] && f.light[0] == f.light[3]
+}
+
+// Faces by direction, slice along the face normal and the two in plane axes
+type greedyFaces [6][CHUNK_SIZE][CHUNK_SIZE][CHUNK_SIZE]greedyFace
+
+var greedyFacesPool = sync.Pool{
+	New: func() any { return new(greedyFaces) },
+}
+
+// sliceCoords returns the slice index and in plane coordinates of a block for a face direction.
+func sliceCoords(face uint8, key blockPosition) (uint8, uint8, uint8) {
+	switch face {
+	case FACE_MAP.FRONT, FACE_MAP.BACK:
+		return key.z, key.x, key.y
+	case FACE_MAP.LEFT, FACE_MAP.RIGHT:
+		return key.x, key.z, key.y
+	default:
+		return key.y, key.x, key.z
+	}
+}
+
+func sliceBlock(face uint8, slice, a, b uint8) blockPosition {
+	switch face {
+	case FACE_MAP.FRONT, FACE_MAP.BACK:
+		return blockPosition{a, b, slice}
+	case FACE_MAP.LEFT, FACE_MAP.RIGHT:
+		return blockPosition{slice, b, a}
+	default:
+		return blockPosition{a, slice, b}
+	}
+}
+
+func greedyMeshChunk(world map[PillarPos]*Pillar, _Chunk *Chunk, chunkPos ChunkPosition) *[]uint32 {
+	faces := greedyFacesPool.Get().(*greedyFaces)
+	defer greedyFacesPool.Put(faces)
+	*faces = greedyFaces{}
+
+	visibleFaces(world, _Chunk, chunkPos, func(key blockPosition, face uint8, self Block, curTint mgl32.Vec3, cornerLight [4]float32) {
+		slice, a, b := sliceCoords(face, key)
+		faces[face][slice][a][b] = greedyFace{set: true, blockType: self.blockType, tint: curTint, light: cornerLight}
+	})
+
+	verts := mergeGreedyFaces(faces, CHUNK_SIZE, 1)
+	return &verts
+}
+
+// mergeGreedyFaces covers the faces of a size^3 grid of cells, each step blocks wide, with as few quads as possible.
+func mergeGreedyFaces(faces *greedyFaces, size, step uint8) []uint32 {
+	var verts []uint32
+	for face := range uint8(len(faces)) {
+		for slice := range size {
+			mask := &faces[face][slice]
+			for a := range size {
+				for b := uint8(0); b < size; {
+					f := mask[a][b]
+					if !f.set {
+						b++
+						continue
+					}
+
+					// Grow along b first, then extend the whole strip along a
+					height := uint8(1)
+					for b+height < size && f.mergeable(&mask[a][b+height]) {
+						height++
+					}
+					width := uint8(1)
+				grow:
+					for a+width < size {
+						for k := range height {
+							if !f.mergeable(&mask[a+width][b+k]) {
+								break grow
+							}
+						}
+						width++
+					}
+
+					for i := range width {
+						for k := range height {
+							mask[a+i][b+k].set = false
+						}
+					}
+
+					from := sliceBlock(face, slice*step, a*step, b*step)
+					to := sliceBlock(face, slice*step+step-1, (a+width)*step-1, (b+height)*step-1)
+					appendQuad(&verts, face, f.blockType, f.tint, f.light, from, to)
+					b += height
+				}
+			}
+		}
+	}
+	return verts
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"math/rand/v2"
+	"testing"
+)
+
+// quadCoverage counts how often the quads of a mesh cover each unit square of each face direction, keyed by the
+// face, its texture layer and the square's lowest corner.
+func quadCoverage(t *testing.T, verts []uint32) (map[[5]int]int, int) {
+	t.Helper()
+	coverage := make(map[[5]int]int)
+	quadWords := 4 * vertexWords
+	if len(verts)%quadWords != 0 {
+		t.Fatalf("mesh of %d words isn't made of quads", len(verts))
+	}
+	for q := 0; q < len(verts); q += quadWords {
+		low, high := [3]int{99, 99, 99}, [3]int{}
+		first := unpackChunkVertex([vertexWords]uint32{verts[q], verts[q+1]})
+		for corner := range 4 {
+			v := unpackChunkVertex([vertexWords]uint32{verts[q+corner*vertexWords], verts[q+corner*vertexWords+1]})
+			if v.face != first.face || v.layer != first.layer {
+				t.Fatalf("quad %d mixes faces or layers", q/quadWords)
+			}
+			for axis, c := range [3]int{int(v.x), int(v.y), int(v.z)} {
+				low[axis], high[axis] = min(low[axis], c), max(high[axis], c)
+			}
+		}
+		flat := 0
+		for axis := range 3 {
+			if low[axis] == high[axis] {
+				flat++
+				high[axis]++ // a single step along the face's normal
+			}
+		}
+		if flat != 1 {
+			t.Fatalf("quad %d from %v to %v isn't flat against a face", q/quadWords, low, high)
+		}
+		for x := low[0]; x < high[0]; x++ {
+			for y := low[1]; y < high[1]; y++ {
+				for z := low[2]; z < high[2]; z++ {
+					coverage[[5]int{int(first.face), int(first.layer), x, y, z}]++
+				}
+			}
+		}
+	}
+	return coverage, len(verts) / quadWords
+}
+
+func TestGreedyMeshCoversNaiveMesh(t *testing.T) {
+	// Uneven ground of stone, dirt and grass with holes and leaves, under an air chunk and over a stone one
+	world := groundWorld(1, -1, -1, 1)
+	rng := rand.New(rand.NewPCG(6, 6))
+	ch := newChunk(AirID)
+	for x := range CHUNK_SIZE {
+		for z := range CHUNK_SIZE {
+			height := uint8(4 + rng.IntN(4))
+			for y := range height {
+				blockType := StoneID
+				switch {
+				case y == height-1:
+					blockType = GrassID
+				case y > height-3:
+					blockType = DirtID
+				case rng.IntN(10) == 0:
+					blockType = AirID
+				}
+				ch.setBlockType(x, y, z, blockType)
+			}
+			if rng.IntN(12) == 0 {
+				ch.setBlockType(x, height, z, LeavesID)
+			}
+		}
+	}
+	world[PillarPos{0, 0}].chunks[1] = ch
+	for _, pillar := range world {
+		lightPillar(world, pillar)
+	}
+
+	saved := AmbientOcclusion
+	defer func() { AmbientOcclusion = saved }()
+	for _, ao := range []bool{false, true} {
+		AmbientOcclusion = ao
+		naive, naiveQuads := quadCoverage(t, *naiveMeshChunk(world, ch, ChunkPosition{PillarPos{0, 0}, 0}))
+		greedy, greedyQuads := quadCoverage(t, *greedyMeshChunk(world, ch, ChunkPosition{PillarPos{0, 0}, 0}))
+
+		for square, n := range greedy {
+			if n != 1 {
+				t.Fatalf("ambient occlusion %v: greedy quads cover face square %v %d times", ao, square, n)
+			}
+			if naive[square] != 1 {
+				t.Fatalf("ambient occlusion %v: greedy mesh covers face square %v the naive mesh doesn't", ao, square)
+			}
+		}
+		if len(greedy) != len(naive) {
+			t.Fatalf("ambient occlusion %v: greedy mesh covers %d face squares, naive mesh %d", ao, len(greedy), len(naive))
+		}
+		if greedyQuads >= naiveQuads {
+			t.Errorf("ambient occlusion %v: greedy mesh has %d quads, naive mesh %d", ao, greedyQuads, naiveQuads)
+		}
+	}
+}
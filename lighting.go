@@ -0,0 +1,433 @@
+package main
+
+/*
+ * Light engine: sunlight and block light are spread with a BFS over the pillars map.
+ * Both passes take the pillars they work on as a parameter, so they can be run on the global
+ * pillars (with pillarsMu held) or on any other set of pillars without touching OpenGL.
+ */
+
+type lightChannel uint8
+
+const (
+	sunLightChannel lightChannel = iota
+	blockLightChannel
+)
+
+const maxLightLevel uint8 = 15
+
+type lightRemoval struct {
+	pos   ChunkBlockPositions
+	level uint8
+}
+
+// lightWorld wraps a set of pillars for the BFS passes and records every chunk whose light changed.
+type lightWorld struct {
+	pillars   map[PillarPos]*Pillar
+	changed   map[ChunkPosition]struct{}
+	lastPos   ChunkPosition
+	lastChunk *Chunk
+}
+
+func newLightWorld(world map[PillarPos]*Pillar) *lightWorld {
+	return &lightWorld{
+		pillars: world,
+		changed: make(map[ChunkPosition]struct{}),
+	}
+}
+
+func (w *lightWorld) chunk(cP ChunkPosition) *Chunk {
+	if w.lastChunk != nil && w.lastPos == cP {
+		return w.lastChunk
+	}
+	pillar := w.pillars[cP.pillarPos]
+	if pillar == nil {
+		return nil
+	}
+	ch := pillar.chunk(cP.y)
+	if ch != nil {
+		w.lastPos, w.lastChunk = cP, ch
+	}
+	return ch
+}
+
+// neighbor steps one block in dir, returning a nil chunk when the neighbor isn't loaded, is below the pillar or
+// is in the open sky above it. The sky is always in full sunlight, so light never has to spread into it.
+func (w *lightWorld) neighbor(cur ChunkBlockPositions, dir Vec3Int8) (ChunkBlockPositions, *Chunk) {
+	chunkPos, blockPos := calculateCrossChunkNeighbor(cur.chunkPos, cur.blockPos.x, cur.blockPos.y, cur.blockPos.z, dir)
+	n := ChunkBlockPositions{chunkPos, blockPos}
+	return n, w.chunk(chunkPos)
+}
+
+// markChanged records the chunk of pos, plus the chunks it borders, since their meshes sample this cell too.
+func (w *lightWorld) markChanged(pos ChunkBlockPositions) {
+	w.changed[pos.chunkPos] = struct{}{}
+
+	edge := func(v uint8) []int8 {
+		switch v {
+		case 0:
+			return []int8{0, -1}
+		case CHUNK_SIZE - 1:
+			return []int8{0, 1}
+		}
+		return []int8{0}
+	}
+	for _, dx := range edge(pos.blockPos.x) {
+		for _, dy := range edge(pos.blockPos.y) {
+			for _, dz := range edge(pos.blockPos.z) {
+				if dx == 0 && dy == 0 && dz == 0 {
+					continue
+				}
+				neighbor := pos.chunkPos
+				neighbor.pillarPos.x += int32(dx)
+				neighbor.pillarPos.z += int32(dz)
+				neighbor.y += int32(dy)
+				if w.chunk(neighbor) != nil {
+					w.changed[neighbor] = struct{}{}
+				}
+			}
+		}
+	}
+}
+
+func (w *lightWorld) setLight(pos ChunkBlockPositions, ch *Chunk, channel lightChannel, level uint8) {
+	ch.setLight(pos.blockPos, channel, level)
+	w.markChanged(pos)
+}
+
+func (c *Chunk) getLight(pos blockPosition, channel lightChannel) uint8 {
+	if channel == sunLightChannel {
+		return c.getSunLight(pos.x, pos.y, pos.z)
+	}
+	return c.getBlockLight(pos.x, pos.y, pos.z)
+}
+
+func (c *Chunk) setLight(pos blockPosition, channel lightChannel, level uint8) {
+	if channel == sunLightChannel {
+		c.setSunLight(pos.x, pos.y, pos.z, level)
+	} else {
+		c.setBlockLight(pos.x, pos.y, pos.z, level)
+	}
+}
+
+func lightEmission(blockType uint16) uint8 {
+	return BlockProperties[blockType].LightEmission
+}
+
+func isLightTransparent(blockType uint16) bool {
+	return BlockProperties[blockType].IsTransparent
+}
+
+// BFSLightProp spreads light outwards from the given cells, which must already hold their own light level.
+// Full strength sunlight travels straight down without dimming.
+func BFSLightProp(w *lightWorld, queue []ChunkBlockPositions, channel lightChannel) {
+	for head := 0; head < len(queue); head++ {
+		cur := queue[head]
+		ch := w.chunk(cur.chunkPos)
+		if ch == nil {
+			continue
+		}
+
+		level := ch.getLight(cur.blockPos, channel)
+		if level <= 1 {
+			continue
+		}
+
+		for _, dir := range CardinalDirections {
+			n, nch := w.neighbor(cur, dir)
+			if nch == nil || !isLightTransparent(nch.getBlockType(n.blockPos.x, n.blockPos.y, n.blockPos.z)) {
+				continue
+			}
+
+			newLevel := level - 1
+			if channel == sunLightChannel && level == maxLightLevel && dir.y < 0 {
+				newLevel = maxLightLevel
+			}
+			if nch.getLight(n.blockPos, channel) < newLevel {
+				w.setLight(n, nch, channel, newLevel)
+				queue = append(queue, n)
+			}
+		}
+	}
+}
+
+// BFSLightRemoval is the reverse pass: starting from cells already set to zero (with the level they used to have),
+// it clears all light that came from them and returns the brighter cells on the edge, which BFSLightProp refills from.
+func BFSLightRemoval(w *lightWorld, queue []lightRemoval, channel lightChannel) []ChunkBlockPositions {
+	var refill []ChunkBlockPositions
+
+	for head := 0; head < len(queue); head++ {
+		cur := queue[head]
+
+		for _, dir := range CardinalDirections {
+			n, nch := w.neighbor(cur.pos, dir)
+			if nch == nil {
+				continue
+			}
+			neighborLevel := nch.getLight(n.blockPos, channel)
+			if neighborLevel == 0 {
+				continue
+			}
+
+			fromAbove := channel == sunLightChannel && dir.y < 0 && cur.level == maxLightLevel
+			if neighborLevel < cur.level || fromAbove {
+				w.setLight(n, nch, channel, 0)
+				queue = append(queue, lightRemoval{n, neighborLevel})
+
+				// light sources keep shining on their own
+				if channel == blockLightChannel {
+					if emission := lightEmission(nch.getBlockType(n.blockPos.x, n.blockPos.y, n.blockPos.z)); emission > 0 {
+						w.setLight(n, nch, channel, emission)
+						refill = append(refill, n)
+					}
+				}
+			} else {
+				refill = append(refill, n)
+			}
+		}
+	}
+	return refill
+}
+
+// lightPillar computes the light of a freshly generated pillar: the sunlight column pass, block light
+// sources, and light flowing in from or out to the neighboring pillars.
+func lightPillar(world map[PillarPos]*Pillar, pillar *Pillar) map[ChunkPosition]struct{} {
+	w := newLightWorld(world)
+	top := len(pillar.chunks) - 1
+
+	// Sunlight column pass, from the top of the pillar down to the first opaque block
+	for x := range CHUNK_SIZE {
+		for z := range CHUNK_SIZE {
+		column:
+			for ci := top; ci >= 0; ci-- {
+				ch := pillar.chunks[ci]
+				if ch == nil {
+					break
+				}
+				if blockType, ok := ch.isUniform(); ok && isLightTransparent(blockType) {
+					for y := range CHUNK_SIZE {
+						ch.setSunLight(x, y, z, maxLightLevel)
+					}
+					continue
+				}
+				for y := int(CHUNK_SIZE) - 1; y >= 0; y-- {
+					if !isLightTransparent(ch.getBlockType(x, uint8(y), z)) {
+						break column
+					}
+					ch.setSunLight(x, uint8(y), z, maxLightLevel)
+				}
+			}
+		}
+	}
+
+	var sunQueue, blockQueue []ChunkBlockPositions
+	for ci, ch := range pillar.chunks {
+		if ch == nil {
+			continue
+		}
+		ch.light.compact()
+		chunkPos := ChunkPosition{pillar.pos, pillar.bottom + int32(ci)}
+		w.changed[chunkPos] = struct{}{}
+
+		// Open sky chunks surrounded by open sky have nowhere darker to spread to
+		seedSun := !isFullySunlit(ch)
+		for _, dir := range CardinalDirections[2:] {
+			n := w.chunk(ChunkPosition{PillarPos{pillar.pos.x + int32(dir.x), pillar.pos.z + int32(dir.z)}, chunkPos.y})
+			if n != nil && !isFullySunlit(n) {
+				seedSun = true
+			}
+		}
+
+		emitters := false
+		for _, blockType := range ch.blocks.palette {
+			if lightEmission(blockType) > 0 {
+				emitters = true
+			}
+		}
+
+		for x := range CHUNK_SIZE {
+			for y := range CHUNK_SIZE {
+				for z := range CHUNK_SIZE {
+					pos := ChunkBlockPositions{chunkPos, blockPosition{x, y, z}}
+
+					if emitters {
+						if emission := lightEmission(ch.getBlockType(x, y, z)); emission > 0 {
+							ch.setBlockLight(x, y, z, emission)
+							blockQueue = append(blockQueue, pos)
+						}
+					}
+
+					// Only sunlit cells next to a darker open cell need to spread sideways
+					if !seedSun || ch.getSunLight(x, y, z) != maxLightLevel {
+						continue
+					}
+					for _, dir := range CardinalDirections[2:] {
+						n, nch := w.neighbor(pos, dir)
+						if nch != nil && nch.getSunLight(n.blockPos.x, n.blockPos.y, n.blockPos.z) < maxLightLevel-1 &&
+							isLightTransparent(nch.getBlockType(n.blockPos.x, n.blockPos.y, n.blockPos.z)) {
+							sunQueue = append(sunQueue, pos)
+							break
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// Light already present in the neighboring pillars flows in across the shared faces
+	for _, dir := range CardinalDirections[2:] {
+		neighborPos := PillarPos{pillar.pos.x + int32(dir.x), pillar.pos.z + int32(dir.z)}
+		neighbor := world[neighborPos]
+		if neighbor == nil {
+			continue
+		}
+		for ci, ch := range neighbor.chunks {
+			chunkY := neighbor.bottom + int32(ci)
+			if ch == nil || ch.light.data == nil && ch.light.uniform&0xEE == 0 {
+				continue // nothing brighter than 1 to pass on
+			}
+			if own := pillar.chunk(chunkY); own != nil && isFullySunlit(own) && ch.light.data == nil && ch.light.uniform&0x0F <= 1 {
+				continue
+			}
+			for i := range CHUNK_SIZE {
+				for y := range CHUNK_SIZE {
+					x, z := borderColumn(Vec3Int8{-dir.x, 0, -dir.z}, i)
+					pos := ChunkBlockPositions{ChunkPosition{neighborPos, chunkY}, blockPosition{x, y, z}}
+					if ch.getSunLight(x, y, z) > 1 {
+						sunQueue = append(sunQueue, pos)
+					}
+					if ch.getBlockLight(x, y, z) > 1 {
+						blockQueue = append(blockQueue, pos)
+					}
+				}
+			}
+		}
+	}
+
+	// The open sky above the lower of two pillars shines sideways into the other, like the air chunks it
+	// stands for would
+	for _, dir := range CardinalDirections[2:] {
+		neighbor := world[PillarPos{pillar.pos.x + int32(dir.x), pillar.pos.z + int32(dir.z)}]
+		if neighbor == nil {
+			continue
+		}
+		sunQueue = shineSkyInto(w, pillar, neighbor.top()+1, dir, sunQueue)
+		sunQueue = shineSkyInto(w, neighbor, pillar.top()+1, Vec3Int8{-dir.x, 0, -dir.z}, sunQueue)
+	}
+
+	BFSLightProp(w, sunQueue, sunLightChannel)
+	BFSLightProp(w, blockQueue, blockLightChannel)
+	for _, ch := range pillar.chunks {
+		if ch != nil {
+			ch.light.compact()
+		}
+	}
+	return w.changed
+}
+
+// shineSkyInto lights the side of pillar facing side, from chunk Y from up, with the sunlight of the open sky
+// next to it. The cells it lit are added to queue.
+func shineSkyInto(w *lightWorld, pillar *Pillar, from int32, side Vec3Int8, queue []ChunkBlockPositions) []ChunkBlockPositions {
+	for chunkY := max(from, pillar.bottom); chunkY <= pillar.top(); chunkY++ {
+		ch := pillar.chunk(chunkY)
+		if isFullySunlit(ch) {
+			continue
+		}
+		for i := range CHUNK_SIZE {
+			for y := range CHUNK_SIZE {
+				x, z := borderColumn(side, i)
+				if ch.getSunLight(x, y, z) < maxLightLevel-1 && isLightTransparent(ch.getBlockType(x, y, z)) {
+					pos := ChunkBlockPositions{ChunkPosition{pillar.pos, chunkY}, blockPosition{x, y, z}}
+					w.setLight(pos, ch, sunLightChannel, maxLightLevel-1)
+					queue = append(queue, pos)
+				}
+			}
+		}
+	}
+	return queue
+}
+
+// borderColumn returns column i of the chunk side facing the horizontal direction side.
+func borderColumn(side Vec3Int8, i uint8) (x, z uint8) {
+	switch {
+	case side.x > 0:
+		return CHUNK_SIZE - 1, i
+	case side.x < 0:
+		return 0, i
+	case side.z > 0:
+		return i, CHUNK_SIZE - 1
+	}
+	return i, 0
+}
+
+func isFullySunlit(ch *Chunk) bool {
+	return ch.light.data == nil && ch.light.uniform>>4 == maxLightLevel
+}
+
+// skyLight returns the sunlight the open sky around pos shines onto it: full strength from right above, one
+// less from the side, 0 if pos has no sky next to it.
+func (w *lightWorld) skyLight(pos ChunkBlockPositions) uint8 {
+	var level uint8
+	for _, dir := range CardinalDirections {
+		if dir.y < 0 {
+			continue
+		}
+		cP, _ := calculateCrossChunkNeighbor(pos.chunkPos, pos.blockPos.x, pos.blockPos.y, pos.blockPos.z, dir)
+		if pillar := w.pillars[cP.pillarPos]; pillar == nil || cP.y <= pillar.top() {
+			continue
+		}
+		if dir.y > 0 {
+			return maxLightLevel
+		}
+		level = maxLightLevel - 1
+	}
+	return level
+}
+
+// relightBlockChange updates both light channels after the block at pos changed from oldType to its current type.
+func relightBlockChange(world map[PillarPos]*Pillar, pos ChunkBlockPositions, oldType uint16) map[ChunkPosition]struct{} {
+	w := newLightWorld(world)
+	ch := w.chunk(pos.chunkPos)
+	if ch == nil {
+		return w.changed
+	}
+	w.markChanged(pos)
+
+	newType := ch.getBlockType(pos.blockPos.x, pos.blockPos.y, pos.blockPos.z)
+	transparent := isLightTransparent(newType)
+
+	for _, channel := range []lightChannel{sunLightChannel, blockLightChannel} {
+		var refill []ChunkBlockPositions
+
+		oldLevel := ch.getLight(pos.blockPos, channel)
+		lostSource := channel == blockLightChannel && lightEmission(oldType) > 0
+		if oldLevel > 0 && (!transparent || lostSource) {
+			w.setLight(pos, ch, channel, 0)
+			refill = BFSLightRemoval(w, []lightRemoval{{pos, oldLevel}}, channel)
+		}
+
+		if channel == blockLightChannel {
+			if emission := lightEmission(newType); emission > 0 {
+				w.setLight(pos, ch, channel, emission)
+				refill = append(refill, pos)
+			}
+		}
+
+		// An opened up cell is filled from its neighbors
+		if transparent {
+			for _, dir := range CardinalDirections {
+				if n, nch := w.neighbor(pos, dir); nch != nil && nch.getLight(n.blockPos, channel) > 0 {
+					refill = append(refill, n)
+				}
+			}
+			if channel == sunLightChannel {
+				if sky := w.skyLight(pos); sky > ch.getLight(pos.blockPos, channel) {
+					w.setLight(pos, ch, channel, sky)
+					refill = append(refill, pos)
+				}
+			}
+		}
+
+		BFSLightProp(w, refill, channel)
+	}
+	return w.changed
+}
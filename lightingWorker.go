@@ -0,0 +1,157 @@
+package main
+
+import "sync"
+
+/*
+ * Lighting runs on its own goroutine. Jobs are queued by pillar generation and block edits; the worker copies
+ * the 3x3 pillars around the job (a snapshot), lights the copy without holding any lock, and then commits the
+ * new light back under a short write lock. Jobs run one at a time in the order they were queued, and every block
+ * edit queues its job only once the block is in, so a block edited while a job lights its snapshot always has a
+ * job of its own waiting behind it. Such a job's light is committed all the same: the edit's job then relights
+ * around the block as if the two jobs had run one after the other on the live world. Only chunks that were
+ * replaced or unloaded since the snapshot keep their light, the pillar that replaced them is lit by a job of its
+ * own.
+ */
+
+type lightJobKind uint8
+
+const (
+	lightPillarJob lightJobKind = iota // light a freshly generated pillar
+	lightBlockJob                      // relight around a single edited block
+)
+
+type lightJob struct {
+	kind    lightJobKind
+	pillar  PillarPos
+	block   ChunkBlockPositions
+	oldType uint16
+}
+
+var lightJobs []lightJob
+var lightJobsMu sync.Mutex
+var lightJobsReady = make(chan struct{}, 1)
+
+func queueLightJob(job lightJob) {
+	lightJobsMu.Lock()
+	lightJobs = append(lightJobs, job)
+	lightJobsMu.Unlock()
+
+	select {
+	case lightJobsReady <- struct{}{}:
+	default:
+	}
+}
+
+func nextLightJob() (lightJob, bool) {
+	lightJobsMu.Lock()
+	defer lightJobsMu.Unlock()
+	if len(lightJobs) == 0 {
+		return lightJob{}, false
+	}
+	job := lightJobs[0]
+	lightJobs = lightJobs[1:]
+	return job, true
+}
+
+func runLightingWorker() {
+	for range lightJobsReady {
+		for {
+			job, ok := nextLightJob()
+			if !ok {
+				break
+			}
+			processLightJob(job)
+		}
+	}
+}
+
+func processLightJob(job lightJob) {
+	center := job.pillar
+	if job.kind == lightBlockJob {
+		center = job.block.chunkPos.pillarPos
+	}
+
+	pillarsMu.RLock()
+	snapshot := takeLightSnapshot(center)
+	pillarsMu.RUnlock()
+	if snapshot == nil {
+		return // unloaded in the meantime
+	}
+
+	var changed map[ChunkPosition]struct{}
+	switch job.kind {
+	case lightPillarJob:
+		changed = lightPillar(snapshot.pillars, snapshot.pillars[center])
+	case lightBlockJob:
+		changed = relightBlockChange(snapshot.pillars, job.block, job.oldType)
+	}
+
+	pillarsMu.Lock()
+	changed = snapshot.commit(changed)
+	pillarsMu.Unlock()
+
+	if job.kind == lightPillarJob {
+		pillarsMu.RLock()
+		if pillar := pillars[center]; pillar != nil {
+			pillar.lit.Store(true)
+		}
+		pillarsMu.RUnlock()
+	}
+
+	for cP := range changed {
+		queueChunkRebuild(cP)
+	}
+
+	if job.kind == lightPillarJob {
+		queueReadyPillarMeshes(center)
+	}
+}
+
+type lightSnapshot struct {
+	pillars map[PillarPos]*Pillar    // copies the light passes work on
+	live    map[ChunkPosition]*Chunk // the loaded chunk each copy was taken from
+}
+
+// takeLightSnapshot copies the pillars around center, or returns nil if center isn't loaded. The caller must hold
+// pillarsMu.
+func takeLightSnapshot(center PillarPos) *lightSnapshot {
+	s := &lightSnapshot{
+		pillars: make(map[PillarPos]*Pillar),
+		live:    make(map[ChunkPosition]*Chunk),
+	}
+
+	if pillars[center] == nil {
+		return nil
+	}
+	for dx := int32(-1); dx <= 1; dx++ {
+		for dz := int32(-1); dz <= 1; dz++ {
+			pos := PillarPos{center.x + dx, center.z + dz}
+			pillar := pillars[pos]
+			if pillar == nil {
+				continue
+			}
+			for i, ch := range pillar.chunks {
+				s.live[ChunkPosition{pos, pillar.bottom + int32(i)}] = ch
+			}
+			s.pillars[pos] = pillar.snapshot()
+		}
+	}
+	return s
+}
+
+// commit writes the computed light of the changed chunks back into the loaded ones and returns the chunks it
+// wrote, which leaves out those that were replaced or unloaded since the snapshot was taken. The caller must hold
+// pillarsMu for writing.
+func (s *lightSnapshot) commit(changed map[ChunkPosition]struct{}) map[ChunkPosition]struct{} {
+	written := make(map[ChunkPosition]struct{}, len(changed))
+	for cP := range changed {
+		pillar := pillars[cP.pillarPos]
+		if pillar == nil || pillar.chunk(cP.y) != s.live[cP] {
+			continue
+		}
+		pillar.chunk(cP.y).light = s.pillars[cP.pillarPos].chunk(cP.y).light
+		pillar.dirty.Store(true)
+		written[cP] = struct{}{}
+	}
+	return written
+}
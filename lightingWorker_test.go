@@ -0,0 +1,60 @@
+package main
+
+import "testing"
+
+func TestLightCommitMergesEditedChunks(t *testing.T) {
+	useWorld(t, groundWorld(1, -1, 0, 1))
+	lightLoadedWorld(t)
+
+	// Light a hole dug at the surface on a snapshot, then fill it in again before the commit
+	hole := ChunkBlockPositions{ChunkPosition{PillarPos{0, 0}, 0}, blockPosition{5, 15, 5}}
+	pillarsMu.Lock()
+	pillars[PillarPos{0, 0}].chunk(0).setBlockType(5, 15, 5, AirID)
+	snapshot := takeLightSnapshot(PillarPos{0, 0})
+	pillarsMu.Unlock()
+	changed := relightBlockChange(snapshot.pillars, hole, StoneID)
+
+	setTestBlock(t, 5, 15, 5, StoneID)
+	pillarsMu.Lock()
+	committed := snapshot.commit(changed)
+	pillarsMu.Unlock()
+	if _, ok := committed[hole.chunkPos]; !ok {
+		t.Fatal("the light of an edited chunk wasn't committed")
+	}
+
+	// The job the edit queued relights the filled hole as if it had come after the commit
+	runLightJobs()
+	if b := testBlock(t, 5, 15, 5); b.sunLight != 0 {
+		t.Fatalf("the refilled hole has sunlight %d", b.sunLight)
+	}
+	if b := testBlock(t, 5, 16, 5); b.sunLight != maxLightLevel {
+		t.Fatalf("the air over the refilled hole has sunlight %d", b.sunLight)
+	}
+}
+
+func TestLightCommitSkipsReplacedChunks(t *testing.T) {
+	useWorld(t, groundWorld(1, -1, 0, 1))
+	lightLoadedWorld(t)
+
+	// Light a hole on a snapshot, then unload and reload the pillar around it before the commit
+	hole := ChunkBlockPositions{ChunkPosition{PillarPos{0, 0}, 0}, blockPosition{5, 15, 5}}
+	pillarsMu.Lock()
+	pillars[PillarPos{0, 0}].chunk(0).setBlockType(5, 15, 5, AirID)
+	snapshot := takeLightSnapshot(PillarPos{0, 0})
+	pillarsMu.Unlock()
+	changed := relightBlockChange(snapshot.pillars, hole, StoneID)
+
+	pillarsMu.Lock()
+	reloaded := groundPillar(PillarPos{0, 0}, -1, 0, 1)
+	pillars[PillarPos{0, 0}] = reloaded
+	committed := snapshot.commit(changed)
+	pillarsMu.Unlock()
+	for cP := range committed {
+		if cP.pillarPos == (PillarPos{0, 0}) {
+			t.Fatalf("light was committed into the replaced chunk %v", cP)
+		}
+	}
+	if b := testBlock(t, 5, 15, 5); b.sunLight != 0 {
+		t.Fatalf("the reloaded pillar got sunlight %d from the old one", b.sunLight)
+	}
+}
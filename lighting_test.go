@@ -0,0 +1,150 @@
+package main
+
+import "testing"
+
+/*
+ * The light passes run on hand-built pillar maps here, the way the lighting worker runs them on its snapshots.
+ */
+
+// litGroundWorld is groundWorld with every pillar lit.
+func litGroundWorld(radius, bottom, ground, top int32) map[PillarPos]*Pillar {
+	world := groundWorld(radius, bottom, ground, top)
+	for _, pillar := range world {
+		lightPillar(world, pillar)
+	}
+	return world
+}
+
+// blockIn returns the block at a world position of a pillar map.
+func blockIn(t *testing.T, world map[PillarPos]*Pillar, x, y, z int32) Block {
+	t.Helper()
+	pos := worldToChunkBlock(x, y, z)
+	ch := world[pos.chunkPos.pillarPos].chunkOrSky(pos.chunkPos.y)
+	if ch == nil {
+		t.Fatalf("block %d,%d,%d isn't in the world", x, y, z)
+	}
+	return ch.getBlock(pos.blockPos.x, pos.blockPos.y, pos.blockPos.z)
+}
+
+// setBlockIn changes the block at a world position of a pillar map and relights around it.
+func setBlockIn(t *testing.T, world map[PillarPos]*Pillar, x, y, z int32, blockType uint16) {
+	t.Helper()
+	pos := worldToChunkBlock(x, y, z)
+	ch := world[pos.chunkPos.pillarPos].chunk(pos.chunkPos.y)
+	if ch == nil {
+		t.Fatalf("block %d,%d,%d isn't in the world", x, y, z)
+	}
+	oldType := ch.getBlockType(pos.blockPos.x, pos.blockPos.y, pos.blockPos.z)
+	ch.setBlockType(pos.blockPos.x, pos.blockPos.y, pos.blockPos.z, blockType)
+	relightBlockChange(world, pos, oldType)
+}
+
+func assertLight(t *testing.T, world map[PillarPos]*Pillar, x, y, z int32, channel lightChannel, want uint8) {
+	t.Helper()
+	b := blockIn(t, world, x, y, z)
+	got, name := b.sunLight, "sunlight"
+	if channel == blockLightChannel {
+		got, name = b.blockLight, "block light"
+	}
+	if got != want {
+		t.Errorf("block %d,%d,%d has %s %d, want %d", x, y, z, name, got, want)
+	}
+}
+
+func TestLightPillarSunlight(t *testing.T) {
+	// The ground's top is at y 15, the air chunk above it and the open sky over that are sunlit
+	world := litGroundWorld(1, -2, 0, 1)
+	assertLight(t, world, 3, 16, 3, sunLightChannel, maxLightLevel)
+	assertLight(t, world, 3, 31, 3, sunLightChannel, maxLightLevel)
+	assertLight(t, world, 3, 15, 3, sunLightChannel, 0)
+	assertLight(t, world, 3, -20, 3, sunLightChannel, 0)
+}
+
+func TestLightShaftAcrossChunks(t *testing.T) {
+	world := litGroundWorld(1, -2, 0, 1)
+
+	// A 1x1 shaft from the surface down through two chunk borders
+	for y := int32(15); y >= -20; y-- {
+		setBlockIn(t, world, 5, y, 5, AirID)
+	}
+	for _, y := range []int32{15, 0, -1, -16, -17, -20} {
+		assertLight(t, world, 5, y, 5, sunLightChannel, maxLightLevel)
+	}
+	// The walls stay dark, the shaft doesn't let light into solid blocks
+	assertLight(t, world, 6, -20, 5, sunLightChannel, 0)
+
+	// Sideways it dims by one a block
+	for x := int32(6); x <= 9; x++ {
+		setBlockIn(t, world, x, -20, 5, AirID)
+	}
+	for x := int32(6); x <= 9; x++ {
+		assertLight(t, world, x, -20, 5, sunLightChannel, maxLightLevel-uint8(x-5))
+	}
+}
+
+func TestLightAcrossPillarBorder(t *testing.T) {
+	world := litGroundWorld(1, -2, 0, 1)
+
+	// A shaft in pillar 0,0 and a tunnel from it into pillar 1,0, which only ever sees the light through the tunnel
+	for y := int32(15); y >= 10; y-- {
+		setBlockIn(t, world, 13, y, 5, AirID)
+	}
+	for x := int32(14); x <= 20; x++ {
+		setBlockIn(t, world, x, 10, 5, AirID)
+	}
+	for x := int32(14); x <= 20; x++ {
+		assertLight(t, world, x, 10, 5, sunLightChannel, maxLightLevel-uint8(x-13))
+	}
+
+	// Lit again from scratch the other way around, the shaft's light spreads into the pillar lit before it
+	for _, pos := range []PillarPos{{1, 0}, {0, 0}} {
+		for _, ch := range world[pos].chunks {
+			ch.light = lightStorage{}
+		}
+		lightPillar(world, world[pos])
+	}
+	assertLight(t, world, 16, 10, 5, sunLightChannel, maxLightLevel-3)
+	assertLight(t, world, 20, 10, 5, sunLightChannel, maxLightLevel-7)
+}
+
+func TestLightRemoval(t *testing.T) {
+	world := litGroundWorld(1, -2, 0, 1)
+	for y := int32(15); y >= 0; y-- {
+		setBlockIn(t, world, 14, y, 5, AirID)
+	}
+	for x := int32(15); x <= 19; x++ {
+		setBlockIn(t, world, x, 0, 5, AirID)
+	}
+	assertLight(t, world, 19, 0, 5, sunLightChannel, maxLightLevel-5)
+
+	// Covering the shaft takes the sunlight out of it and out of the tunnel in the next pillar
+	setBlockIn(t, world, 14, 15, 5, StoneID)
+	for y := int32(14); y >= 0; y-- {
+		assertLight(t, world, 14, y, 5, sunLightChannel, 0)
+	}
+	for x := int32(15); x <= 19; x++ {
+		assertLight(t, world, x, 0, 5, sunLightChannel, 0)
+	}
+
+	// Lava at the end of the tunnel lights it back the other way, across the pillar border
+	setBlockIn(t, world, 20, 0, 5, LavaID)
+	assertLight(t, world, 20, 0, 5, blockLightChannel, 15)
+	for x := int32(14); x <= 19; x++ {
+		assertLight(t, world, x, 0, 5, blockLightChannel, 15-uint8(20-x))
+	}
+	assertLight(t, world, 14, 5, 5, blockLightChannel, 15-6-5)
+
+	// Taking the lava away takes its light with it
+	setBlockIn(t, world, 20, 0, 5, StoneID)
+	for x := int32(14); x <= 20; x++ {
+		assertLight(t, world, x, 0, 5, blockLightChannel, 0)
+	}
+	for y := int32(1); y < 15; y++ {
+		assertLight(t, world, 14, y, 5, blockLightChannel, 0)
+	}
+
+	// And uncovering the shaft brings the sunlight back
+	setBlockIn(t, world, 14, 15, 5, AirID)
+	assertLight(t, world, 14, 0, 5, sunLightChannel, maxLightLevel)
+	assertLight(t, world, 19, 0, 5, sunLightChannel, maxLightLevel-5)
+}
@@ -0,0 +1,208 @@
+package main
+
+/*
+ * Level of detail for far terrain. Pillars past RENDER_DISTANCE_i32 are meshed on a coarser grid: level 1
+ * merges 2x2x2 blocks into one cell, level 2 4x4x4 and level 3 8x8x8. A cell is solid when most of its
+ * blocks are, and shows the top-most solid block so grass stays on top. An open cell that is mostly water is a
+ * water cell, meshed into the translucent pass like full detail water.
+ * Where two pillars of different levels meet the coarse faces don't line up, so faces on that border are
+ * always emitted on both sides, full detail pillars included, which hangs a skirt down the edge and hides the crack.
+ * Coarse pillars are still generated, lit, kept and saved in full, only their meshes are smaller. Each coarser
+ * level reaches the ring width further, so the generation distance grows by three ring widths: at render
+ * distance 4 that is 11x11 pillars without coarse rings, 17x17 at the default width of 1 and 35x35 at width 4,
+ * at about 20 KiB of blocks and light for a generated pillar before its meshes.
+ */
+
+// Furthest pillar distance meshed at each level
+var LOD_DISTANCES = lodDistances(RENDER_DISTANCE_i32, 1)
+
+// Every coarser level reaches ringWidth pillars further than the one before
+func lodDistances(renderDistance, ringWidth int32) [4]int32 {
+	return [4]int32{renderDistance, renderDistance + ringWidth, renderDistance + 2*ringWidth, renderDistance + 3*ringWidth}
+}
+
+// setRenderDistance moves the full detail, level of detail, generation and unload distances together.
+func setRenderDistance(renderDistance, ringWidth int32) {
+	RENDER_DISTANCE_i32 = renderDistance
+	LOD_DISTANCES = lodDistances(renderDistance, ringWidth)
+	LOD_DISTANCE_i32 = LOD_DISTANCES[len(LOD_DISTANCES)-1]
+	GENERATION_DISTANCE_i32 = LOD_DISTANCE_i32 + 1
+	UNLOAD_DISTANCE_i32 = GENERATION_DISTANCE_i32 + 2
+}
+
+func lodLevel(pos, center PillarPos) uint8 {
+	distance := max(abs32(pos.x-center.x), abs32(pos.z-center.z))
+	for level, furthest := range LOD_DISTANCES {
+		if distance <= furthest {
+			return uint8(level)
+		}
+	}
+	// Past the last ring, the coarsest level that has a ring at all
+	coarsest := 0
+	for level := 1; level < len(LOD_DISTANCES); level++ {
+		if LOD_DISTANCES[level] > LOD_DISTANCES[level-1] {
+			coarsest = level
+		}
+	}
+	return uint8(coarsest)
+}
+
+type lodCell struct {
+	blockType uint16 // AirID or WaterID for open cells
+	light     uint8  // brightest open block in the cell
+}
+
+// lodCellAt downsamples the step^3 blocks of coarse cell cx, cy, cz.
+func lodCellAt(ch *Chunk, step, cx, cy, cz uint8) lodCell {
+	solid, water := 0, 0
+	top := AirID
+	var light uint8
+	for y := int(cy)*int(step) + int(step) - 1; y >= int(cy)*int(step); y-- {
+		for x := cx * step; x < cx*step+step; x++ {
+			for z := cz * step; z < cz*step+step; z++ {
+				block := ch.getBlock(x, uint8(y), z)
+				if block.isSolid() {
+					solid++
+					if top == AirID {
+						top = block.blockType
+					}
+				} else {
+					light = max(light, block.lightLevel())
+					if isWater(block.blockType) {
+						water++
+					}
+				}
+			}
+		}
+	}
+
+	if volume := int(step) * int(step) * int(step); solid*2 < volume {
+		if water*2 >= volume-solid {
+			return lodCell{WaterID, light}
+		}
+		return lodCell{AirID, light}
+	}
+	return lodCell{top, light}
+}
+
+// lodMeshChunk greedy meshes a chunk of world at the given level of detail, returning its opaque and its water
+// mesh.
+func lodMeshChunk(world map[PillarPos]*Pillar, _Chunk *Chunk, chunkPos ChunkPosition, level uint8) (*[]uint32, *[]uint32) {
+	step := uint8(1) << level
+	size := CHUNK_SIZE / step
+
+	var cells [CHUNK_SIZE][CHUNK_SIZE][CHUNK_SIZE]lodCell
+	for x := range size {
+		for y := range size {
+			for z := range size {
+				cells[x][y][z] = lodCellAt(_Chunk, step, x, y, z)
+			}
+		}
+	}
+
+	// neighbor returns the cell next to x, y, z, and false where a face has to be drawn as a skirt
+	neighbor := func(x, y, z uint8, face uint8) (lodCell, bool) {
+		normal := faceNormals[face]
+		nx, ny, nz := int(x)+int(normal.x), int(y)+int(normal.y), int(z)+int(normal.z)
+		if nx >= 0 && ny >= 0 && nz >= 0 && nx < int(size) && ny < int(size) && nz < int(size) {
+			return cells[nx][ny][nz], true
+		}
+
+		pos := PillarPos{chunkPos.pillarPos.x + int32(normal.x), chunkPos.pillarPos.z + int32(normal.z)}
+		pillar := world[pos]
+		if pillar == nil || pillar.lod.Load() != int32(level) {
+			return lodCell{}, false
+		}
+		ch := pillar.chunkOrSky(chunkPos.y + int32(normal.y))
+		if ch == nil {
+			return lodCell{}, false
+		}
+		wrap := func(v int) uint8 {
+			return uint8((v + int(size)) % int(size))
+		}
+		return lodCellAt(ch, step, wrap(nx), wrap(ny), wrap(nz)), true
+	}
+
+	tints := lazyColumnTints{pos: chunkPos.pillarPos}
+
+	faces := greedyFacesPool.Get().(*greedyFaces)
+	defer greedyFacesPool.Put(faces)
+	*faces = greedyFaces{}
+	waterFaces := greedyFacesPool.Get().(*greedyFaces)
+	defer greedyFacesPool.Put(waterFaces)
+	*waterFaces = greedyFaces{}
+
+	for x := range size {
+		for y := range size {
+			for z := range size {
+				self := cells[x][y][z]
+				if self.blockType == AirID {
+					continue
+				}
+				target, hidden := faces, func(n lodCell) bool { return BlockProperties[n.blockType].IsSolid }
+				if self.blockType == WaterID {
+					target, hidden = waterFaces, func(n lodCell) bool { return n.blockType != AirID }
+				}
+
+				curTint := tints.tint(self.blockType, x*step, z*step)
+
+				for face := range uint8(len(faces)) {
+					n, ok := neighbor(x, y, z, face)
+					if ok && hidden(n) {
+						continue
+					}
+
+					light := float32(maxLightLevel)
+					if ok {
+						light = float32(n.light)
+					}
+					if AmbientOcclusion {
+						light *= faceShade[face] // match the directional shading of full detail chunks
+					}
+
+					slice, a, b := sliceCoords(face, blockPosition{x, y, z})
+					target[face][slice][a][b] = greedyFace{set: true, blockType: self.blockType, tint: curTint, light: [4]float32{light, light, light, light}}
+				}
+			}
+		}
+	}
+
+	verts := mergeGreedyFaces(faces, size, step)
+	waterVerts := mergeGreedyFaces(waterFaces, size, step)
+	return &verts, &waterVerts
+}
+
+// updatePillarLODs picks the level of every meshed pillar for a camera in center and remeshes the pillars whose
+// level changed, along with their neighbors whose skirts depend on it.
+func updatePillarLODs(center PillarPos) {
+	var changed []PillarPos
+	pillarsMu.RLock()
+	for pos, pillar := range pillars {
+		level := int32(lodLevel(pos, center))
+		if pillar.lod.Swap(level) != level && pillar.meshReady.Load() {
+			changed = append(changed, pos)
+		}
+	}
+	pillarsMu.RUnlock()
+
+	remesh := make(map[PillarPos]struct{})
+	for _, pos := range changed {
+		remesh[pos] = struct{}{}
+		for _, dir := range CardinalDirections[2:] {
+			remesh[PillarPos{pos.x + int32(dir.x), pos.z + int32(dir.z)}] = struct{}{}
+		}
+	}
+	var rebuild []ChunkPosition
+	pillarsMu.RLock()
+	for pos := range remesh {
+		if pillar := pillars[pos]; pillar != nil {
+			for i := range pillar.chunks {
+				rebuild = append(rebuild, ChunkPosition{pos, pillar.bottom + int32(i)})
+			}
+		}
+	}
+	pillarsMu.RUnlock()
+	for _, cP := range rebuild {
+		queueChunkRebuild(cP)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import "testing"
+
+// borderSquares counts the face squares of a mesh facing one way that lie in the plane at corner coordinate plane.
+func borderSquares(t *testing.T, verts []uint32, face uint8, axis, plane int) int {
+	t.Helper()
+	coverage, _ := quadCoverage(t, verts)
+	count := 0
+	for square := range coverage {
+		if square[0] == int(face) && square[2+axis] == plane {
+			count++
+		}
+	}
+	return count
+}
+
+func TestLODBorderSkirts(t *testing.T) {
+	// Flat solid ground on both sides of the border between pillar 0,0 and 1,0
+	world := groundWorld(1, -1, 0, 1)
+	for _, pillar := range world {
+		lightPillar(world, pillar)
+	}
+	ground := ChunkPosition{PillarPos{0, 0}, 0}
+	coarse := ChunkPosition{PillarPos{1, 0}, 0}
+	ch, coarseCh := world[ground.pillarPos].chunk(0), world[coarse.pillarPos].chunk(0)
+
+	// Neighbors at the same level cover each other's sides
+	if n := borderSquares(t, *naiveMeshChunk(world, ch, ground), FACE_MAP.RIGHT, 0, int(CHUNK_SIZE)); n != 0 {
+		t.Fatalf("%d faces drawn between two full detail pillars", n)
+	}
+
+	world[coarse.pillarPos].lod.Store(1)
+	for _, ao := range []bool{false, true} {
+		saved := AmbientOcclusion
+		AmbientOcclusion = ao
+		// The full detail side hangs a face on every block of the border, greedy or not
+		for name, verts := range map[string][]uint32{
+			"naive":  *naiveMeshChunk(world, ch, ground),
+			"greedy": *greedyMeshChunk(world, ch, ground),
+		} {
+			if n := borderSquares(t, verts, FACE_MAP.RIGHT, 0, int(CHUNK_SIZE)); n != int(CHUNK_SIZE)*int(CHUNK_SIZE) {
+				t.Errorf("ambient occlusion %v: %s mesh draws %d faces on the border with a coarser pillar", ao, name, n)
+			}
+		}
+		// And so does the coarse side, with faces of 2x2 blocks
+		verts, _ := lodMeshChunk(world, coarseCh, coarse, 1)
+		if n := borderSquares(t, *verts, FACE_MAP.LEFT, 0, 0); n != int(CHUNK_SIZE)*int(CHUNK_SIZE) {
+			t.Errorf("ambient occlusion %v: coarse mesh draws %d cells on the border with a full detail pillar", ao, n)
+		}
+		AmbientOcclusion = saved
+	}
+
+	// The skirt is lit, not black like the buried block it faces
+	verts := *naiveMeshChunk(world, ch, ground)
+	for q := 0; q < len(verts); q += vertexWords {
+		v := unpackChunkVertex([vertexWords]uint32{verts[q], verts[q+1]})
+		if v.face == FACE_MAP.RIGHT && v.light == 0 {
+			t.Fatalf("skirt vertex %+v is unlit", v)
+		}
+	}
+}
+
+func TestLODRingWidth(t *testing.T) {
+	defer setRenderDistance(RENDER_DISTANCE_i32, defaultEngineSettings().LODRingWidth)
+	center := PillarPos{-3, 2}
+
+	// Each ring is as wide as set, and the generation and unload distances follow the last one
+	setRenderDistance(4, 2)
+	for distance, want := range []uint8{0, 0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 3} {
+		if got := lodLevel(PillarPos{center.x + int32(distance), center.z - 1}, center); got != want {
+			t.Errorf("ring width 2: a pillar %d away is at level %d, want %d", distance, got, want)
+		}
+	}
+	if GENERATION_DISTANCE_i32 != 4+3*2+1 || UNLOAD_DISTANCE_i32 != GENERATION_DISTANCE_i32+2 {
+		t.Errorf("ring width 2 generates up to %d and unloads past %d", GENERATION_DISTANCE_i32, UNLOAD_DISTANCE_i32)
+	}
+
+	// Without rings everything is drawn at full detail and only the render distance is generated
+	setRenderDistance(4, 0)
+	for distance := int32(0); distance < 10; distance++ {
+		if got := lodLevel(PillarPos{center.x, center.z + distance}, center); got != 0 {
+			t.Errorf("without rings a pillar %d away is at level %d", distance, got)
+		}
+	}
+	if GENERATION_DISTANCE_i32 != 5 {
+		t.Errorf("without rings the generation distance is %d", GENERATION_DISTANCE_i32)
+	}
+}
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"math/rand"
 	"net/http"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"time"
@@ -17,42 +20,36 @@ import (
 	"github.com/go-gl/glfw/v3.3/glfw"
 	"github.com/go-gl/mathgl/mgl32"
 	"github.com/go-gl/mathgl/mgl64"
-	"github.com/ojrac/opensimplex-go"
 )
 
 // Import for side effects
 
 var (
-	noise                          = opensimplex.New32(SEED)
-	random                         = rand.New(rand.NewSource(SEED))
-	yaw                    float64 = -90.0
-	pitch                  float64 = 0.0
-	lastX                  float64
-	lastY                  float64
-	firstMouse             bool = true
-	movementSpeed          float32
-	shouldLockMouse        bool = true
-	cameraPosition              = mgl32.Vec3{0.0, 10, 15}
-	cameraPositionLerped        = cameraPosition
-	cameraFront                 = mgl32.Vec3{0.0, 0.0, -1.0}
-	orientationFront            = mgl32.Vec3{0.0, 0.0, -1.0}
-	cameraUp                    = mgl32.Vec3{0.0, 1.0, 0.0}
-	cameraRight                 = cameraFront.Cross(cameraUp)
-	velocity                    = mgl32.Vec3{0, 0, 0}
-	deltaTime              float32
-	previousFrame          time.Time = time.Now()
-	isOnGround             bool
-	isSprinting            bool
-	jumpCooldown           float32 = 0
-	fps                    float64
-	fpsString              string
-	frameCount             int       = 0
-	startTime              time.Time = time.Now() // for FPS display
-	isFlying               bool      = true
-	previousCameraPosition mgl32.Vec3
-	monitor                *glfw.Monitor
-	tickAccumulator        float32
-	showDebug              bool = true
+	random                       = rand.New(rand.NewSource(worldSettings.Seed))
+	yaw                  float64 = -90.0
+	pitch                float64 = 0.0
+	lastX                float64
+	lastY                float64
+	firstMouse           bool = true
+	shouldLockMouse      bool = true
+	cameraPosition            = mgl32.Vec3{0.0, 10, 15}
+	cameraPositionLerped      = cameraPosition
+	cameraFront               = mgl32.Vec3{0.0, 0.0, -1.0}
+	orientationFront          = mgl32.Vec3{0.0, 0.0, -1.0}
+	cameraUp                  = mgl32.Vec3{0.0, 1.0, 0.0}
+	cameraRight               = cameraFront.Cross(cameraUp)
+	deltaTime            float32
+	previousFrame        time.Time = time.Now()
+	fps                  float64
+	fpsString            string
+	frameCount           int       = 0
+	startTime            time.Time = time.Now() // for FPS display
+	monitor              *glfw.Monitor
+	tickAccumulator      float32
+	showDebug            bool = true
+	chunksDrawn          int
+	chunksCulled         int
+	entitiesDrawn        int
 )
 
 func initOpenGL3D() uint32 {
@@ -63,6 +60,9 @@ func initOpenGL3D() uint32 {
 	gl.CullFace(gl.BACK)
 	gl.FrontFace(gl.CCW)
 	gl.Enable(gl.DEPTH_TEST)
+	if engineSettings.AntiAliasing {
+		gl.Enable(gl.MULTISAMPLE)
+	}
 	vertexShader := loadShader("shaders/blockShaderVertex.vert", gl.VERTEX_SHADER)
 	fragmentShader := loadShader("shaders/blockShaderFragment.frag", gl.FRAGMENT_SHADER)
 	prog := gl.CreateProgram()
@@ -139,6 +139,85 @@ func updateFPS() {
 	}
 }
 
+// renderChunks draws the chunks that survive frustum and cave culling, opaque faces first and then the water
+// faces back to front. The caller must hold pillarsMu.
+func renderChunks(viewFrustum *frustum, modelLoc3D int32) (drawn, culled int) {
+	setModel := func(cP ChunkPosition) {
+		modelPos := mgl32.Translate3D(
+			float32(cP.getWorldX()),
+			float32(cP.getWorldY()),
+			float32(cP.getWorldZ()),
+		)
+		gl.UniformMatrix4fv(modelLoc3D, 1, false, &modelPos[0])
+	}
+
+	total := 0
+	for _, pillarData := range pillars {
+		for _, chunkData := range pillarData.chunks {
+			if chunkData != nil && chunkData.hasMesh() {
+				total++
+			}
+		}
+	}
+
+	var visible []ChunkPosition
+	reachable := false
+	if CaveCulling {
+		var reached []ChunkPosition
+		if reached, reachable = visibleChunks(ChunkPosition{cameraPillar(), chunkYFromPosition(cameraPositionLerped[1])}, viewFrustum); reachable {
+			for _, cP := range reached {
+				if pillars[cP.pillarPos].chunk(cP.y).hasMesh() {
+					visible = append(visible, cP)
+				}
+			}
+		}
+	}
+	if !reachable {
+		// Camera outside the loaded world, fall back to frustum culling alone
+		for pillarPos, pillarData := range pillars {
+			if !viewFrustum.intersectsAABB(pillarAABB(pillarData)) {
+				continue
+			}
+			for i, chunkData := range pillarData.chunks {
+				cP := ChunkPosition{pillarPos, pillarData.bottom + int32(i)}
+				if chunkData.hasMesh() && viewFrustum.intersectsAABB(chunkAABB(cP)) {
+					visible = append(visible, cP)
+				}
+			}
+		}
+	}
+
+	var water []ChunkPosition
+	for _, cP := range visible {
+		chunkData := pillars[cP.pillarPos].chunk(cP.y)
+		if chunkData.waterIndexCount > 0 {
+			water = append(water, cP)
+		}
+		if chunkData.indexCount > 0 {
+			setModel(cP)
+			gl.BindVertexArray(chunkData.vao)
+			gl.DrawElements(gl.TRIANGLES, chunkData.indexCount, gl.UNSIGNED_INT, nil)
+		}
+	}
+
+	// Water is seen from above and below, and must not hide the water behind it
+	waterDrawOrder(water)
+	gl.Enable(gl.BLEND)
+	gl.Disable(gl.CULL_FACE)
+	gl.DepthMask(false)
+	for _, cP := range water {
+		chunkData := pillars[cP.pillarPos].chunk(cP.y)
+		setModel(cP)
+		gl.BindVertexArray(chunkData.waterVao)
+		gl.DrawElements(gl.TRIANGLES, chunkData.waterIndexCount, gl.UNSIGNED_INT, nil)
+	}
+	gl.DepthMask(true)
+	gl.Enable(gl.CULL_FACE)
+	gl.Disable(gl.BLEND)
+
+	return len(visible), total - len(visible)
+}
+
 func OnWindowResize(w *glfw.Window, width int, height int) {
 	gl.Viewport(0, 0, int32(width), int32(height))
 }
@@ -147,6 +226,25 @@ func lerp(a, b mgl32.Vec3, alpha float32) mgl32.Vec3 {
 }
 
 func main() {
+	opts, err := loadSettings(os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "OpenCraft:", err)
+		os.Exit(2)
+	}
+	applyWorldSettings(opts.world)
+	applyEngineSettings(opts.engine)
+	simulation = NewSimulation(cameraPosition, opts.engine)
+
+	if opts.worldStats > 0 {
+		if err := runWorldStats(opts.worldStats, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	runtime.LockOSThread()
 
 	// Start profiling server
@@ -155,7 +253,7 @@ func main() {
 		log.Println(http.ListenAndServe("localhost:6060", nil))
 	}()
 
-	err := glfw.Init()
+	err = glfw.Init()
 	if err != nil {
 		panic(err)
 	}
@@ -165,6 +263,9 @@ func main() {
 	glfw.WindowHint(glfw.ContextVersionMinor, 1)
 	glfw.WindowHint(glfw.OpenGLForwardCompatible, glfw.True)
 	glfw.WindowHint(glfw.OpenGLProfile, glfw.OpenGLCoreProfile)
+	if engineSettings.AntiAliasing {
+		glfw.WindowHint(glfw.Samples, 4)
+	}
 	window, err := glfw.CreateWindow(1600, 900, "OpenCraft", nil, nil)
 	window.SetAspectRatio(16, 9)
 	if err != nil {
@@ -172,7 +273,7 @@ func main() {
 	}
 	window.MakeContextCurrent()
 
-	if Vsync {
+	if engineSettings.Vsync {
 		glfw.SwapInterval(1)
 	} else {
 		glfw.SwapInterval(0)
@@ -185,8 +286,8 @@ func main() {
 	gl.UseProgram(opengl3d)
 
 	gl.ActiveTexture(gl.TEXTURE0)
-	var blockTextureAtlas = loadTextureAtlas("assets/textures/minecraftTextures.png")
-	gl.BindTexture(gl.TEXTURE_2D, blockTextureAtlas)
+	var blockTextureAtlas = loadTextureArray("assets/textures/minecraftTextures.png")
+	gl.BindTexture(gl.TEXTURE_2D_ARRAY, blockTextureAtlas)
 	textureLoc := gl.GetUniformLocation(opengl3d, gl.Str("TexCoord\x00"))
 	gl.Uniform1i(textureLoc, 0)
 	projection := initProjectionMatrix()
@@ -211,9 +312,14 @@ func main() {
 	projectionLoc2D := gl.GetUniformLocation(opengl2d, gl.Str("projection\x00"))
 	gl.UniformMatrix4fv(projectionLoc2D, 1, false, &orthographicProjection[0])
 	var position = "POS: " + strconv.FormatFloat(mgl64.Round(float64(cameraPosition[0]), 2), 'f', -1, 32) + "," + strconv.FormatFloat(mgl64.Round(float64(cameraPosition[1]), 2), 'f', -1, 32) + "," + strconv.FormatFloat(mgl64.Round(float64(cameraPosition[2]), 2), 'f', -1, 32)
-	var isGroundedState = "Grounded: " + strconv.FormatBool(isOnGround)
-	var isSprintingState = "Sprinting: " + strconv.FormatBool(isSprinting)
-	var velString string = "Velocity: " + strconv.FormatFloat(mgl64.Round(float64(velocity[0]), 2), 'f', -1, 32) + " , " + strconv.FormatFloat(mgl64.Round(float64(velocity[1]), 2), 'f', -1, 32) + " , " + strconv.FormatFloat(mgl64.Round(float64(velocity[2]), 2), 'f', -1, 32)
+	player := simulation.player
+	var isGroundedState = "Grounded: " + strconv.FormatBool(player.onGround)
+	var isSprintingState = "Sprinting: " + strconv.FormatBool(player.sprinting)
+	var velString string = "Velocity: " + strconv.FormatFloat(mgl64.Round(float64(player.velocity[0]), 2), 'f', -1, 32) + " , " + strconv.FormatFloat(mgl64.Round(float64(player.velocity[1]), 2), 'f', -1, 32) + " , " + strconv.FormatFloat(mgl64.Round(float64(player.velocity[2]), 2), 'f', -1, 32)
+	var streamingState = streamingStats.String()
+	var cullingState = "Chunks: 0 drawn, 0 culled"
+	var biomeState = "Biome: " + cameraBiome().Name
+	var entityState = "Entities: 0 drawn, 1 total"
 	var textObjects []text = []text{
 		createText(ctx, "+", 16, false, mgl32.Vec2{800, 450}, dst, opengl2d),
 		createText(ctx, &fpsString, 24, true, mgl32.Vec2{10, 400}, dst, opengl2d),
@@ -221,6 +327,10 @@ func main() {
 		createText(ctx, &isGroundedState, 24, true, mgl32.Vec2{10, 360}, dst, opengl2d),
 		createText(ctx, &isSprintingState, 24, true, mgl32.Vec2{10, 340}, dst, opengl2d),
 		createText(ctx, &position, 24, true, mgl32.Vec2{10, 320}, dst, opengl2d),
+		createText(ctx, &streamingState, 24, true, mgl32.Vec2{10, 300}, dst, opengl2d),
+		createText(ctx, &cullingState, 24, true, mgl32.Vec2{10, 280}, dst, opengl2d),
+		createText(ctx, &biomeState, 24, true, mgl32.Vec2{10, 260}, dst, opengl2d),
+		createText(ctx, &entityState, 24, true, mgl32.Vec2{10, 240}, dst, opengl2d),
 	}
 	modelLoc2D := gl.GetUniformLocation(opengl2d, gl.Str("model\x00"))
 	modelLoc3D := gl.GetUniformLocation(opengl3d, gl.Str("model\x00"))
@@ -231,7 +341,22 @@ func main() {
 	window.SetMouseButtonCallback(mouseInputCallback)
 	window.SetKeyCallback(input)
 
-	go makeTestChunks()
+	worldStore, err = openRegionStore(filepath.Join(opts.worldDir, "region"))
+	if err != nil {
+		panic(err)
+	}
+	defer func() {
+		saveModifiedPillars()
+		if err := worldStore.Close(); err != nil {
+			log.Println("closing world:", err)
+		}
+	}()
+
+	go runLightingWorker()
+	go streamWorld()
+	go runFluidSim()
+	runChunkWorkers(chunkWorkerCount())
+	go autosaveWorld()
 
 	initialized := false
 	for !window.ShouldClose() {
@@ -250,51 +375,43 @@ func main() {
 			window.SetInputMode(glfw.CursorMode, glfw.CursorNormal)
 		}
 
-		movement(window)
-
+		ticked := false
 		for tickAccumulator >= TICK_UPDATE_RATE {
-			previousCameraPosition = cameraPosition
-			velocityDamping(0.35)
-
-			//Update Debug
-			if showDebug {
-				position = "POS: " + strconv.FormatFloat(mgl64.Round(float64(cameraPosition[0]), 2), 'f', -1, 32) + " , " + strconv.FormatFloat(mgl64.Round(float64(cameraPosition[1]), 2), 'f', -1, 32) + " , " + strconv.FormatFloat(mgl64.Round(float64(cameraPosition[2]), 2), 'f', -1, 32)
-				isSprintingState = "Sprinting: " + strconv.FormatBool(isSprinting)
-				isGroundedState = "Grounded: " + strconv.FormatBool(isOnGround)
-				velString = "Velocity: " + strconv.FormatFloat(mgl64.Round(float64(velocity[0]), 2), 'f', -1, 32) + "," + strconv.FormatFloat(mgl64.Round(float64(velocity[1]), 2), 'f', -1, 32) + "," + strconv.FormatFloat(mgl64.Round(float64(velocity[2]), 2), 'f', -1, 32)
-				for i := range textObjects {
-					if textObjects[i].Update {
-						updateTextTexture(textObjects[i].Content, &textObjects[i], ctx, dst)
-					}
-				}
-			}
-			if !isFlying {
-				velocity[1] -= 0.02 //gravity
-				collisions()
-			}
-			if jumpCooldown > 0.01 {
-				jumpCooldown -= 0.01
-			} else {
-				jumpCooldown = 0
-			}
-
-			cameraPosition = cameraPosition.Add(velocity)
+			simulation.Queue(readInput(window))
+			simulation.BlocksChanged(takeBlockEdits())
+			pillarsMu.RLock()
+			edits := simulation.Step(loadedWorld{})
+			pillarsMu.RUnlock()
+			applyBlockEdits(edits)
 			tickAccumulator -= TICK_UPDATE_RATE
+			ticked = true
 		}
-		lerpVal := tickAccumulator / TICK_UPDATE_RATE
-		if lerpVal < 0 {
-			lerpVal = 0
-		}
-		if lerpVal > 1 {
-			lerpVal = 1
+		alpha := tickAccumulator / TICK_UPDATE_RATE
+		cameraPosition = player.position
+		cameraPositionLerped = simulation.Interpolated(alpha)
+
+		//Update Debug
+		if showDebug && ticked {
+			position = "POS: " + strconv.FormatFloat(mgl64.Round(float64(cameraPosition[0]), 2), 'f', -1, 32) + " , " + strconv.FormatFloat(mgl64.Round(float64(cameraPosition[1]), 2), 'f', -1, 32) + " , " + strconv.FormatFloat(mgl64.Round(float64(cameraPosition[2]), 2), 'f', -1, 32)
+			isSprintingState = "Sprinting: " + strconv.FormatBool(player.sprinting)
+			isGroundedState = "Grounded: " + strconv.FormatBool(player.onGround)
+			streamingState = streamingStats.String()
+			cullingState = "Chunks: " + strconv.Itoa(chunksDrawn) + " drawn, " + strconv.Itoa(chunksCulled) + " culled"
+			entityState = "Entities: " + strconv.Itoa(entitiesDrawn) + " drawn, " + strconv.Itoa(len(simulation.entities.all)) + " total"
+			biomeState = "Biome: " + cameraBiome().Name
+			velString = "Velocity: " + strconv.FormatFloat(mgl64.Round(float64(player.velocity[0]), 2), 'f', -1, 32) + "," + strconv.FormatFloat(mgl64.Round(float64(player.velocity[1]), 2), 'f', -1, 32) + "," + strconv.FormatFloat(mgl64.Round(float64(player.velocity[2]), 2), 'f', -1, 32)
+			for i := range textObjects {
+				if textObjects[i].Update {
+					updateTextTexture(textObjects[i].Content, &textObjects[i], ctx, dst)
+				}
+			}
 		}
-		cameraPositionLerped = lerp(previousCameraPosition, cameraPosition, lerpVal)
 
 		gl.Enable(gl.CULL_FACE)
 		gl.Enable(gl.DEPTH_TEST)
 
 		gl.UseProgram(opengl3d)
-		gl.BindTexture(gl.TEXTURE_2D, blockTextureAtlas)
+		gl.BindTexture(gl.TEXTURE_2D_ARRAY, blockTextureAtlas)
 
 		view = initViewMatrix()
 
@@ -306,31 +423,12 @@ func main() {
 
 		pillarsMu.RLock()
 
-		for pillarPos, pillarData := range pillars {
-
-			for i, chunkData := range pillarData.chunks {
-				if chunkData != nil && chunkData.trisCount > 0 {
-
-					if chunkData.trisCount > 0 {
-						//render the chunk
-
-						modelPos := mgl32.Translate3D(
-							float32(pillarPos.getWorldX()),
-							float32(getWorldYFromIndex(uint8(i))),
-							float32(pillarPos.getWorldZ()),
-						)
-
-						gl.UniformMatrix4fv(modelLoc3D, 1, false, &modelPos[0])
-						gl.BindVertexArray(chunkData.vao)
-						gl.DrawArrays(gl.TRIANGLES, 0, chunkData.trisCount)
-
-					}
-				}
-			}
-
-		}
+		viewFrustum := extractFrustum(projection.Mul4(view))
+		drawn, culled := renderChunks(&viewFrustum, modelLoc3D)
 
 		pillarsMu.RUnlock()
+		chunksDrawn, chunksCulled = drawn, culled
+		entitiesDrawn = renderEntities(simulation.entities, &viewFrustum, projection, view, alpha)
 
 		if showDebug {
 			gl.Disable(gl.DEPTH_TEST)
@@ -366,8 +464,3 @@ func main() {
 
 	}
 }
-
-/*
-To-do:
-Add light rebuild on block edits
-*/
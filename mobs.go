@@ -0,0 +1,296 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+/*
+ * Mobs: creatures that walk the world on paths from findPath. Each runs a small state machine: it idles, wanders
+ * off to a random spot nearby, follows or flees the player it sees depending on its kind, and flees whatever hit
+ * it for a while. The physics keeps it from walking off ledges deeper than its paths drop, so knocking it around
+ * doesn't throw it off a cliff either. Mobs spawn on grass around the player and despawn once it is far away.
+ */
+
+type mobKind struct {
+	name      string
+	width     float32
+	height    float32
+	eyeHeight float32
+	speed     float32 // blocks per tick walking, before ground friction
+	sight     float32 // how close the player has to be to be noticed
+	follows   bool    // walks after the player it sees, otherwise runs from it
+	color     mgl32.Vec3
+}
+
+var mobKinds = []*mobKind{
+	{name: "Sheep", width: 0.9, height: 1.3, eyeHeight: 1.1, speed: 0.15, sight: 10, follows: true, color: mgl32.Vec3{0.92, 0.92, 0.88}},
+	{name: "Rabbit", width: 0.4, height: 0.5, eyeHeight: 0.4, speed: 0.25, sight: 6, color: mgl32.Vec3{0.6, 0.48, 0.35}},
+}
+
+type mobState uint8
+
+const (
+	mobIdle   mobState = iota // stands around for a while
+	mobWander                 // walks to a random spot nearby
+	mobFollow                 // walks after the player
+	mobFlee                   // runs away from a threat
+)
+
+const (
+	mobPathRange      int32   = 8  // how far a wandering or fleeing mob heads off
+	mobFollowDistance float32 = 2  // a following mob stops this close to the player
+	mobFleeTicks              = 60 // how long a mob runs from the player it saw
+	mobHurtFleeTicks          = 150
+	mobSearchCooldown         = 10 // ticks between two path searches of one mob
+	mobStuckTicks             = 40 // a mob that gets no closer to the next block for this long gives up
+	mobSalt           uint64  = 200
+)
+
+// How a mob finds its way: it steps onto ledges as high as its physics steps and drops down a few blocks at most
+func (k *mobKind) pathRules() pathRules {
+	return pathRules{height: int32(math.Ceil(float64(k.height))), climb: 1, drop: 3, maxNodes: PATH_MAX_NODES}
+}
+
+type mob struct {
+	kind       *mobKind
+	state      mobState
+	stateTicks uint32     // ticks left in the state, for the states that end on their own
+	threat     mgl32.Vec3 // what a fleeing mob runs from
+	goal       [3]int32   // the block the mob is heading for
+	hasGoal    bool
+	path       *path // nil while it has to be found again
+	next       int   // the node of the path the mob walks to
+	closest    float32
+	stuckTicks uint32
+	cooldown   uint32 // ticks until the next path search
+	rng        *rand.Rand
+}
+
+// A hurtable controller is told when its entity gets hit
+type hurtable interface {
+	hurt(from mgl32.Vec3)
+}
+
+func newMob(position mgl32.Vec3, kind *mobKind) *Entity {
+	return &Entity{
+		position:   position,
+		width:      kind.width,
+		height:     kind.height,
+		eyeHeight:  kind.eyeHeight,
+		solid:      true,
+		controller: &mob{kind: kind},
+		physics: &entityPhysics{
+			gravity:        gravity,
+			drag:           0.02,
+			groundFriction: 0.5,
+			stepHeight:     float32(kind.pathRules().climb),
+			maxDrop:        float32(kind.pathRules().drop) + 0.5,
+		},
+		model: &boxModel{size: mgl32.Vec3{kind.width, kind.height, kind.width}, color: kind.color},
+	}
+}
+
+func (m *mob) update(e *Entity, world BlockReader, entities *Entities) {
+	if m.rng == nil {
+		m.rng = rand.New(rand.NewPCG(uint64(e.id), mobSalt))
+	}
+	feet := feetBlock(e)
+	m.think(e, feet, nearestPlayer(e, entities, m.kind.sight))
+	m.walk(e, world, feet)
+	if m.stateTicks > 0 {
+		m.stateTicks--
+	}
+	if m.cooldown > 0 {
+		m.cooldown--
+	}
+}
+
+// think moves the state machine on and picks where to go.
+func (m *mob) think(e *Entity, feet [3]int32, player *Entity) {
+	switch {
+	case m.state == mobFlee && m.stateTicks > 0:
+		// Keeps running, heading off again each time it gets somewhere
+	case player != nil && m.kind.follows:
+		m.state = mobFollow
+	case player != nil:
+		m.flee(player.position, mobFleeTicks)
+	case m.state == mobFollow || m.state == mobFlee:
+		m.idle()
+	case m.state == mobIdle && m.stateTicks == 0:
+		m.state = mobWander
+		offset := [2]int32{m.rng.Int32N(2*mobPathRange+1) - mobPathRange, m.rng.Int32N(2*mobPathRange+1) - mobPathRange}
+		m.setGoal([3]int32{feet[0] + offset[0], feet[1], feet[2] + offset[1]})
+	case m.state == mobWander && !m.hasGoal:
+		m.idle()
+	}
+
+	switch m.state {
+	case mobFollow:
+		toPlayer := player.position.Sub(e.position)
+		if (mgl32.Vec2{toPlayer[0], toPlayer[2]}).Len() <= mobFollowDistance {
+			m.hasGoal, m.path = false, nil
+			return
+		}
+		// Only searches again once the player moved away from where it was heading
+		target := feetBlock(player)
+		if !m.hasGoal || abs32(target[0]-m.goal[0])+abs32(target[1]-m.goal[1])+abs32(target[2]-m.goal[2]) > 2 {
+			m.setGoal(target)
+		}
+	case mobFlee:
+		if !m.hasGoal {
+			away := e.position.Sub(m.threat)
+			away[1] = 0
+			if away.Len() == 0 {
+				away = mgl32.Vec3{1, 0, 0}
+			}
+			away = away.Normalize().Mul(float32(mobPathRange))
+			m.setGoal([3]int32{feet[0] + blockCoord(away[0]), feet[1], feet[2] + blockCoord(away[2])})
+		}
+	}
+}
+
+func (m *mob) idle() {
+	m.state = mobIdle
+	m.stateTicks = 40 + m.rng.Uint32N(100)
+	m.hasGoal, m.path = false, nil
+}
+
+func (m *mob) flee(from mgl32.Vec3, ticks uint32) {
+	if m.state != mobFlee {
+		m.hasGoal, m.path = false, nil
+	}
+	m.state = mobFlee
+	m.threat = from
+	m.stateTicks = max(m.stateTicks, ticks)
+}
+
+func (m *mob) hurt(from mgl32.Vec3) {
+	m.flee(from, mobHurtFleeTicks)
+}
+
+func (m *mob) setGoal(goal [3]int32) {
+	if m.hasGoal && m.goal == goal {
+		return
+	}
+	m.goal, m.hasGoal, m.path = goal, true, nil
+}
+
+// walk searches for a path to the goal when there is none and steers along it.
+func (m *mob) walk(e *Entity, world BlockReader, feet [3]int32) {
+	if !m.hasGoal {
+		return
+	}
+	if m.path == nil {
+		if m.cooldown > 0 {
+			return
+		}
+		m.cooldown = mobSearchCooldown
+		m.path, m.next = findPath(world, feet, m.goal, m.kind.pathRules()), 0
+		m.closest, m.stuckTicks = float32(1e9), 0
+		if m.path == nil {
+			m.hasGoal = false
+			return
+		}
+	}
+
+	node := m.path.nodes[m.next]
+	toNode := mgl32.Vec2{float32(node[0]) - e.position[0], float32(node[2]) - e.position[2]}
+	if toNode.Len() < 0.25 && abs32(node[1]-feet[1]) <= 1 {
+		m.next++
+		m.closest, m.stuckTicks = float32(1e9), 0
+		if m.next == len(m.path.nodes) {
+			m.hasGoal, m.path = false, nil
+			return
+		}
+		node = m.path.nodes[m.next]
+		toNode = mgl32.Vec2{float32(node[0]) - e.position[0], float32(node[2]) - e.position[2]}
+	}
+
+	if toNode.Len() < m.closest-0.01 {
+		m.closest, m.stuckTicks = toNode.Len(), 0
+	} else if m.stuckTicks++; m.stuckTicks > mobStuckTicks {
+		m.hasGoal, m.path = false, nil
+		return
+	}
+	if toNode.Len() > 0 {
+		step := toNode.Normalize().Mul(min(m.kind.speed, toNode.Len()))
+		e.velocity[0], e.velocity[2] = step[0], step[1]
+	}
+}
+
+// blocksChanged drops the path when a block it was found through changed.
+func (m *mob) blocksChanged(blocks [][3]int32, everything bool) {
+	if m.path == nil {
+		return
+	}
+	if everything {
+		m.path = nil
+		return
+	}
+	for _, block := range blocks {
+		if m.path.touches(block) {
+			m.path = nil
+			return
+		}
+	}
+}
+
+// feetBlock returns the block an entity's feet are in.
+func feetBlock(e *Entity) [3]int32 {
+	feet := e.position[1] - e.eyeHeight + 2*collisionEpsilon
+	return [3]int32{blockCoord(e.position[0]), blockCoord(feet), blockCoord(e.position[2])}
+}
+
+// nearestPlayer returns the closest player within sight of an entity.
+func nearestPlayer(e *Entity, entities *Entities, sight float32) *Entity {
+	var nearest *Entity
+	for _, other := range entities.near(e.position, sight) {
+		if _, ok := other.controller.(*playerState); !ok {
+			continue
+		}
+		if nearest == nil || other.position.Sub(e.position).Len() < nearest.position.Sub(e.position).Len() {
+			nearest = other
+		}
+	}
+	return nearest
+}
+
+// spawnMobs despawns the mobs far from the player and spawns new ones on grass around it until there are
+// MOB_CAP nearby.
+func (s *Simulation) spawnMobs(world BlockReader) {
+	player := s.player
+	nearby := 0
+	for _, e := range s.entities.all {
+		if _, ok := e.controller.(*mob); !ok {
+			continue
+		}
+		if e.position.Sub(player.position).Len() > MOB_DESPAWN_RADIUS {
+			s.entities.remove(e)
+		} else if e.position.Sub(player.position).Len() <= MOB_SPAWN_RADIUS {
+			nearby++
+		}
+	}
+
+	rng := rand.New(rand.NewPCG(s.tick, mobSalt))
+	feet := feetBlock(player.Entity)
+	for attempt := 0; attempt < 8 && nearby < MOB_CAP; attempt++ {
+		radius := int32(MOB_SPAWN_RADIUS)
+		x := feet[0] + rng.Int32N(2*radius+1) - radius
+		z := feet[2] + rng.Int32N(2*radius+1) - radius
+		if distance := (mgl32.Vec2{float32(x - feet[0]), float32(z - feet[2])}).Len(); distance < 12 || distance > MOB_SPAWN_RADIUS {
+			continue // not right next to the player, nor where it wouldn't count as nearby
+		}
+		kind := mobKinds[rng.IntN(len(mobKinds))]
+		for y := feet[1] + 8; y >= feet[1]-16; y-- {
+			ground, _ := world.BlockAt(x, y-1, z)
+			if ground == GrassID && pathStandable(world, x, y, z, kind.pathRules().height) {
+				s.entities.spawn(newMob(mgl32.Vec3{float32(x), float32(y) - 0.5 + kind.eyeHeight, float32(z)}, kind))
+				nearby++
+				break
+			}
+		}
+	}
+}
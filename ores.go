@@ -0,0 +1,183 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+)
+
+/*
+ * Underground features: ore veins, pockets of dirt and gravel, and lakes of water or lava. Every chunk draws its
+ * features from its own random source, seeded with the world seed and the chunk position, so the features of a
+ * chunk never depend on which chunks were generated before it. A feature stays inside the chunk it starts in.
+ */
+
+// OreVein places blobs of Block into the stone of a height range. Dirt and gravel pockets are just big veins.
+type OreVein struct {
+	Block    uint16
+	MinY     int32 // world heights the middle of a vein may be at
+	MaxY     int32
+	Size     int // roughly how many blocks a vein has
+	Attempts int // veins tried per chunk, an attempt landing outside MinY..MaxY places nothing
+}
+
+var defaultOreVeins = []OreVein{
+	{Block: DirtID, MinY: FEATURE_BOTTOM_Y, MaxY: 64, Size: 40, Attempts: 2},
+	{Block: GravelID, MinY: FEATURE_BOTTOM_Y, MaxY: 64, Size: 40, Attempts: 2},
+	{Block: CoalOreID, MinY: FEATURE_BOTTOM_Y, MaxY: 96, Size: 14, Attempts: 10},
+	{Block: IronOreID, MinY: FEATURE_BOTTOM_Y, MaxY: 40, Size: 8, Attempts: 8},
+	{Block: GoldOreID, MinY: FEATURE_BOTTOM_Y, MaxY: 0, Size: 7, Attempts: 3},
+	{Block: DiamondOreID, MinY: FEATURE_BOTTOM_Y, MaxY: -16, Size: 5, Attempts: 2},
+}
+
+// Salts keeping the random sources of the underground stages apart
+const (
+	oreSalt uint64 = iota + 100
+	lakeSalt
+)
+
+// chunkRand returns the random source of one chunk for a stage, the same for the same seed, chunk and salt.
+func chunkRand(seed uint64, pos ChunkPosition, salt uint64) *rand.Rand {
+	column := mixSeed(uint64(uint32(pos.pillarPos.x))<<32|uint64(uint32(pos.pillarPos.z)), uint64(uint32(pos.y)))
+	return rand.New(rand.NewPCG(seed, mixSeed(column, salt)))
+}
+
+type OreStage struct {
+	seed  uint64
+	Veins []OreVein
+}
+
+func NewOreStage(seed int64) *OreStage {
+	return &OreStage{seed: uint64(seed), Veins: defaultOreVeins}
+}
+
+func (s *OreStage) Apply(ch *Chunk, ctx *generationContext) {
+	if blockType, ok := ch.isUniform(); ok && blockType != StoneID {
+		return
+	}
+	rng := chunkRand(s.seed, ctx.pos, oreSalt)
+	bottom := ctx.pos.getWorldY()
+	for _, vein := range s.Veins {
+		for range vein.Attempts {
+			// Always draw the whole attempt so skipping one doesn't shift the ones after it
+			x, y, z := rng.Float64()*float64(CHUNK_SIZE), rng.Float64()*float64(CHUNK_SIZE), rng.Float64()*float64(CHUNK_SIZE)
+			radius := math.Cbrt(3 * float64(vein.Size) / (4 * math.Pi))
+			radii := [3]float64{radius * (0.7 + 0.6*rng.Float64()), radius * (0.7 + 0.6*rng.Float64()), radius * (0.7 + 0.6*rng.Float64())}
+			if worldY := bottom + int32(y); worldY < vein.MinY || worldY > vein.MaxY {
+				continue
+			}
+			placeBlob(ch, [3]float64{x, y, z}, radii, vein.Block)
+		}
+	}
+}
+
+// placeBlob replaces the stone inside an ellipsoid, clipped to the chunk.
+func placeBlob(ch *Chunk, center, radii [3]float64, blockType uint16) {
+	lo := func(axis int) uint8 { return uint8(max(0, math.Floor(center[axis]-radii[axis]))) }
+	hi := func(axis int) uint8 {
+		return uint8(min(float64(CHUNK_SIZE-1), math.Floor(center[axis]+radii[axis])))
+	}
+	for x := lo(0); x <= hi(0); x++ {
+		for y := lo(1); y <= hi(1); y++ {
+			for z := lo(2); z <= hi(2); z++ {
+				dx := (float64(x) + 0.5 - center[0]) / radii[0]
+				dy := (float64(y) + 0.5 - center[1]) / radii[1]
+				dz := (float64(z) + 0.5 - center[2]) / radii[2]
+				if dx*dx+dy*dy+dz*dz <= 1 && ch.getBlockType(x, y, z) == StoneID {
+					ch.setBlockType(x, y, z, blockType)
+				}
+			}
+		}
+	}
+}
+
+// UndergroundLake hollows out a flat dome in solid ground and fills its lower half with Fluid.
+type UndergroundLake struct {
+	Fluid         uint16
+	MinY          int32 // world heights the surface of a lake may be at
+	MaxY          int32
+	Chance        float32 // chance of a chunk holding a lake
+	Radius        float64 // horizontal radius, lakes are half as tall as they are wide
+	SurfaceMargin int32   // lakes stay at least this far under the surface
+}
+
+var defaultUndergroundLakes = []UndergroundLake{
+	{Fluid: WaterID, MinY: -16, MaxY: 40, Chance: 0.04, Radius: 5, SurfaceMargin: 8},
+	{Fluid: LavaID, MinY: -28, MaxY: 0, Chance: 0.08, Radius: 5, SurfaceMargin: 8},
+}
+
+type LakeStage struct {
+	seed  uint64
+	Lakes []UndergroundLake
+}
+
+func NewLakeStage(seed int64) *LakeStage {
+	return &LakeStage{seed: uint64(seed), Lakes: defaultUndergroundLakes}
+}
+
+func (s *LakeStage) Apply(ch *Chunk, ctx *generationContext) {
+	if blockType, ok := ch.isUniform(); ok && !BlockProperties[blockType].IsSolid {
+		return
+	}
+	rng := chunkRand(s.seed, ctx.pos, lakeSalt)
+	for _, lake := range s.Lakes {
+		roll := rng.Float32()
+		radius := lake.Radius * (0.75 + 0.25*rng.Float64())
+		radii := [3]float64{radius, radius / 2, radius}
+
+		// Keep the lake and the ground around it inside the chunk
+		var center [3]float64
+		for axis := range center {
+			margin := math.Ceil(radii[axis]) + 1
+			center[axis] = margin + rng.Float64()*(float64(CHUNK_SIZE)-2*margin)
+		}
+
+		if roll >= lake.Chance {
+			continue
+		}
+		if surface := ctx.pos.getWorldY() + int32(center[1]); surface < lake.MinY || surface > lake.MaxY {
+			continue
+		}
+		if !lakeFits(ch, ctx, center, radii, lake.SurfaceMargin) {
+			continue
+		}
+		fillLake(ch, center, radii, lake.Fluid)
+	}
+}
+
+// lakeCells calls cell for every block whose centre lies inside the ellipsoid grown by grow blocks on each axis.
+func lakeCells(center, radii [3]float64, grow float64, cell func(x, y, z uint8)) {
+	for x := uint8(center[0] - radii[0] - grow); x <= uint8(center[0]+radii[0]+grow); x++ {
+		for y := uint8(center[1] - radii[1] - grow); y <= uint8(center[1]+radii[1]+grow); y++ {
+			for z := uint8(center[2] - radii[2] - grow); z <= uint8(center[2]+radii[2]+grow); z++ {
+				dx := (float64(x) + 0.5 - center[0]) / (radii[0] + grow)
+				dy := (float64(y) + 0.5 - center[1]) / (radii[1] + grow)
+				dz := (float64(z) + 0.5 - center[2]) / (radii[2] + grow)
+				if dx*dx+dy*dy+dz*dz <= 1 {
+					cell(x, y, z)
+				}
+			}
+		}
+	}
+}
+
+// lakeFits reports whether a lake would be sealed in solid ground, so it can't spill into a cave or the open.
+func lakeFits(ch *Chunk, ctx *generationContext, center, radii [3]float64, surfaceMargin int32) bool {
+	top := ctx.pos.getWorldY() + int32(center[1]+radii[1]) + 1
+	fits := true
+	lakeCells(center, radii, 1, func(x, y, z uint8) {
+		if !ch.getBlock(x, y, z).isSolid() || top > ctx.heights[x][z]-surfaceMargin {
+			fits = false
+		}
+	})
+	return fits
+}
+
+func fillLake(ch *Chunk, center, radii [3]float64, fluid uint16) {
+	lakeCells(center, radii, 0, func(x, y, z uint8) {
+		if float64(y)+0.5 < center[1] {
+			ch.setBlockType(x, y, z, fluid)
+		} else {
+			ch.setBlockType(x, y, z, AirID)
+		}
+	})
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"container/heap"
+	"sync"
+)
+
+/*
+ * A* pathfinding over the block grid. A path is a list of the blocks a walker's feet pass through. The walker
+ * stands where the block below it is solid and it has room for its height, steps to the four horizontal
+ * neighbors, climbs onto ledges up to a limit and drops down ledges that aren't too deep. Liquids count as
+ * neither room nor ground, so walkers keep out of water and lava, and so does the world that isn't loaded.
+ * Paths are kept while nothing changes under them: every edited block is logged and paths near one are thrown
+ * away, see blockEdits.
+ */
+
+type pathRules struct {
+	height   int32 // blocks of room a walker needs above its feet
+	climb    int32 // how many blocks up a walker can step or jump in one move
+	drop     int32 // how many blocks down a walker is willing to drop in one move
+	maxNodes int   // blocks explored before the search gives up
+}
+
+type path struct {
+	nodes    [][3]int32 // the blocks the feet pass through after the start, the last is the end
+	complete bool       // ends at the goal rather than as close to it as the search got
+	low      [3]int32   // the corners of the blocks the path was found through
+	high     [3]int32
+}
+
+// Steps between neighboring blocks
+var pathDirections = [4][2]int32{{1, 0}, {-1, 0}, {0, 1}, {0, -1}}
+
+type pathNode struct {
+	pos    [3]int32
+	cost   float32 // from the start
+	guess  float32 // cost plus the estimate to the goal
+	parent *pathNode
+	index  int // position in the heap, -1 once explored
+}
+
+type pathNodeHeap []*pathNode
+
+func (h pathNodeHeap) Len() int           { return len(h) }
+func (h pathNodeHeap) Less(i, j int) bool { return h[i].guess < h[j].guess }
+func (h pathNodeHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *pathNodeHeap) Push(x any) {
+	n := x.(*pathNode)
+	n.index = len(*h)
+	*h = append(*h, n)
+}
+
+func (h *pathNodeHeap) Pop() any {
+	old := *h
+	n := old[len(old)-1]
+	*h = old[:len(old)-1]
+	n.index = -1
+	return n
+}
+
+// findPath searches for the cheapest walk from start to goal. When the goal can't be reached within
+// rules.maxNodes explored blocks, the path leads to the explored block closest to it and isn't complete; it is
+// nil when the walker can't move from start at all.
+func findPath(world BlockReader, start, goal [3]int32, rules pathRules) *path {
+	estimate := func(pos [3]int32) float32 {
+		return float32(abs32(goal[0]-pos[0]) + abs32(goal[2]-pos[2]))
+	}
+	distance := func(pos [3]int32) int32 {
+		return abs32(goal[0]-pos[0]) + abs32(goal[1]-pos[1]) + abs32(goal[2]-pos[2])
+	}
+
+	first := &pathNode{pos: start, guess: estimate(start)}
+	nodes := map[[3]int32]*pathNode{start: first}
+	open := pathNodeHeap{}
+	heap.Push(&open, first)
+	closest := first
+	explored := 0
+
+	for open.Len() > 0 && explored < rules.maxNodes {
+		n := heap.Pop(&open).(*pathNode)
+		explored++
+		if n.pos == goal {
+			closest = n
+			break
+		}
+		if distance(n.pos) < distance(closest.pos) {
+			closest = n
+		}
+
+		for _, dir := range pathDirections {
+			next, cost, ok := pathStep(world, n.pos, dir, rules)
+			if !ok {
+				continue
+			}
+			cost += n.cost
+			if known, seen := nodes[next]; seen {
+				if known.index < 0 || cost >= known.cost {
+					continue
+				}
+				known.cost, known.guess, known.parent = cost, cost+estimate(next), n
+				heap.Fix(&open, known.index)
+				continue
+			}
+			m := &pathNode{pos: next, cost: cost, guess: cost + estimate(next), parent: n}
+			nodes[next] = m
+			heap.Push(&open, m)
+		}
+	}
+	if closest == first {
+		return nil
+	}
+
+	p := &path{complete: closest.pos == goal, low: start, high: start}
+	for n := closest; n != first; n = n.parent {
+		p.nodes = append(p.nodes, n.pos)
+	}
+	for i, j := 0, len(p.nodes)-1; i < j; i, j = i+1, j-1 {
+		p.nodes[i], p.nodes[j] = p.nodes[j], p.nodes[i]
+	}
+	for _, pos := range p.nodes {
+		for axis := range 3 {
+			p.low[axis] = min(p.low[axis], pos[axis])
+			p.high[axis] = max(p.high[axis], pos[axis])
+		}
+	}
+	// The ground under the path, the room over it and the drops down to it
+	p.low[1] -= rules.drop + 1
+	p.high[1] += rules.height + rules.climb
+	return p
+}
+
+// pathStep returns where a walker with its feet in from ends up moving one block along dir, and what that costs.
+func pathStep(world BlockReader, from [3]int32, dir [2]int32, rules pathRules) ([3]int32, float32, bool) {
+	x, z := from[0]+dir[0], from[2]+dir[1]
+	if pathPassable(world, x, from[1], z, rules.height) {
+		// Walks in level and falls to whatever is below
+		for y := from[1]; y >= from[1]-rules.drop; y-- {
+			if ground, _ := world.BlockAt(x, y-1, z); BlockProperties[ground].IsSolid {
+				return [3]int32{x, y, z}, 1 + 0.5*float32(from[1]-y), true
+			}
+			if !pathPassable(world, x, y-1, z, 1) {
+				break
+			}
+		}
+		return from, 0, false
+	}
+	// Climbs onto the lowest ledge there is room for
+	for up := int32(1); up <= rules.climb; up++ {
+		if !pathPassable(world, from[0], from[1]+rules.height+up-1, from[2], 1) {
+			break
+		}
+		if pathStandable(world, x, from[1]+up, z, rules.height) {
+			return [3]int32{x, from[1] + up, z}, 1 + float32(up), true
+		}
+	}
+	return from, 0, false
+}
+
+// pathPassable reports whether height blocks from y up are free for a walker to move through.
+func pathPassable(world BlockReader, x, y, z, height int32) bool {
+	for i := range height {
+		blockType, loaded := world.BlockAt(x, y+i, z)
+		if !loaded || BlockProperties[blockType].IsSolid || BlockProperties[blockType].Liquid {
+			return false
+		}
+	}
+	return true
+}
+
+// pathStandable reports whether a walker can stand with its feet in a block.
+func pathStandable(world BlockReader, x, y, z, height int32) bool {
+	ground, _ := world.BlockAt(x, y-1, z)
+	return BlockProperties[ground].IsSolid && pathPassable(world, x, y, z, height)
+}
+
+// touches reports whether a block is in or next to the box the path was found through.
+func (p *path) touches(block [3]int32) bool {
+	for axis := range 3 {
+		if block[axis] < p.low[axis]-1 || block[axis] > p.high[axis]+1 {
+			return false
+		}
+	}
+	return true
+}
+
+// blockEdits logs the blocks changed since the main loop last passed them to the simulation, from whichever
+// goroutine changed them, so paths through them can be found again. Past maxBlockEdits it only remembers that
+// everything changed.
+var blockEdits struct {
+	mu       sync.Mutex
+	blocks   [][3]int32
+	overflow bool
+}
+
+const maxBlockEdits = 1024
+
+func logBlockEdit(x, y, z int32) {
+	blockEdits.mu.Lock()
+	defer blockEdits.mu.Unlock()
+	if len(blockEdits.blocks) >= maxBlockEdits {
+		blockEdits.overflow = true
+		return
+	}
+	blockEdits.blocks = append(blockEdits.blocks, [3]int32{x, y, z})
+}
+
+// takeBlockEdits returns the blocks changed since the last call, everything reports whether there were too many
+// to list.
+func takeBlockEdits() (blocks [][3]int32, everything bool) {
+	blockEdits.mu.Lock()
+	defer blockEdits.mu.Unlock()
+	blocks, everything = blockEdits.blocks, blockEdits.overflow
+	blockEdits.blocks, blockEdits.overflow = nil, false
+	return blocks, everything
+}
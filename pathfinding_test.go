@@ -0,0 +1,235 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// mazeWorld is a hand-drawn world seen from above, rows along z and columns along x. A digit is stone up to
+// under that height, so feet stand in the block at the digit, '.' is the same as '1', '#' a wall too high to climb,
+// '~' water over stone and '=' a floor at 1 under a ceiling at 2. Everything outside the drawing isn't loaded.
+type mazeWorld []string
+
+func maze(drawing string) mazeWorld {
+	return strings.Split(strings.TrimSpace(drawing), "\n")
+}
+
+func (m mazeWorld) BlockAt(x, y, z int32) (uint16, bool) {
+	if z < 0 || int(z) >= len(m) || x < 0 || int(x) >= len(m[z]) {
+		return AirID, false
+	}
+	floor := int32(1)
+	switch c := m[z][x]; {
+	case c == '#':
+		floor = 9
+	case c == '~':
+		if y == 0 {
+			return WaterID, true
+		}
+	case c == '=':
+		if y == 2 {
+			return StoneID, true
+		}
+	case c >= '0' && c <= '9':
+		floor = int32(c - '0')
+	}
+	if y < floor {
+		return StoneID, true
+	}
+	return AirID, true
+}
+
+// tall walks like a sheep: two blocks high, climbing one block and dropping three.
+var tall = pathRules{height: 2, climb: 1, drop: 3, maxNodes: PATH_MAX_NODES}
+
+// assertWalkable checks that every node of a path is a single step from the one before and can be stood in.
+func assertWalkable(t *testing.T, world BlockReader, start [3]int32, p *path, rules pathRules) {
+	t.Helper()
+	previous := start
+	for _, node := range p.nodes {
+		if abs32(node[0]-previous[0])+abs32(node[2]-previous[2]) != 1 {
+			t.Fatalf("path steps from %v to %v", previous, node)
+		}
+		if node[1]-previous[1] > rules.climb || previous[1]-node[1] > rules.drop {
+			t.Fatalf("path climbs or drops from %v to %v", previous, node)
+		}
+		if !pathStandable(world, node[0], node[1], node[2], rules.height) {
+			t.Fatalf("path goes through %v, which can't be stood in", node)
+		}
+		previous = node
+	}
+}
+
+// findComplete finds a path from start to goal, failing unless it reaches the goal.
+func findComplete(t *testing.T, world BlockReader, start, goal [3]int32, rules pathRules) *path {
+	t.Helper()
+	p := findPath(world, start, goal, rules)
+	if p == nil || !p.complete {
+		t.Fatalf("no path from %v to %v: %+v", start, goal, p)
+	}
+	assertWalkable(t, world, start, p, rules)
+	return p
+}
+
+func TestPathThroughMaze(t *testing.T) {
+	world := maze(`
+#########
+#.......#
+#######.#
+#.......#
+#.#######
+#.......#
+#########`)
+	p := findComplete(t, world, [3]int32{1, 1, 1}, [3]int32{7, 1, 5}, tall)
+	if len(p.nodes) != 6+2+6+2+6 {
+		t.Errorf("path through the maze takes %d steps, want 22", len(p.nodes))
+	}
+	if p.nodes[len(p.nodes)-1] != [3]int32{7, 1, 5} {
+		t.Errorf("path ends at %v", p.nodes[len(p.nodes)-1])
+	}
+
+	// Around the water and the unloaded world rather than through them
+	world = maze(`
+.~.
+.~.
+...`)
+	p = findComplete(t, world, [3]int32{0, 1, 0}, [3]int32{2, 1, 0}, tall)
+	if len(p.nodes) != 6 {
+		t.Errorf("path around the water takes %d steps, want 6", len(p.nodes))
+	}
+
+	// Boxed in it goes nowhere
+	if p := findPath(maze(`#.#`), [3]int32{1, 1, 0}, [3]int32{5, 1, 0}, tall); p != nil {
+		t.Errorf("found %+v out of a box", p)
+	}
+}
+
+func TestPathClimbLimits(t *testing.T) {
+	// A one block step is climbed, a two block one isn't
+	p := findComplete(t, maze(`..2..`), [3]int32{0, 1, 0}, [3]int32{4, 1, 0}, tall)
+	if len(p.nodes) != 4 || p.nodes[1] != [3]int32{2, 2, 0} {
+		t.Errorf("path over a one block step: %v", p.nodes)
+	}
+	if p := findPath(maze(`..3..`), [3]int32{0, 1, 0}, [3]int32{4, 1, 0}, tall); p == nil || p.complete {
+		t.Errorf("path over a two block step: %+v", p)
+	}
+	climber := tall
+	climber.climb = 2
+	findComplete(t, maze(`..3..`), [3]int32{0, 1, 0}, [3]int32{4, 1, 0}, climber)
+
+	// Walking on needs room for the walker's height, and stepping up room over the head before the step
+	if p := findPath(maze(`.=.`), [3]int32{0, 1, 0}, [3]int32{2, 1, 0}, tall); p != nil && p.complete {
+		t.Errorf("a two block walker went through a one block gap: %v", p.nodes)
+	}
+	short := tall
+	short.height = 1
+	findComplete(t, maze(`.=.`), [3]int32{0, 1, 0}, [3]int32{2, 1, 0}, short)
+	world := maze(`
+.=2
+..2`)
+	p = findComplete(t, world, [3]int32{1, 1, 0}, [3]int32{2, 2, 0}, short)
+	if len(p.nodes) != 3 || p.nodes[0] != [3]int32{1, 1, 1} {
+		t.Errorf("path up a step from under a ceiling: %v", p.nodes)
+	}
+}
+
+func TestPathDropLimits(t *testing.T) {
+	// Three blocks down is dropped, four isn't
+	findComplete(t, maze(`4.`), [3]int32{0, 4, 0}, [3]int32{1, 1, 0}, tall)
+	if p := findPath(maze(`5.`), [3]int32{0, 5, 0}, [3]int32{1, 1, 0}, tall); p != nil {
+		t.Errorf("dropped four blocks: %+v", p)
+	}
+
+	// A drop the walker can't climb back up is one way
+	world := maze(`
+3..
+333`)
+	findComplete(t, world, [3]int32{0, 3, 0}, [3]int32{2, 1, 0}, tall)
+	if p := findPath(world, [3]int32{2, 1, 0}, [3]int32{0, 3, 0}, tall); p != nil && p.complete {
+		t.Errorf("climbed back up a drop: %v", p.nodes)
+	}
+}
+
+func TestPathNodeLimit(t *testing.T) {
+	// A field too big to cross within the limit ends as close to the goal as the search got
+	world := maze(strings.Repeat(strings.Repeat(".", 60)+"\n", 60))
+	start, goal := [3]int32{0, 1, 0}, [3]int32{59, 1, 59}
+	limited := tall
+	limited.maxNodes = 50
+	p := findPath(world, start, goal, limited)
+	if p == nil || p.complete {
+		t.Fatalf("crossed the field within 50 nodes: %+v", p)
+	}
+	assertWalkable(t, world, start, p, limited)
+	if end := p.nodes[len(p.nodes)-1]; end[0]+end[2] < 8 || end[0]+end[2] > 50 {
+		t.Errorf("the path stopped at %v", end)
+	}
+	p = findComplete(t, world, start, goal, tall)
+	if len(p.nodes) != 118 {
+		t.Errorf("path across the field takes %d steps, want 118", len(p.nodes))
+	}
+
+	// Walled off from the goal, the search explores at most PATH_MAX_NODES blocks before giving up
+	world = maze(strings.Repeat(strings.Repeat(".", 200)+"#.\n", 200))
+	explored := make(map[[3]int32]bool)
+	counting := countingReader{world, explored}
+	p = findPath(counting, [3]int32{0, 1, 0}, [3]int32{201, 1, 0}, tall)
+	if p == nil || p.complete || p.nodes[len(p.nodes)-1] != [3]int32{199, 1, 0} {
+		t.Fatalf("path to a walled off goal: %+v", p)
+	}
+	if len(explored) > 5*PATH_MAX_NODES {
+		t.Errorf("the search looked at %d columns", len(explored))
+	}
+}
+
+// countingReader records every column a search looks at.
+type countingReader struct {
+	BlockReader
+	columns map[[3]int32]bool
+}
+
+func (r countingReader) BlockAt(x, y, z int32) (uint16, bool) {
+	r.columns[[3]int32{x, 0, z}] = true
+	return r.BlockReader.BlockAt(x, y, z)
+}
+
+func TestPathTouches(t *testing.T) {
+	// A path along x at height 3, with a drop to 1 at its end
+	world := maze(`333331`)
+	p := findComplete(t, world, [3]int32{0, 3, 0}, [3]int32{5, 1, 0}, tall)
+
+	// Everything it stands on, moves through and could drop or climb into counts, with a block of margin
+	for _, block := range [][3]int32{
+		{2, 2, 0},  // the ground under it
+		{3, 4, 0},  // the room over it
+		{4, 3, 1},  // beside it
+		{5, 0, 0},  // under the end of the drop
+		{-1, 3, 0}, // behind the start
+		{3, 6, -1}, // over the head, where a climb would need room
+	} {
+		if !p.touches(block) {
+			t.Errorf("an edit at %v doesn't touch the path", block)
+		}
+	}
+	for _, block := range [][3]int32{{7, 1, 0}, {2, 3, 2}, {2, 8, 0}, {2, -5, 0}} {
+		if p.touches(block) {
+			t.Errorf("an edit at %v touches the path", block)
+		}
+	}
+
+	// A mob drops the path when a block it touches is edited, or when too many were to list them
+	m := &mob{path: p}
+	m.blocksChanged([][3]int32{{30, 1, 30}, {7, 1, 0}}, false)
+	if m.path == nil {
+		t.Error("edits away from the path dropped it")
+	}
+	m.blocksChanged([][3]int32{{30, 1, 30}, {4, 2, 0}}, false)
+	if m.path != nil {
+		t.Error("an edit under the path kept it")
+	}
+	m.path = p
+	m.blocksChanged(nil, true)
+	if m.path != nil {
+		t.Error("changing everything kept the path")
+	}
+}
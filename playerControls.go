@@ -10,147 +10,7 @@ import (
 
 var clickDelayAccumulator float32
 var clickDeltaTimeDelay float32 = float32(1.0 / 8.0)
-
-func velocityDamping(damping float32) {
-	dampenVert := (1.0 - damping)
-	dampenHoriz := (1.0 - damping)
-	airMultiplier := float32(0.93) //In Air (while jumping, etc) horizontal resistance 7% decrease
-	sprintMultiplier := float32(2) // sprint jump = 14% decrease
-	if !isOnGround {
-		dampenHoriz = (1.0 - (damping * airMultiplier))
-		if isSprinting {
-			dampenHoriz = 1.0 - (damping * (1 - ((1 - airMultiplier) * sprintMultiplier)))
-		}
-	}
-	velocity[0] *= dampenHoriz
-	velocity[2] *= dampenHoriz
-	if isFlying {
-		velocity[1] *= dampenVert
-	}
-}
-
-// true = delete, false = create
-func raycast(action bool) {
-	/*
-		var tMaxX, tMaxY, tMaxZ, tDeltaX, tDeltaY, tDeltaZ float32
-		var startPoint mgl32.Vec3 = cameraPosition.Add(mgl32.Vec3{0.5, 0.5, 0.5}).Sub(cameraFront.Mul(0.1))
-		var endPoint mgl32.Vec3 = startPoint.Add(cameraFront.Mul(5))
-		var hitPoint mgl32.Vec3 = startPoint
-		var dx float32 = sign(endPoint.X() - startPoint.X())
-		if dx != 0 {
-			tDeltaX = float32(math.Min(float64((dx / (endPoint.X() - startPoint.X()))), 10000000))
-		} else {
-			tDeltaX = 10000000
-		}
-		if dx > 0 {
-			tMaxX = tDeltaX * frac1(startPoint.X())
-		} else {
-			tMaxX = tDeltaX * frac0(startPoint.X())
-		}
-
-		var dy float32 = sign(endPoint.Y() - startPoint.Y())
-		if dy != 0 {
-			tDeltaY = float32(math.Min(float64((dy / (endPoint.Y() - startPoint.Y()))), 10000000))
-		} else {
-			tDeltaY = 10000000
-		}
-		if dy > 0 {
-			tMaxY = tDeltaY * frac1(startPoint.Y())
-		} else {
-			tMaxY = tDeltaY * frac0(startPoint.Y())
-		}
-
-		var dz float32 = sign(endPoint.Z() - startPoint.Z())
-		if dz != 0 {
-			tDeltaZ = float32(math.Min(float64((dz / (endPoint.Z() - startPoint.Z()))), 10000000))
-		} else {
-			tDeltaZ = 10000000
-		}
-		if dz > 0 {
-			tMaxZ = tDeltaZ * frac1(startPoint.Z())
-		} else {
-			tMaxZ = tDeltaZ * frac0(startPoint.Z())
-		}
-		for !(tMaxX > 1 && tMaxY > 1 && tMaxZ > 1) {
-
-			ChunkPos := chunkPosition{
-				int32(math.Floor(float64(hitPoint[0] / float32(CHUNK_SIZE)))),
-				int32(math.Floor(float64(hitPoint[1] / float32(CHUNK_SIZE)))),
-				int32(math.Floor(float64(hitPoint[2] / float32(CHUNK_SIZE)))),
-			}
-
-			pos := blockPosition{
-				uint8(math.Floor(float64(hitPoint[0]) - float64(ChunkPos.x*int32(CHUNK_SIZE)))),
-				uint8(math.Floor(float64(hitPoint[1]) - float64(ChunkPos.y*int32(CHUNK_SIZE)))),
-				uint8(math.Floor(float64(hitPoint[2]) - float64(ChunkPos.z*int32(CHUNK_SIZE)))),
-			}
-			absPos := mgl32.Vec3{
-				float32(math.Floor(float64(hitPoint[0]))),
-				float32(math.Floor(float64(hitPoint[1]))),
-				float32(math.Floor(float64(hitPoint[2]))),
-			}
-			if action {
-				chunksMu.RLock()
-				chunk, ok := chunks[ChunkPos]
-				chunksMu.RUnlock()
-				if ok {
-
-					if chunk.blocksData[pos.x][pos.y][pos.z].isSolid() {
-						breakBlock(pos, ChunkPos)
-						return
-					}
-
-				}
-
-			}
-			if tMaxX < tMaxY {
-				if tMaxX < tMaxZ {
-					hitPoint[0] += dx
-					tMaxX += tDeltaX
-				} else {
-					hitPoint[2] += dz
-					tMaxZ += tDeltaZ
-				}
-			} else {
-				if tMaxY < tMaxZ {
-					hitPoint[1] += dy
-					tMaxY += tDeltaY
-				} else {
-					hitPoint[2] += dz
-					tMaxZ += tDeltaZ
-				}
-			}
-			if !action {
-
-				tempChunkPos := chunkPosition{
-					int32(math.Floor(float64(hitPoint[0] / 32))),
-					int32(math.Floor(float64(hitPoint[1] / 32))),
-					int32(math.Floor(float64(hitPoint[2] / 32))),
-				}
-				tempPos := blockPosition{uint8(math.Floor(float64(hitPoint[0]) - float64(tempChunkPos.x*32))), uint8(math.Floor(float64(hitPoint[1]) - float64(tempChunkPos.y*32))), uint8(math.Floor(float64(hitPoint[2]) - float64(tempChunkPos.z*32)))}
-				chunksMu.RLock()
-				chunk, ok := chunks[tempChunkPos]
-				chunksMu.RUnlock()
-				if ok {
-					if block := chunk.blocksData[tempPos.x][tempPos.y][tempPos.z]; block.isSolid() {
-
-						isCollidingWithPlayer := IsCollidingWithPlacedBlock(absPos)
-						//place a block if there is no block at the position and it is not colliding with the player
-
-						if !chunk.blocksData[pos.x][pos.y][pos.z].isSolid() && !isCollidingWithPlayer {
-							placeBlock(pos, ChunkPos, DirtID)
-
-							return
-						}
-
-					}
-				}
-
-			}
-
-		}
-	*/
-}
+var pendingActions InputCommand // one-off actions of key presses and clicks, sent with the next tick's command
 
 func input(window *glfw.Window, key glfw.Key, scancode int, action glfw.Action, mods glfw.ModifierKey) {
 
@@ -160,13 +20,26 @@ func input(window *glfw.Window, key glfw.Key, scancode int, action glfw.Action,
 			showDebug = !showDebug
 		}
 		if key == glfw.KeyF {
-			isFlying = !isFlying
+			pendingActions.ToggleFlying = true
+		}
+		if key == glfw.KeyG {
+			pendingActions.Throw = true
 		}
 		if key == glfw.KeyF6 {
 			AmbientOcclusion = !AmbientOcclusion
 			fmt.Printf("Ambient Occlusion: %v\n", AmbientOcclusion)
+			go remeshLoadedChunks()
 
 		}
+		if key == glfw.KeyF7 {
+			GreedyMeshing = !GreedyMeshing
+			fmt.Printf("Greedy Meshing: %v\n", GreedyMeshing)
+			go remeshLoadedChunks()
+		}
+		if key == glfw.KeyF8 {
+			CaveCulling = !CaveCulling
+			fmt.Printf("Cave Culling: %v\n", CaveCulling)
+		}
 		if key == glfw.KeyEscape {
 			shouldLockMouse = !shouldLockMouse
 		}
@@ -183,13 +56,6 @@ func input(window *glfw.Window, key glfw.Key, scancode int, action glfw.Action,
 			}
 		}
 	}
-	if action == glfw.Release {
-		if key == glfw.KeyLeftShift {
-			if isSprinting {
-				isSprinting = false
-			}
-		}
-	}
 
 }
 
@@ -242,71 +108,44 @@ func mouseInputCallback(window *glfw.Window, button glfw.MouseButton, action glf
 		shouldLockMouse = true
 		clickDelayAccumulator = 0
 		if button == glfw.MouseButtonRight {
-			raycast(false)
+			pendingActions.Place = DirtID
 		}
 
 		if button == glfw.MouseButtonLeft {
-			raycast(true)
+			pendingActions.Break = true
 		}
 	}
 }
 
-// Movement inputs, gets checked each frame for fast responses.
-func movement(window *glfw.Window) {
-
-	movementSpeed = WALKING_SPEED
-
-	if isFlying {
-		movementSpeed = FLYING_SPEED
-		if window.GetKey(glfw.KeySpace) == glfw.Press {
-			if window.GetKey(glfw.KeyLeftShift) == glfw.Press {
-				velocity[1] -= movementSpeed * deltaTime
-			} else {
-				velocity[1] += movementSpeed * deltaTime
-			}
-		}
-
-	}
-
-	if window.GetKey(glfw.KeyLeftShift) == glfw.Press {
-		movementSpeed *= RUNNING_SPEED
-		isSprinting = true
-	}
-
-	var direction mgl32.Vec3
-	if window.GetKey(glfw.KeyW) == glfw.Press {
-		direction = direction.Add(orientationFront)
-	}
-	if window.GetKey(glfw.KeyS) == glfw.Press {
-		direction = direction.Sub(orientationFront)
-	}
-	if window.GetKey(glfw.KeyA) == glfw.Press {
-		direction = direction.Sub(cameraRight)
-	}
-	if window.GetKey(glfw.KeyD) == glfw.Press {
-		direction = direction.Add(cameraRight)
-	}
-
-	if direction.Len() > 0 {
-		direction = direction.Normalize()
-	}
-
-	velocity = velocity.Add(direction.Mul(movementSpeed * deltaTime))
-
-	if window.GetKey(glfw.KeySpace) == glfw.Press {
-		if !isOnGround || jumpCooldown != 0 {
-			return
-		}
-		jumpCooldown = 0.05
-		velocity[1] += JUMP_HEIGHT
-
-	}
-	if window.GetKey(glfw.KeySpace) == glfw.Press {
-		if !isOnGround || jumpCooldown != 0 {
-			return
-		}
-		jumpCooldown = 0.05
-		velocity[1] += JUMP_HEIGHT
-
-	}
+// readInput samples the movement keys into the command for the next tick.
+func readInput(window *glfw.Window) InputCommand {
+	pressed := func(key glfw.Key) bool {
+		return window.GetKey(key) == glfw.Press
+	}
+	axis := func(positive, negative glfw.Key) float32 {
+		var v float32
+		if pressed(positive) {
+			v++
+		}
+		if pressed(negative) {
+			v--
+		}
+		return v
+	}
+
+	cmd := InputCommand{
+		Forward:      axis(glfw.KeyW, glfw.KeyS),
+		Right:        axis(glfw.KeyD, glfw.KeyA),
+		Yaw:          float32(yaw),
+		Pitch:        float32(pitch),
+		Jump:         pressed(glfw.KeySpace),
+		Sprint:       pressed(glfw.KeyLeftShift),
+		Crouch:       pressed(glfw.KeyLeftControl),
+		ToggleFlying: pendingActions.ToggleFlying,
+		Throw:        pendingActions.Throw,
+		Break:        pendingActions.Break,
+		Place:        pendingActions.Place,
+	}
+	pendingActions = InputCommand{}
+	return cmd
 }
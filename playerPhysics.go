@@ -0,0 +1,116 @@
+package main
+
+import "github.com/go-gl/mathgl/mgl32"
+
+/*
+ * Walking physics: gravity, crouching, climbing and swimming. Every tick the blocks around the player are looked
+ * up and their BlockProperty decides how the player moves: the Friction of the ground slows walking down, a
+ * Climbable block lets the player climb while touching it and a Liquid block makes the player swim, slowed by
+ * the liquid's Friction in every direction.
+ */
+
+const (
+	gravity               float32 = 0.02 // blocks per tick, per tick
+	liquidGravityScale    float32 = 0.2  // sinking in a liquid is this much slower than falling
+	swimUpSpeed           float32 = 0.5  // velocity gained per second swimming up
+	swimSpeedMultiplier   float32 = 0.5
+	crouchSpeedMultiplier float32 = 0.3
+	climbSpeed            float32 = 0.1  // blocks per tick up or down a climbable block
+	jumpCooldownTicks             = 5    // ticks after a jump before the player can jump again
+	crouchEdgeDrop        float32 = 0.5  // crouching keeps the player from walking off drops deeper than this
+	edgeProbeStep         float32 = 0.05 // how much the motion is shortened at a time looking for the edge
+)
+
+// What the player stands on, is in and touches
+type playerSurroundings struct {
+	ground   uint16 // the solid block under the feet, AirID when there is none
+	liquid   uint16 // the liquid the body is in, AirID when dry
+	climbing bool   // touching a climbable block
+}
+
+func (p *playerState) swimming() bool {
+	return !p.flying && p.surroundings.liquid != AirID
+}
+
+// physics moves the walking player through one tick.
+func (p *playerState) physics(cmd InputCommand, world BlockReader) {
+	solid := collidable(world)
+	p.updateCrouch(cmd.Crouch, solid)
+	p.surroundings = senseSurroundings(p.box(), func(x, y, z int32) uint16 {
+		blockType, _ := world.BlockAt(x, y, z)
+		return blockType
+	})
+
+	switch {
+	case p.surroundings.liquid != AirID:
+		p.velocity[1] -= gravity * liquidGravityScale
+	case p.surroundings.climbing:
+		p.velocity[1] = max(p.velocity[1]-gravity, -climbSpeed)
+		if cmd.Jump || cmd.Forward > 0 {
+			p.velocity[1] = climbSpeed
+		} else if p.crouching {
+			p.velocity[1] = max(p.velocity[1], 0)
+		}
+	default:
+		p.velocity[1] -= gravity
+	}
+	p.collide(solid)
+}
+
+// updateCrouch crouches while the crouch key is held and stands back up once there is room above the head.
+// Crouching lowers the eyes and shrinks the player's box with them.
+func (p *playerState) updateCrouch(wantsCrouch bool, solid func(x, y, z int32) bool) {
+	drop := PLAYER_EYE_HEIGHT - PLAYER_CROUCH_EYE_HEIGHT
+	switch {
+	case wantsCrouch && !p.crouching:
+		p.crouching = true
+		p.position[1] -= drop
+		p.eyeHeight, p.height = PLAYER_CROUCH_EYE_HEIGHT, p.height-drop
+	case !wantsCrouch && p.crouching:
+		standing := p.position.Add(mgl32.Vec3{0, drop, 0})
+		if boxFits(playerAABB(standing, PLAYER_EYE_HEIGHT), solid) {
+			p.crouching = false
+			p.position = standing
+			p.eyeHeight, p.height = PLAYER_EYE_HEIGHT, p.height+drop
+		}
+	}
+}
+
+// senseSurroundings looks up the ground under a player box, the liquid it is in and whether it touches a
+// climbable block.
+func senseSurroundings(box aabb, blockAt func(x, y, z int32) uint16) playerSurroundings {
+	var s playerSurroundings
+	center := box.Min.Add(box.Max).Mul(0.5)
+
+	// The block under the middle of the feet, or any other the feet stand on
+	groundY := blockCoord(box.Min[1] - edgeProbeStep)
+	if blockType := blockAt(blockCoord(center[0]), groundY, blockCoord(center[2])); BlockProperties[blockType].IsSolid {
+		s.ground = blockType
+	} else {
+		for x := blockCoord(box.Min[0]); x <= blockCoord(box.Max[0]) && s.ground == AirID; x++ {
+			for z := blockCoord(box.Min[2]); z <= blockCoord(box.Max[2]); z++ {
+				if !overlapsAcross(box, blockAABB(x, groundY, z), 1) {
+					continue
+				}
+				if blockType := blockAt(x, groundY, z); BlockProperties[blockType].IsSolid {
+					s.ground = blockType
+					break
+				}
+			}
+		}
+	}
+
+	// In a liquid once it reaches a little above the feet
+	if blockType := blockAt(blockCoord(center[0]), blockCoord(box.Min[1]+0.4), blockCoord(center[2])); BlockProperties[blockType].Liquid {
+		s.liquid = blockType
+	}
+
+	// Climbable blocks count when the box overlaps or presses against them from the side, not from above
+	reach := AABB(box.Min.Sub(mgl32.Vec3{edgeProbeStep, -collisionEpsilon, edgeProbeStep}), box.Max.Add(mgl32.Vec3{edgeProbeStep, -collisionEpsilon, edgeProbeStep}))
+	forEachBlockIn(reach, func(x, y, z int32) {
+		if BlockProperties[blockAt(x, y, z)].Climbable && overlapsAcross(reach, blockAABB(x, y, z), -1) {
+			s.climbing = true
+		}
+	})
+	return s
+}
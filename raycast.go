@@ -0,0 +1,115 @@
+package main
+
+import (
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+/*
+ * Block raycasting: a DDA walk through the block grid (Amanatides & Woo). The ray steps from cell to cell,
+ * always crossing whichever cell boundary comes first, so it visits every block it passes through in order and
+ * never skips one at an edge or corner. Block centers sit at integer positions, so the grid is offset by half a
+ * block.
+ */
+
+type raycastHit struct {
+	block    [3]int32 // world position of the block the ray hit
+	face     uint8    // the face of that block the ray entered through
+	adjacent [3]int32 // the cell in front of that face, where a placed block goes
+	distance float32  // along the ray, in blocks
+}
+
+// The faces a positive and a negative step along each axis enter the next block through
+var enteredFaces = [3][2]uint8{
+	{FACE_MAP.LEFT, FACE_MAP.RIGHT},
+	{FACE_MAP.DOWN, FACE_MAP.UP},
+	{FACE_MAP.BACK, FACE_MAP.FRONT},
+}
+
+// raycast follows a ray from origin along dir for up to maxDistance blocks and returns the first block hit
+// accepts. The block the ray starts in is never hit.
+func raycast(origin, dir mgl32.Vec3, maxDistance float32, hit func(x, y, z int32) bool) (raycastHit, bool) {
+	if dir.Len() == 0 {
+		return raycastHit{}, false
+	}
+	dir = dir.Normalize()
+
+	var cell [3]int32
+	var step [3]int32
+	var tMax, tDelta [3]float32
+	for axis := range 3 {
+		o := float64(origin[axis]) + 0.5
+		cell[axis] = int32(math.Floor(o))
+		switch {
+		case dir[axis] > 0:
+			step[axis] = 1
+			tDelta[axis] = 1 / dir[axis]
+			tMax[axis] = float32(float64(cell[axis])+1-o) * tDelta[axis]
+		case dir[axis] < 0:
+			step[axis] = -1
+			tDelta[axis] = -1 / dir[axis]
+			tMax[axis] = float32(o-float64(cell[axis])) * tDelta[axis]
+		default:
+			tDelta[axis] = float32(math.Inf(1))
+			tMax[axis] = float32(math.Inf(1))
+		}
+	}
+
+	for {
+		axis := 0
+		if tMax[1] < tMax[axis] {
+			axis = 1
+		}
+		if tMax[2] < tMax[axis] {
+			axis = 2
+		}
+		distance := tMax[axis]
+		if distance > maxDistance {
+			return raycastHit{}, false
+		}
+		cell[axis] += step[axis]
+		tMax[axis] += tDelta[axis]
+
+		if hit(cell[0], cell[1], cell[2]) {
+			face := enteredFaces[axis][0]
+			if step[axis] < 0 {
+				face = enteredFaces[axis][1]
+			}
+			normal := faceNormals[face]
+			return raycastHit{
+				block:    cell,
+				face:     face,
+				adjacent: [3]int32{cell[0] + int32(normal.x), cell[1] + int32(normal.y), cell[2] + int32(normal.z)},
+				distance: distance,
+			}, true
+		}
+	}
+}
+
+// blockTypeAt returns the block at a world position, air where nothing is loaded. The caller must hold pillarsMu.
+func blockTypeAt(x, y, z int32) uint16 {
+	pos := worldToChunkBlock(x, y, z)
+	pillar := pillars[pos.chunkPos.pillarPos]
+	if pillar == nil {
+		return AirID
+	}
+	ch := pillar.chunkOrSky(pos.chunkPos.y)
+	if ch == nil {
+		return AirID
+	}
+	return ch.getBlockType(pos.blockPos.x, pos.blockPos.y, pos.blockPos.z)
+}
+
+// isTargetable reports whether the crosshair stops at a block, which is every block but air and fluids.
+func isTargetable(blockType uint16) bool {
+	return BlockProperties[blockType].IsSolid || BlockProperties[blockType].IsPlant
+}
+
+// targetBlock returns the block a player looking where cmd says looks at, if one is within reach.
+func (p *playerState) targetBlock(cmd InputCommand, world BlockReader) (raycastHit, bool) {
+	return raycast(p.position, lookDirection(cmd.Yaw, cmd.Pitch), BLOCK_REACH, func(x, y, z int32) bool {
+		blockType, _ := world.BlockAt(x, y, z)
+		return isTargetable(blockType)
+	})
+}
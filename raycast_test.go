@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// blockSet is a hit function for a handful of solid blocks, recording every cell the ray visits.
+type blockSet struct {
+	solid   map[[3]int32]bool
+	visited [][3]int32
+}
+
+func newBlockSet(blocks ...[3]int32) *blockSet {
+	s := &blockSet{solid: make(map[[3]int32]bool)}
+	for _, block := range blocks {
+		s.solid[block] = true
+	}
+	return s
+}
+
+func (s *blockSet) hit(x, y, z int32) bool {
+	s.visited = append(s.visited, [3]int32{x, y, z})
+	return s.solid[[3]int32{x, y, z}]
+}
+
+// assertWalk checks that every cell the ray visited is a face neighbor of the one before, starting next to start.
+func (s *blockSet) assertWalk(t *testing.T, start [3]int32) {
+	t.Helper()
+	previous := start
+	for _, cell := range s.visited {
+		if d := abs32(cell[0]-previous[0]) + abs32(cell[1]-previous[1]) + abs32(cell[2]-previous[2]); d != 1 {
+			t.Fatalf("ray jumped from %v to %v", previous, cell)
+		}
+		previous = cell
+	}
+}
+
+func assertHit(t *testing.T, name string, got raycastHit, ok bool, want raycastHit) {
+	t.Helper()
+	if !ok {
+		t.Errorf("%s: nothing hit", name)
+		return
+	}
+	if got.block != want.block || got.face != want.face || got.adjacent != want.adjacent || mgl32.Abs(got.distance-want.distance) > 1e-4 {
+		t.Errorf("%s: hit %+v, want %+v", name, got, want)
+	}
+}
+
+func TestRaycastAxisAligned(t *testing.T) {
+	// A block three cells away in each direction from a ray starting 0.25 off the center of the origin block
+	origin := mgl32.Vec3{0.25, 0.25, 0.25}
+	f := FACE_MAP
+	for _, c := range []struct {
+		dir  mgl32.Vec3
+		want raycastHit
+	}{
+		{mgl32.Vec3{1, 0, 0}, raycastHit{[3]int32{3, 0, 0}, f.LEFT, [3]int32{2, 0, 0}, 2.25}},
+		{mgl32.Vec3{-1, 0, 0}, raycastHit{[3]int32{-3, 0, 0}, f.RIGHT, [3]int32{-2, 0, 0}, 2.75}},
+		{mgl32.Vec3{0, 1, 0}, raycastHit{[3]int32{0, 3, 0}, f.DOWN, [3]int32{0, 2, 0}, 2.25}},
+		{mgl32.Vec3{0, -1, 0}, raycastHit{[3]int32{0, -3, 0}, f.UP, [3]int32{0, -2, 0}, 2.75}},
+		{mgl32.Vec3{0, 0, 1}, raycastHit{[3]int32{0, 0, 3}, f.BACK, [3]int32{0, 0, 2}, 2.25}},
+		{mgl32.Vec3{0, 0, -1}, raycastHit{[3]int32{0, 0, -3}, f.FRONT, [3]int32{0, 0, -2}, 2.75}},
+	} {
+		// The origin block itself is solid and never hit
+		blocks := newBlockSet([3]int32{}, [3]int32{3, 0, 0}, [3]int32{-3, 0, 0}, [3]int32{0, 3, 0}, [3]int32{0, -3, 0}, [3]int32{0, 0, 3}, [3]int32{0, 0, -3})
+		hit, ok := raycast(origin, c.dir.Mul(7), 5, blocks.hit)
+		assertHit(t, fmt.Sprint("along ", c.dir), hit, ok, c.want)
+		blocks.assertWalk(t, [3]int32{})
+
+		// Out of reach
+		if _, ok := raycast(origin, c.dir, c.want.distance-0.01, blocks.hit); ok {
+			t.Errorf("along %v: hit a block past the reach", c.dir)
+		}
+	}
+
+	if _, ok := raycast(origin, mgl32.Vec3{}, 5, newBlockSet([3]int32{1, 0, 0}).hit); ok {
+		t.Error("a ray without a direction hit something")
+	}
+}
+
+func TestRaycastDiagonalCorners(t *testing.T) {
+	// Straight through the edge two blocks share: the ray can't slip between them
+	blocks := newBlockSet([3]int32{1, 0, 0}, [3]int32{0, 1, 0}, [3]int32{1, 1, 0})
+	hit, ok := raycast(mgl32.Vec3{}, mgl32.Vec3{1, 1, 0}, 5, blocks.hit)
+	if !ok || hit.block == [3]int32{1, 1, 0} {
+		t.Errorf("ray through an edge went between the blocks on either side, hit %+v", hit)
+	}
+
+	// With the sides open it reaches the block beyond, stepping one axis at a time
+	blocks = newBlockSet([3]int32{1, 1, 0})
+	hit, ok = raycast(mgl32.Vec3{}, mgl32.Vec3{1, 1, 0}, 5, blocks.hit)
+	assertHit(t, "edge", hit, ok, raycastHit{[3]int32{1, 1, 0}, hit.face, hit.adjacent, 0.5 * 1.4142135})
+	blocks.assertWalk(t, [3]int32{})
+	if normal := faceNormals[hit.face]; hit.adjacent != [3]int32{1 + int32(normal.x), 1 + int32(normal.y), int32(normal.z)} || (hit.face != FACE_MAP.LEFT && hit.face != FACE_MAP.DOWN) {
+		t.Errorf("edge: entered through face %d with the placed block going to %v", hit.face, hit.adjacent)
+	}
+
+	// Through the corners of three blocks at once, and on along a long diagonal without skipping a cell
+	blocks = newBlockSet([3]int32{4, 4, 4})
+	hit, ok = raycast(mgl32.Vec3{}, mgl32.Vec3{1, 1, 1}, 10, blocks.hit)
+	if !ok || hit.block != [3]int32{4, 4, 4} || mgl32.Abs(hit.distance-3.5*1.7320508) > 1e-4 {
+		t.Errorf("corner diagonal: hit %+v, %v", hit, ok)
+	}
+	blocks.assertWalk(t, [3]int32{})
+	if len(blocks.visited) != 12 {
+		t.Errorf("corner diagonal visited %d cells on the way to 4,4,4, want 12", len(blocks.visited))
+	}
+
+	blocks = newBlockSet()
+	raycast(mgl32.Vec3{0.1, 0.2, 0.3}, mgl32.Vec3{0.7, -0.5, 0.3}, 30, blocks.hit)
+	blocks.assertWalk(t, [3]int32{0, 0, 0})
+}
+
+func TestRaycastNegativeCoordinates(t *testing.T) {
+	// Cells round towards negative infinity: -20.3 is in block -20, whose border is at -20.5
+	blocks := newBlockSet([3]int32{-23, -6, -41}, [3]int32{-20, -9, -41}, [3]int32{-20, -6, -44})
+	start := [3]int32{-20, -6, -41}
+	origin := mgl32.Vec3{-20.3, -5.8, -40.9}
+	hit, ok := raycast(origin, mgl32.Vec3{-1, 0, 0}, 5, blocks.hit)
+	assertHit(t, "-x", hit, ok, raycastHit{[3]int32{-23, -6, -41}, FACE_MAP.RIGHT, [3]int32{-22, -6, -41}, 2.2})
+	blocks.assertWalk(t, start)
+
+	hit, ok = raycast(origin, mgl32.Vec3{0, -1, 0}, 5, blocks.hit)
+	assertHit(t, "-y", hit, ok, raycastHit{[3]int32{-20, -9, -41}, FACE_MAP.UP, [3]int32{-20, -8, -41}, 2.7})
+
+	hit, ok = raycast(origin, mgl32.Vec3{0, 0, -1}, 5, blocks.hit)
+	assertHit(t, "-z", hit, ok, raycastHit{[3]int32{-20, -6, -44}, FACE_MAP.FRONT, [3]int32{-20, -6, -43}, 2.6})
+
+	// Across zero, from a negative cell into a positive one
+	blocks = newBlockSet([3]int32{1, 0, 0})
+	hit, ok = raycast(mgl32.Vec3{-1.4, 0, 0}, mgl32.Vec3{1, 0, 0}, 5, blocks.hit)
+	assertHit(t, "across zero", hit, ok, raycastHit{[3]int32{1, 0, 0}, FACE_MAP.LEFT, [3]int32{0, 0, 0}, 1.9})
+	blocks.assertWalk(t, [3]int32{-1, 0, 0})
+}
+
+func TestRaycastPillarBorders(t *testing.T) {
+	// Stone up to world height 15 around pillar 0,0, with sky above
+	world := groundWorld(1, -1, 0, 1)
+	useWorld(t, world)
+	solid := func(x, y, z int32) bool { return isTargetable(blockTypeAt(x, y, z)) }
+	pillarsMu.RLock()
+	defer pillarsMu.RUnlock()
+
+	// Down a slope from pillar 0,0 onto the ground of pillar 1,0
+	hit, ok := raycast(mgl32.Vec3{15.2, 20, 3}, mgl32.Vec3{1, -1, 0}, 10, solid)
+	assertHit(t, "onto the next pillar", hit, ok, raycastHit{[3]int32{20, 15, 3}, FACE_MAP.UP, [3]int32{20, 16, 3}, 4.5 * 1.4142135})
+
+	// Along the ground of pillar -1,0 into pillar 0,0, through a tunnel that crosses the border
+	for x := int32(-4); x <= 2; x++ {
+		world[worldToChunkBlock(x, 10, 5).chunkPos.pillarPos].chunk(0).setBlockType(uint8(floorMod(x, 16)), 10, 5, AirID)
+	}
+	hit, ok = raycast(mgl32.Vec3{-4, 10, 5}, mgl32.Vec3{1, 0, 0}, 10, solid)
+	assertHit(t, "through the tunnel", hit, ok, raycastHit{[3]int32{3, 10, 5}, FACE_MAP.LEFT, [3]int32{2, 10, 5}, 6.5})
+
+	// Up out of the pillar's chunks into the sky, and sideways into pillars that aren't loaded
+	if hit, ok := raycast(mgl32.Vec3{0, 16, 0}, mgl32.Vec3{0, 1, 0}, 100, solid); ok {
+		t.Errorf("hit %+v in the sky", hit)
+	}
+	if hit, ok := raycast(mgl32.Vec3{31, 16.2, 0}, mgl32.Vec3{1, 0, 0}, 50, solid); ok {
+		t.Errorf("hit %+v in an unloaded pillar", hit)
+	}
+}
@@ -0,0 +1,526 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+/*
+ * Region files: REGION_SIZE x REGION_SIZE pillars per file.
+ * The file starts with a header table of REGION_SIZE^2 entries (first sector uint32, sector count uint32),
+ * followed by REGION_SECTOR_SIZE byte sectors. A pillar's sectors hold a uint32 payload length followed by
+ * the zlib compressed pillar data. The sectors a pillar outgrows are freed and handed to later writes that fit
+ * into them, so a file only grows when none of its gaps is large enough.
+ */
+
+const (
+	regionHeaderEntries = int(REGION_SIZE * REGION_SIZE)
+	regionHeaderSize    = regionHeaderEntries * 8
+	regionHeaderSectors = (regionHeaderSize + REGION_SECTOR_SIZE - 1) / REGION_SECTOR_SIZE
+
+	pillarFormatVersion uint8 = 1
+)
+
+var errCorruptPillar = errors.New("corrupt pillar data")
+
+var worldStore *RegionStore
+
+type regionPos struct {
+	x int32
+	z int32
+}
+
+type regionEntry struct {
+	sector uint32
+	count  uint32
+}
+
+type regionFile struct {
+	mu      sync.Mutex
+	file    *os.File
+	entries [regionHeaderEntries]regionEntry
+	used    []bool // for every sector of the file, whether the header or a pillar takes it up
+}
+
+type RegionStore struct {
+	dir     string
+	mu      sync.Mutex
+	regions map[regionPos]*regionFile
+}
+
+func regionPosFromPillar(pos PillarPos) regionPos {
+	return regionPos{floorDiv(pos.x, REGION_SIZE), floorDiv(pos.z, REGION_SIZE)}
+}
+
+// index of the pillar inside its region's header table
+func regionEntryIndex(pos PillarPos) int {
+	return int(floorMod(pos.x, REGION_SIZE) + floorMod(pos.z, REGION_SIZE)*REGION_SIZE)
+}
+
+func floorDiv(a, b int32) int32 {
+	q := a / b
+	if (a%b != 0) && ((a < 0) != (b < 0)) {
+		q--
+	}
+	return q
+}
+func floorMod(a, b int32) int32 {
+	return a - floorDiv(a, b)*b
+}
+
+func openRegionStore(dir string) (*RegionStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &RegionStore{
+		dir:     dir,
+		regions: make(map[regionPos]*regionFile),
+	}, nil
+}
+
+func (s *RegionStore) regionPath(rp regionPos) string {
+	return filepath.Join(s.dir, fmt.Sprintf("r.%d.%d.ocr", rp.x, rp.z))
+}
+
+// getRegion returns the open region file, opening (and optionally creating) it on first use.
+// A nil region with a nil error means the file doesn't exist and create was false.
+func (s *RegionStore) getRegion(rp regionPos, create bool) (*regionFile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r, ok := s.regions[rp]; ok {
+		return r, nil
+	}
+
+	flags := os.O_RDWR
+	if create {
+		flags |= os.O_CREATE
+	}
+	file, err := os.OpenFile(s.regionPath(rp), flags, 0o644)
+	if err != nil {
+		if !create && errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	r := &regionFile{file: file}
+	if err := r.readHeader(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	s.regions[rp] = r
+	return r, nil
+}
+
+func (r *regionFile) readHeader() error {
+	info, err := r.file.Stat()
+	if err != nil {
+		return err
+	}
+	r.used = make([]bool, 0, max(int64(regionHeaderSectors), (info.Size()+REGION_SECTOR_SIZE-1)/REGION_SECTOR_SIZE))
+	r.markSectors(0, uint32(regionHeaderSectors), true)
+	if info.Size() == 0 {
+		// new file, reserve the header sectors
+		return r.file.Truncate(int64(regionHeaderSectors * REGION_SECTOR_SIZE))
+	}
+	if info.Size() < int64(regionHeaderSize) {
+		return fmt.Errorf("region %s: truncated header", r.file.Name())
+	}
+
+	header := make([]byte, regionHeaderSize)
+	if _, err := r.file.ReadAt(header, 0); err != nil {
+		return err
+	}
+	for i := range r.entries {
+		r.entries[i].sector = binary.LittleEndian.Uint32(header[i*8:])
+		r.entries[i].count = binary.LittleEndian.Uint32(header[i*8+4:])
+		if r.entries[i].count == 0 {
+			continue
+		}
+		if r.entries[i].sector < uint32(regionHeaderSectors) {
+			return fmt.Errorf("region %s: pillar %d overlaps the header", r.file.Name(), i)
+		}
+		r.markSectors(r.entries[i].sector, r.entries[i].count, true)
+	}
+	return nil
+}
+
+// markSectors marks count sectors from first as used or free, growing the map past the end of the file.
+func (r *regionFile) markSectors(first, count uint32, used bool) {
+	for int(first+count) > len(r.used) {
+		r.used = append(r.used, false)
+	}
+	for i := first; i < first+count; i++ {
+		r.used[i] = used
+	}
+}
+
+// allocate returns the first run of count free sectors, past the end of the file if no gap is large enough.
+func (r *regionFile) allocate(count uint32) uint32 {
+	run := uint32(0)
+	for i := range r.used {
+		if r.used[i] {
+			run = 0
+			continue
+		}
+		if run++; run == count {
+			return uint32(i) + 1 - count
+		}
+	}
+	// Carries on from a free run at the end of the file
+	return uint32(len(r.used)) - run
+}
+
+func (r *regionFile) read(index int) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry := r.entries[index]
+	if entry.count == 0 {
+		return nil, nil
+	}
+
+	data := make([]byte, int(entry.count)*REGION_SECTOR_SIZE)
+	if _, err := r.file.ReadAt(data, int64(entry.sector)*REGION_SECTOR_SIZE); err != nil && err != io.EOF {
+		return nil, err
+	}
+	length := binary.LittleEndian.Uint32(data)
+	if int(length) > len(data)-4 {
+		return nil, errCorruptPillar
+	}
+	return data[4 : 4+length], nil
+}
+
+func (r *regionFile) write(index int, payload []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	count := uint32((len(payload) + 4 + REGION_SECTOR_SIZE - 1) / REGION_SECTOR_SIZE)
+	entry := r.entries[index]
+
+	// The old sectors are freed first, the new payload may well fit where it was
+	old := entry
+	r.markSectors(old.sector, old.count, false)
+	entry.sector, entry.count = r.allocate(count), count
+	r.markSectors(entry.sector, entry.count, true)
+	failed := func(err error) error {
+		r.markSectors(entry.sector, entry.count, false)
+		r.markSectors(old.sector, old.count, true)
+		return err
+	}
+
+	data := make([]byte, int(count)*REGION_SECTOR_SIZE)
+	binary.LittleEndian.PutUint32(data, uint32(len(payload)))
+	copy(data[4:], payload)
+	if _, err := r.file.WriteAt(data, int64(entry.sector)*REGION_SECTOR_SIZE); err != nil {
+		return failed(err)
+	}
+
+	var header [8]byte
+	binary.LittleEndian.PutUint32(header[0:], entry.sector)
+	binary.LittleEndian.PutUint32(header[4:], entry.count)
+	if _, err := r.file.WriteAt(header[:], int64(index*8)); err != nil {
+		return failed(err)
+	}
+	r.entries[index] = entry
+	return nil
+}
+
+// loadPillar returns the stored pillar at pos, or nil if it has never been saved.
+func (s *RegionStore) loadPillar(pos PillarPos) (*Pillar, error) {
+	r, err := s.getRegion(regionPosFromPillar(pos), false)
+	if err != nil || r == nil {
+		return nil, err
+	}
+	compressed, err := r.read(regionEntryIndex(pos))
+	if err != nil || compressed == nil {
+		return nil, err
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+
+	pillar := &Pillar{pos: pos}
+	if err := decodePillar(data, pillar); err != nil {
+		return nil, fmt.Errorf("pillar %d,%d: %w", pos.x, pos.z, err)
+	}
+	return pillar, nil
+}
+
+func loadStoredPillar(pos PillarPos) (*Pillar, error) {
+	if worldStore == nil {
+		return nil, nil
+	}
+	return worldStore.loadPillar(pos)
+}
+
+func (s *RegionStore) savePillar(pillar *Pillar) error {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(encodePillar(pillar)); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	r, err := s.getRegion(regionPosFromPillar(pillar.pos), true)
+	if err != nil {
+		return err
+	}
+	return r.write(regionEntryIndex(pillar.pos), buf.Bytes())
+}
+
+func (s *RegionStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var firstErr error
+	for rp, r := range s.regions {
+		r.mu.Lock()
+		if err := r.file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		r.mu.Unlock()
+		delete(s.regions, rp)
+	}
+	return firstErr
+}
+
+/*
+ * Pillar payload: format version byte, the bottom chunk Y int32 and the chunk count uint16, then for each chunk
+ * its palette (uint16 count + entries), index width byte and packed index words, then a light flag byte with
+ * either the uniform light byte or one light byte per cell.
+ */
+
+func encodePillar(pillar *Pillar) []byte {
+	data := []byte{pillarFormatVersion}
+	data = binary.LittleEndian.AppendUint32(data, uint32(pillar.bottom))
+	data = binary.LittleEndian.AppendUint16(data, uint16(len(pillar.chunks)))
+
+	for _, ch := range pillar.chunks {
+		data = binary.LittleEndian.AppendUint16(data, uint16(len(ch.blocks.palette)))
+		for _, blockType := range ch.blocks.palette {
+			data = binary.LittleEndian.AppendUint16(data, blockType)
+		}
+		data = append(data, ch.blocks.bits)
+		for _, word := range ch.blocks.data {
+			data = binary.LittleEndian.AppendUint64(data, word)
+		}
+
+		if ch.light.data == nil {
+			data = append(data, 0, ch.light.uniform)
+		} else {
+			data = append(data, 1)
+			data = append(data, ch.light.data...)
+		}
+	}
+	return data
+}
+
+func decodePillar(data []byte, pillar *Pillar) error {
+	if len(data) < 1 {
+		return errCorruptPillar
+	}
+	version := data[0]
+	data = data[1:]
+	if version != pillarFormatVersion {
+		return fmt.Errorf("unsupported pillar format version %d", version)
+	}
+
+	if len(data) < 6 {
+		return errCorruptPillar
+	}
+	pillar.bottom = int32(binary.LittleEndian.Uint32(data))
+	pillar.chunks = make([]*Chunk, binary.LittleEndian.Uint16(data[4:]))
+	data = data[6:]
+	for i := range pillar.chunks {
+		var err error
+		if pillar.chunks[i], data, err = decodeChunk(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeChunk(data []byte) (*Chunk, []byte, error) {
+	if len(data) < 2 {
+		return nil, nil, errCorruptPillar
+	}
+	paletteLen := int(binary.LittleEndian.Uint16(data))
+	data = data[2:]
+	if paletteLen == 0 || len(data) < paletteLen*2+1 {
+		return nil, nil, errCorruptPillar
+	}
+
+	ch := newChunk(AirID)
+	ch.blocks.palette = make([]uint16, paletteLen)
+	for p := range ch.blocks.palette {
+		ch.blocks.palette[p] = binary.LittleEndian.Uint16(data[p*2:])
+	}
+	data = data[paletteLen*2:]
+
+	ch.blocks.bits = data[0]
+	data = data[1:]
+	switch ch.blocks.bits {
+	case 0, 1, 2, 4, 8, 16:
+	default:
+		return nil, nil, errCorruptPillar
+	}
+	if ch.blocks.bits < bitsForPalette(paletteLen) {
+		return nil, nil, errCorruptPillar
+	}
+	if ch.blocks.bits > 0 {
+		words := chunkVolume * int(ch.blocks.bits) / 64
+		if len(data) < words*8 {
+			return nil, nil, errCorruptPillar
+		}
+		ch.blocks.data = make([]uint64, words)
+		for w := range ch.blocks.data {
+			ch.blocks.data[w] = binary.LittleEndian.Uint64(data[w*8:])
+		}
+		data = data[words*8:]
+		for i := range chunkVolume {
+			if ch.blocks.rawIndex(i) >= uint64(paletteLen) {
+				return nil, nil, errCorruptPillar
+			}
+		}
+	}
+
+	if len(data) < 2 {
+		return nil, nil, errCorruptPillar
+	}
+	if data[0] == 0 {
+		ch.light.uniform = data[1]
+		return ch, data[2:], nil
+	}
+	data = data[1:]
+	if len(data) < chunkVolume {
+		return nil, nil, errCorruptPillar
+	}
+	ch.light.data = append([]uint8(nil), data[:chunkVolume]...)
+	return ch, data[chunkVolume:], nil
+}
+
+// saveModifiedPillars flushes every loaded pillar that changed since it was last saved.
+func saveModifiedPillars() {
+	if worldStore == nil {
+		return
+	}
+
+	// Encoded from copies, the chunks keep changing while they are written out
+	type modifiedPillar struct {
+		live, copied *Pillar
+		seq          uint64
+	}
+	pillarsMu.RLock()
+	var modified []modifiedPillar
+	for pos, pillar := range pillars {
+		// Pillars still waiting for the lighting worker would be stored dark
+		if pillar.dirty.Load() && pillar.lit.Load() {
+			pillar.dirty.Store(false)
+			modified = append(modified, modifiedPillar{pillar, pillar.snapshot(), beginPillarSave(pos)})
+		}
+	}
+	pillarsMu.RUnlock()
+
+	for _, m := range modified {
+		if err := savePillarCopy(m.copied, m.seq); err != nil {
+			m.live.dirty.Store(true)
+			log.Printf("saving pillar %d,%d: %v", m.copied.pos.x, m.copied.pos.z, err)
+		}
+	}
+}
+
+/*
+ * The copies of a pillar are saved one at a time, and a copy that is older than one already written is dropped
+ * instead of overwriting it. A pillar with copies still waiting to be written isn't loaded again until they are.
+ */
+
+type pillarSaves struct {
+	pending int    // copies taken and not yet written or dropped
+	writing bool   // a copy is being written
+	written uint64 // number of the newest copy written
+}
+
+var (
+	pillarSavesMu    sync.Mutex
+	pillarSavesDone  = sync.NewCond(&pillarSavesMu)
+	pendingSaves     = make(map[PillarPos]*pillarSaves)
+	pillarSaveNumber uint64
+)
+
+// beginPillarSave numbers a copy of the pillar at pos that is about to be saved. The caller must hold pillarsMu
+// while taking the copy, so copies are numbered in the order the pillar changed.
+func beginPillarSave(pos PillarPos) uint64 {
+	pillarSavesMu.Lock()
+	defer pillarSavesMu.Unlock()
+	saves := pendingSaves[pos]
+	if saves == nil {
+		saves = &pillarSaves{}
+		pendingSaves[pos] = saves
+	}
+	saves.pending++
+	pillarSaveNumber++
+	return pillarSaveNumber
+}
+
+// savePillarCopy writes a copy numbered by beginPillarSave, unless a newer copy of the pillar was written first.
+func savePillarCopy(pillar *Pillar, number uint64) error {
+	pillarSavesMu.Lock()
+	saves := pendingSaves[pillar.pos]
+	for saves.writing {
+		pillarSavesDone.Wait()
+	}
+	var err error
+	if number > saves.written {
+		saves.writing = true
+		pillarSavesMu.Unlock()
+		err = worldStore.savePillar(pillar)
+		pillarSavesMu.Lock()
+		saves.writing = false
+		if err == nil {
+			saves.written = number
+		}
+	}
+	saves.pending--
+	if saves.pending == 0 {
+		delete(pendingSaves, pillar.pos)
+	}
+	pillarSavesDone.Broadcast()
+	pillarSavesMu.Unlock()
+	return err
+}
+
+// waitForPillarSaves blocks until every copy of the pillar at pos taken so far is written or dropped.
+func waitForPillarSaves(pos PillarPos) {
+	pillarSavesMu.Lock()
+	for pendingSaves[pos] != nil {
+		pillarSavesDone.Wait()
+	}
+	pillarSavesMu.Unlock()
+}
+
+func autosaveWorld() {
+	ticker := time.NewTicker(AUTOSAVE_INTERVAL)
+	for range ticker.C {
+		saveModifiedPillars()
+	}
+}
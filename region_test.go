@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"math/rand/v2"
+	"os"
+	"testing"
+)
+
+// randomPillar builds a pillar from bottom up with a mix of uniform, sparse and noisy chunks and their light.
+func randomPillar(pos PillarPos, bottom int32, seed uint64) *Pillar {
+	rng := rand.New(rand.NewPCG(seed, 1))
+	pillar := &Pillar{pos: pos, bottom: bottom}
+	for i := range 6 {
+		var ch *Chunk
+		switch i % 3 {
+		case 0:
+			ch = newChunk(StoneID)
+			ch.light.uniform = packLight(0, 3)
+		case 1:
+			ch = newChunk(AirID)
+			ch.light.uniform = packLight(maxLightLevel, 0)
+			ch.setBlock(1, 2, 3, Block{LogID, 14, 2})
+		case 2:
+			ch = newChunk(DirtID)
+			for range 3000 {
+				x, y, z := uint8(rng.IntN(16)), uint8(rng.IntN(16)), uint8(rng.IntN(16))
+				ch.setBlock(x, y, z, Block{uint16(rng.IntN(40)), uint8(rng.IntN(16)), uint8(rng.IntN(16))})
+			}
+		}
+		pillar.chunks = append(pillar.chunks, ch)
+	}
+	return pillar
+}
+
+func assertSamePillar(t *testing.T, got, want *Pillar) {
+	t.Helper()
+	if got == nil {
+		t.Fatalf("pillar %v wasn't stored", want.pos)
+	}
+	if got.bottom != want.bottom || len(got.chunks) != len(want.chunks) {
+		t.Fatalf("pillar %v: bottom %d with %d chunks, want %d with %d", want.pos, got.bottom, len(got.chunks), want.bottom, len(want.chunks))
+	}
+	for i := range want.chunks {
+		for x := range CHUNK_SIZE {
+			for y := range CHUNK_SIZE {
+				for z := range CHUNK_SIZE {
+					if g, w := got.chunks[i].getBlock(x, y, z), want.chunks[i].getBlock(x, y, z); g != w {
+						t.Fatalf("pillar %v chunk %d block %d,%d,%d: got %+v, want %+v", want.pos, i, x, y, z, g, w)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestRegionRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	store, err := openRegionStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both sides of a region border, and far out in negative coordinates
+	want := map[PillarPos]*Pillar{}
+	for i, pos := range []PillarPos{{0, 0}, {-1, 0}, {REGION_SIZE - 1, 5}, {REGION_SIZE, 5}, {-1000, -77}} {
+		want[pos] = randomPillar(pos, int32(i)-3, uint64(i))
+		if err := store.savePillar(want[pos]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Saved again after it changed
+	edited := want[PillarPos{0, 0}]
+	edited.chunks[0].setBlock(15, 15, 15, Block{SandID, 7, 8})
+	edited.chunks = append(edited.chunks, newChunk(StoneID))
+	if err := store.savePillar(edited); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = openRegionStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for pos, pillar := range want {
+		got, err := store.loadPillar(pos)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertSamePillar(t, got, pillar)
+	}
+	for _, pos := range []PillarPos{{1, 0}, {REGION_SIZE * 5, 0}} {
+		if got, err := store.loadPillar(pos); got != nil || err != nil {
+			t.Errorf("pillar %v was never saved, loaded %v, %v", pos, got, err)
+		}
+	}
+}
+
+func TestRegionReusesFreedSectors(t *testing.T) {
+	dir := t.TempDir()
+	store, err := openRegionStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// Noise doesn't compress, so sizes follow the number of noisy chunks
+	noisy := func(pos PillarPos, chunks int) *Pillar {
+		rng := rand.New(rand.NewPCG(uint64(chunks), 2))
+		pillar := &Pillar{pos: pos}
+		for range chunks {
+			ch := newChunk(AirID)
+			for i := range chunkVolume {
+				ch.blocks.set(i, uint16(rng.IntN(256)))
+				ch.light.set(i, uint8(rng.IntN(256)))
+			}
+			pillar.chunks = append(pillar.chunks, ch)
+		}
+		return pillar
+	}
+	fileSize := func() int64 {
+		info, err := os.Stat(store.regionPath(regionPos{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.Size()
+	}
+
+	a, b := PillarPos{0, 0}, PillarPos{1, 0}
+	for _, p := range []*Pillar{noisy(a, 4), noisy(b, 1)} {
+		if err := store.savePillar(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	full := fileSize()
+
+	// a outgrows its sectors and moves to the end, b shrinks and then fits into what a left behind
+	if err := store.savePillar(noisy(a, 5)); err != nil {
+		t.Fatal(err)
+	}
+	grown := fileSize()
+	for range 3 {
+		if err := store.savePillar(noisy(b, 3)); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.savePillar(noisy(b, 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.savePillar(noisy(b, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if size := fileSize(); size != grown || grown >= 2*full {
+		t.Errorf("file grew from %d to %d and then %d bytes, the freed sectors should be reused", full, grown, size)
+	}
+
+	r, err := store.getRegion(regionPos{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ea, eb := r.entries[regionEntryIndex(a)], r.entries[regionEntryIndex(b)]
+	if ea.sector < eb.sector+eb.count && eb.sector < ea.sector+ea.count {
+		t.Fatalf("pillars share sectors: %+v and %+v", ea, eb)
+	}
+	got, err := store.loadPillar(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSamePillar(t, got, noisy(b, 3))
+}
+
+func TestRegionSavesInOrder(t *testing.T) {
+	store, err := openRegionStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	previous := worldStore
+	worldStore = store
+	defer func() { worldStore = previous }()
+
+	// A copy taken before another one that was written first is dropped, not written over it
+	pos := PillarPos{3, -2}
+	older, newer := randomPillar(pos, -2, 1), randomPillar(pos, -2, 2)
+	olderNumber, newerNumber := beginPillarSave(pos), beginPillarSave(pos)
+
+	// Until both are done, the pillar isn't read back
+	loaded := make(chan struct{})
+	go func() {
+		waitForPillarSaves(pos)
+		close(loaded)
+	}()
+	if err := savePillarCopy(newer, newerNumber); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-loaded:
+		t.Fatal("the pillar was read back with a save pending")
+	default:
+	}
+	if err := savePillarCopy(older, olderNumber); err != nil {
+		t.Fatal(err)
+	}
+	<-loaded
+
+	got, err := store.loadPillar(pos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSamePillar(t, got, newer)
+}
+
+func TestRegionCorruptPayloads(t *testing.T) {
+	good := encodePillar(randomPillar(PillarPos{}, 0, 3))
+	// The first chunk is uniform stone: palette of one, no index data, uniform light
+	chunkStart := 7
+
+	corrupt := map[string][]byte{
+		"empty":           {},
+		"unknown version": append([]byte{9}, good[1:]...),
+		"truncated":       good[:len(good)/2],
+		"no chunks count": good[:3],
+		"empty palette":   append(append([]byte{}, good[:chunkStart]...), 0, 0),
+	}
+	badBits := append([]byte{}, good...)
+	badBits[chunkStart+4] = 3
+	corrupt["index width"] = badBits
+
+	// Two palette entries that need at least one bit
+	narrow := append(append([]byte{}, good[:chunkStart]...), 2, 0, 1, 0, 2, 0, 0)
+	corrupt["index too narrow"] = narrow
+
+	outOfPalette := append([]byte{}, good[:chunkStart]...)
+	outOfPalette = append(outOfPalette, 2, 0, 1, 0, 2, 0, 2)
+	for range chunkVolume * 2 / 64 {
+		outOfPalette = binary.LittleEndian.AppendUint64(outOfPalette, 0xFFFFFFFFFFFFFFFF)
+	}
+	outOfPalette = append(outOfPalette, 0, 0)
+	corrupt["index out of palette"] = outOfPalette
+
+	for name, data := range corrupt {
+		var pillar Pillar
+		if err := decodePillar(data, &pillar); err == nil {
+			t.Errorf("%s: decoded", name)
+		}
+	}
+
+	// And on disk: a stored length past the pillar's sectors, and a payload that isn't zlib
+	dir := t.TempDir()
+	store, err := openRegionStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	r, err := store.getRegion(regionPos{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.write(0, []byte("not zlib")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.loadPillar(PillarPos{0, 0}); err == nil {
+		t.Error("garbage payload loaded")
+	}
+
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	zw.Write(good)
+	zw.Close()
+	if err := r.write(1, compressed.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], REGION_SECTOR_SIZE*100)
+	if _, err := r.file.WriteAt(length[:], int64(r.entries[1].sector)*REGION_SECTOR_SIZE); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.loadPillar(PillarPos{1, 0}); !errors.Is(err, errCorruptPillar) {
+		t.Errorf("length past the sectors: got %v, want %v", err, errCorruptPillar)
+	}
+}
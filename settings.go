@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+/*
+ * Settings. World settings shape the terrain and are saved with the world as world.json, so a world always comes
+ * back with the seed it was made with. Engine settings are how the game runs on this machine and live in
+ * settings.json. Command-line flags override both for one run; only a newly created world keeps them, written
+ * into its world.json. Missing files are created with the defaults so there is something to edit.
+ */
+
+const (
+	WORLD_SETTINGS_FILE  = "world.json"
+	ENGINE_SETTINGS_FILE = "settings.json"
+)
+
+type WorldSettings struct {
+	Seed             int64   `json:"seed"`
+	TerrainScale     float32 `json:"terrainScale"`     // blocks per unit of terrain noise, larger means wider hills
+	TerrainAmplitude float32 `json:"terrainAmplitude"` // scales the height of the hills, 1 is the terrain as designed
+}
+
+type EngineSettings struct {
+	RenderDistance   int32   `json:"renderDistance"` // pillars around the camera drawn at full detail
+	LODRingWidth     int32   `json:"lodRingWidth"`   // pillars each coarser level of detail reaches past the last, see lod.go
+	Vsync            bool    `json:"vsync"`
+	AntiAliasing     bool    `json:"antiAliasing"`
+	AmbientOcclusion bool    `json:"ambientOcclusion"`
+	GreedyMeshing    bool    `json:"greedyMeshing"`
+	CaveCulling      bool    `json:"caveCulling"`
+	WalkingSpeed     float32 `json:"walkingSpeed"`
+	RunningSpeed     float32 `json:"runningSpeed"` // walking speed multiplier while sprinting
+	FlyingSpeed      float32 `json:"flyingSpeed"`
+	JumpHeight       float32 `json:"jumpHeight"`
+}
+
+// The settings of the running game, replaced by applyWorldSettings and applyEngineSettings
+var (
+	worldSettings  = defaultWorldSettings()
+	engineSettings = defaultEngineSettings()
+)
+
+func defaultWorldSettings() WorldSettings {
+	return WorldSettings{
+		Seed:             1,
+		TerrainScale:     30,
+		TerrainAmplitude: 1,
+	}
+}
+
+func defaultEngineSettings() EngineSettings {
+	return EngineSettings{
+		RenderDistance:   4,
+		LODRingWidth:     1,
+		AmbientOcclusion: true,
+		GreedyMeshing:    true,
+		CaveCulling:      true,
+		WalkingSpeed:     2.3,
+		RunningSpeed:     1.3,
+		FlyingSpeed:      10,
+		JumpHeight:       0.25,
+	}
+}
+
+func (s *WorldSettings) validate() error {
+	var errs []error
+	if s.TerrainScale <= 0 {
+		errs = append(errs, fmt.Errorf("terrainScale must be above 0, got %v", s.TerrainScale))
+	}
+	if s.TerrainAmplitude < 0 {
+		errs = append(errs, fmt.Errorf("terrainAmplitude can't be negative, got %v", s.TerrainAmplitude))
+	}
+	return errors.Join(errs...)
+}
+
+func (s *EngineSettings) validate() error {
+	var errs []error
+	if s.RenderDistance < 1 || s.RenderDistance > 32 {
+		errs = append(errs, fmt.Errorf("renderDistance must be between 1 and 32, got %d", s.RenderDistance))
+	}
+	if s.LODRingWidth < 0 || s.LODRingWidth > 8 {
+		errs = append(errs, fmt.Errorf("lodRingWidth must be between 0 and 8, got %d", s.LODRingWidth))
+	}
+	speeds := []struct {
+		name  string
+		value float32
+	}{
+		{"walkingSpeed", s.WalkingSpeed}, {"runningSpeed", s.RunningSpeed},
+		{"flyingSpeed", s.FlyingSpeed}, {"jumpHeight", s.JumpHeight},
+	}
+	for _, speed := range speeds {
+		if speed.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be above 0, got %v", speed.name, speed.value))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// launchOptions is everything read from the settings files and the command line.
+type launchOptions struct {
+	world      WorldSettings
+	engine     EngineSettings
+	worldDir   string
+	worldStats int // chunks to generate for the statistics, 0 to play
+}
+
+// loadSettings reads the settings files and applies the command-line flags in args over them. Errors say which
+// file or flag is wrong; flag.ErrHelp is returned after the usage was printed for -h.
+func loadSettings(args []string, usage io.Writer) (*launchOptions, error) {
+	opts := &launchOptions{world: defaultWorldSettings(), engine: defaultEngineSettings()}
+	var engineFile string
+
+	flags := flag.NewFlagSet("OpenCraft", flag.ContinueOnError)
+	flags.SetOutput(usage)
+	flags.StringVar(&opts.worldDir, "world", WORLD_SAVE_DIR, "directory the world is saved in")
+	flags.StringVar(&engineFile, "settings", ENGINE_SETTINGS_FILE, "engine settings file")
+	flags.IntVar(&opts.worldStats, "worldstats", 0, "generate this many chunks without opening a window, print block statistics by height and exit")
+
+	flags.Int64Var(&opts.world.Seed, "seed", opts.world.Seed, "world seed")
+	flags.Var(float32Value{&opts.world.TerrainScale}, "terrain-scale", "blocks per unit of terrain noise")
+	flags.Var(float32Value{&opts.world.TerrainAmplitude}, "terrain-amplitude", "scale of the height of the hills")
+
+	flags.Var(int32Value{&opts.engine.RenderDistance}, "render-distance", "pillars drawn at full detail around the camera")
+	flags.Var(int32Value{&opts.engine.LODRingWidth}, "lod-ring-width", "pillars each coarser level of detail reaches further, 0 for none")
+	flags.BoolVar(&opts.engine.Vsync, "vsync", opts.engine.Vsync, "wait for vertical sync")
+	flags.BoolVar(&opts.engine.AntiAliasing, "aa", opts.engine.AntiAliasing, "multisample anti-aliasing")
+	flags.BoolVar(&opts.engine.AmbientOcclusion, "ao", opts.engine.AmbientOcclusion, "ambient occlusion")
+	flags.BoolVar(&opts.engine.GreedyMeshing, "greedy", opts.engine.GreedyMeshing, "greedy meshing")
+	flags.BoolVar(&opts.engine.CaveCulling, "cave-culling", opts.engine.CaveCulling, "cave culling")
+	flags.Var(float32Value{&opts.engine.WalkingSpeed}, "walking-speed", "walking speed")
+	flags.Var(float32Value{&opts.engine.RunningSpeed}, "running-speed", "walking speed multiplier while sprinting")
+	flags.Var(float32Value{&opts.engine.FlyingSpeed}, "flying-speed", "flying speed")
+	flags.Var(float32Value{&opts.engine.JumpHeight}, "jump-height", "jump strength")
+
+	// The first parse finds the files, which overwrite every setting, then the second puts the flags back on top
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	worldFile := filepath.Join(opts.worldDir, WORLD_SETTINGS_FILE)
+	worldCreated, err := readSettingsFile(worldFile, &opts.world)
+	if err != nil {
+		return nil, err
+	}
+	engineCreated, err := readSettingsFile(engineFile, &opts.engine)
+	if err != nil {
+		return nil, err
+	}
+	savedWorld := opts.world
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if err := opts.world.validate(); err != nil {
+		return nil, fmt.Errorf("world settings (%s):\n%w", worldFile, err)
+	}
+	if err := opts.engine.validate(); err != nil {
+		return nil, fmt.Errorf("engine settings (%s):\n%w", engineFile, err)
+	}
+
+	if engineCreated {
+		if err := writeSettingsFile(engineFile, defaultEngineSettings()); err != nil {
+			log.Printf("writing %s: %v", engineFile, err)
+		}
+	}
+	switch {
+	case worldCreated && opts.worldStats == 0:
+		if err := writeSettingsFile(worldFile, opts.world); err != nil {
+			return nil, err
+		}
+	case !worldCreated && opts.world != savedWorld:
+		log.Printf("%s was created with different world settings, the flags only apply to this run", worldFile)
+	}
+	return opts, nil
+}
+
+// readSettingsFile decodes a settings file over s, reporting whether it didn't exist yet.
+func readSettingsFile(path string, s any) (missing bool, err error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(s); err != nil {
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &syntaxErr):
+			return false, fmt.Errorf("%s:%d: %w", path, lineAt(data, syntaxErr.Offset), err)
+		case errors.As(err, &typeErr):
+			return false, fmt.Errorf("%s:%d: %s: expected %s, got %s", path, lineAt(data, typeErr.Offset), typeErr.Field, typeErr.Type, typeErr.Value)
+		}
+		return false, fmt.Errorf("%s: %w", path, err)
+	}
+	return false, nil
+}
+
+func writeSettingsFile(path string, s any) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// lineAt returns the line number of a byte offset.
+func lineAt(data []byte, offset int64) int {
+	return bytes.Count(data[:min(offset, int64(len(data)))], []byte("\n")) + 1
+}
+
+// float32Value and int32Value let flags set the float32 and int32 fields of the settings.
+type float32Value struct{ target *float32 }
+
+func (v float32Value) String() string {
+	if v.target == nil {
+		return "0"
+	}
+	return strconv.FormatFloat(float64(*v.target), 'g', -1, 32)
+}
+
+func (v float32Value) Set(value string) error {
+	f, err := strconv.ParseFloat(value, 32)
+	if err != nil {
+		return err
+	}
+	*v.target = float32(f)
+	return nil
+}
+
+type int32Value struct{ target *int32 }
+
+func (v int32Value) String() string {
+	if v.target == nil {
+		return "0"
+	}
+	return strconv.FormatInt(int64(*v.target), 10)
+}
+
+func (v int32Value) Set(value string) error {
+	i, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		return err
+	}
+	*v.target = int32(i)
+	return nil
+}
+
+// applyWorldSettings reseeds everything that generates the world.
+func applyWorldSettings(s WorldSettings) {
+	worldSettings = s
+	random = rand.New(rand.NewSource(s.Seed))
+	worldBiomes = NewBiomeSource(s.Seed)
+	worldGenerator = newBiomeWorldGenerator(s.Seed, worldBiomes)
+}
+
+func applyEngineSettings(s EngineSettings) {
+	engineSettings = s
+	AmbientOcclusion = s.AmbientOcclusion
+	GreedyMeshing = s.GreedyMeshing
+	CaveCulling = s.CaveCulling
+	setRenderDistance(s.RenderDistance, s.LODRingWidth)
+}
@@ -3,25 +3,22 @@
 in float LightLevel;
 in vec2 TexCoord;
 in vec3 TextureTint;
-in vec2 OverlayCoord;
+flat in float TextureLayer;
 out vec4 color;
 
-uniform sampler2D texture0;
+uniform sampler2DArray texture0;
 float light;
 float minBrightness = 1.0;
 
 void main() {
-    vec4 baseTexture = texture(texture0, TexCoord);
+    // TexCoord is in blocks, the array repeats it so merged quads tile
+    vec4 baseTexture = texture(texture0, vec3(TexCoord, TextureLayer));
+    if (baseTexture.a < 0.1) {
+        discard; // cut out the empty parts of plant textures
+    }
     light = (LightLevel + minBrightness) / 15.0;
 
-    if (OverlayCoord.x != 0) {
-        color = baseTexture;
-        vec4 overlayTexture = texture(texture0, OverlayCoord);
-        overlayTexture.rgb *= TextureTint;
-        color = mix(color, overlayTexture, overlayTexture.a);
-    } else {
-        color = baseTexture * vec4(TextureTint[0], TextureTint[1], TextureTint[2], 1.0);
-    }
+    color = baseTexture * vec4(TextureTint[0], TextureTint[1], TextureTint[2], 1.0);
 
     color *= vec4(light, light, light, 1.0);
 }
@@ -1,24 +1,37 @@
 #version 410 core
 
-layout(location = 0) in vec3 position;
-layout(location = 1) in vec2 texCoord;
-layout(location = 2) in float lightLevel;
-layout(location = 3) in vec3 textureTint;
-layout(location = 4) in vec2 overlayCoord;
+// Packed chunk vertex, see chunkVertex.go
+layout(location = 0) in uint vertexPosition; // x, y, z 5 bits each | face 3 bits | light * 16 8 bits
+layout(location = 1) in uint vertexStyle;    // texture layer 8 bits | tint r, g, b 8 bits each
 
 out vec2 TexCoord;
-out vec2 OverlayCoord;
 out float LightLevel;
 out vec3 TextureTint;
+flat out float TextureLayer;
 
 uniform mat4 projection;
 uniform mat4 view;
 uniform mat4 model;
 
 void main() {
-    gl_Position = projection * view * model * vec4(position, 1.0f);
-    TexCoord = texCoord;
-    LightLevel = lightLevel;
-    TextureTint = textureTint;
-    OverlayCoord = overlayCoord;
+    vec3 corner = vec3(vertexPosition & 31u, (vertexPosition >> 5) & 31u, (vertexPosition >> 10) & 31u);
+    uint face = (vertexPosition >> 15) & 7u;
+
+    // Block centers sit at integer positions
+    gl_Position = projection * view * model * vec4(corner - 0.5, 1.0f);
+
+    // Texture coordinates in blocks, the texture array repeats them across merged quads
+    if (face == 0u) {        // front
+        TexCoord = vec2(corner.x, 1.0 - corner.y);
+    } else if (face == 1u) { // back
+        TexCoord = vec2(1.0 - corner.x, 1.0 - corner.y);
+    } else if (face <= 3u) { // left, right
+        TexCoord = vec2(corner.z, 1.0 - corner.y);
+    } else {                 // up, down
+        TexCoord = vec2(corner.x, 1.0 - corner.z);
+    }
+
+    LightLevel = float(vertexPosition >> 18) / 16.0;
+    TextureLayer = float(vertexStyle & 255u);
+    TextureTint = vec3((vertexStyle >> 8) & 255u, (vertexStyle >> 16) & 255u, (vertexStyle >> 24) & 255u) / 255.0;
 }
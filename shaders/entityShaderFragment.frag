@@ -0,0 +1,14 @@
+#version 410 core
+in vec3 WorldPosition;
+out vec4 FragColor;
+
+uniform vec3 color;
+
+// Flat shading from the face normal, the tops of boxes are brightest like the tops of blocks.
+const vec3 lightDirection = normalize(vec3(0.3, 1.0, 0.5));
+
+void main() {
+    vec3 normal = normalize(cross(dFdx(WorldPosition), dFdy(WorldPosition)));
+    float shade = 0.55 + 0.45 * abs(dot(normal, lightDirection));
+    FragColor = vec4(color * shade, 1.0);
+}
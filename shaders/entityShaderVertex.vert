@@ -0,0 +1,17 @@
+#version 410 core
+
+// Entity box models: a cube from -1 to 1 at layout(location=0), placed and sized by the model matrix.
+
+layout(location = 0) in vec3 position;
+
+out vec3 WorldPosition;
+
+uniform mat4 projection;
+uniform mat4 view;
+uniform mat4 model;
+
+void main() {
+    vec4 world = model * vec4(position, 1.0);
+    WorldPosition = world.xyz;
+    gl_Position = projection * view * world;
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+/*
+ * The simulation: the player and every other entity, stepped TICK_UPDATE_RATE at a time. Everything the player
+ * does comes in as an InputCommand, one per step, breaking and placing blocks included, so the only other things a
+ * step reads are the blocks around the entities and the blocks changed outside the simulation: the same commands
+ * from the same state through the same blocks always end in the same state, which lets a recorded run be replayed.
+ * A step doesn't change the world itself, it hands the blocks the player edited back to its caller. Rendering never
+ * changes the simulation, it only interpolates between its last two steps.
+ */
+
+// InputCommand is what the player asked for during one tick.
+type InputCommand struct {
+	Forward      float32 // -1 to 1, along the facing direction
+	Right        float32 // -1 to 1, to the right of the facing direction
+	Yaw          float32 // facing direction in degrees, -90 looks along -Z
+	Pitch        float32 // degrees above the horizon
+	Jump         bool    // jump, swim or climb up, or fly up
+	Sprint       bool    // sprint, or fly down together with Jump
+	Crouch       bool
+	ToggleFlying bool
+	Throw        bool   // throw a projectile where the player looks
+	Break        bool   // break the block the player looks at
+	Place        uint16 // block type to place against the block the player looks at, AirID for none
+}
+
+// BlockReader is the world as a simulation sees it.
+type BlockReader interface {
+	// BlockAt returns the block at a world position, loaded is false where the world isn't loaded yet
+	BlockAt(x, y, z int32) (blockType uint16, loaded bool)
+}
+
+// loadedWorld reads the loaded pillars. The caller of Step must hold pillarsMu.
+type loadedWorld struct{}
+
+func (loadedWorld) BlockAt(x, y, z int32) (uint16, bool) {
+	// Below a pillar's bottom is ground that isn't generated yet
+	pos := worldToChunkBlock(x, y, z)
+	if pillar := pillars[pos.chunkPos.pillarPos]; pillar == nil || pos.chunkPos.y < pillar.bottom {
+		return AirID, false
+	}
+	return blockTypeAt(x, y, z), true
+}
+
+// The player is an entity steered by input commands, with physics of its own, see playerPhysics.go
+type playerState struct {
+	*Entity
+	cmd          InputCommand // what the player asked for this tick
+	settings     EngineSettings
+	sprinting    bool
+	flying       bool
+	crouching    bool
+	jumpCooldown int // ticks until the player can jump again
+	surroundings playerSurroundings
+}
+
+type Simulation struct {
+	entities          *Entities
+	player            *playerState
+	tick              uint64 // steps taken
+	commands          []InputCommand
+	last              InputCommand // held keys carry over to steps nobody queued a command for
+	changed           [][3]int32   // blocks changed since the last step, mob paths near them are found again
+	changedEverything bool
+}
+
+// blockEdit is a block the player broke or placed during a step, for the caller of Step to change in the world.
+type blockEdit struct {
+	block     [3]int32
+	blockType uint16
+}
+
+// The player's simulation, stepped by the main loop
+var simulation = NewSimulation(mgl32.Vec3{0.0, 10, 15}, defaultEngineSettings())
+
+// NewSimulation starts a world with nothing but a flying player with its eyes at position, moving at the speeds
+// of settings.
+func NewSimulation(position mgl32.Vec3, settings EngineSettings) *Simulation {
+	s := &Simulation{entities: newEntities()}
+	s.player = &playerState{settings: settings, flying: true}
+	s.player.Entity = s.entities.spawn(&Entity{
+		position:   position,
+		width:      PLAYER_WIDTH,
+		height:     PLAYER_EYE_HEIGHT + 0.25,
+		eyeHeight:  PLAYER_EYE_HEIGHT,
+		solid:      true,
+		controller: s.player,
+	})
+	return s
+}
+
+// Queue adds a command for a later step, steps use them in the order they were queued.
+func (s *Simulation) Queue(cmd InputCommand) {
+	s.commands = append(s.commands, cmd)
+}
+
+// BlocksChanged tells the next step about blocks changed outside the simulation, everything when there were too
+// many to list. Like the commands they are an input of the steps, a replay has to pass the same ones.
+func (s *Simulation) BlocksChanged(blocks [][3]int32, everything bool) {
+	s.changed = append(s.changed, blocks...)
+	s.changedEverything = s.changedEverything || everything
+}
+
+// Step moves the simulation one tick forward with the next queued command. It returns the blocks the player broke
+// or placed, which the caller changes in the world before the next step.
+func (s *Simulation) Step(world BlockReader) []blockEdit {
+	cmd := s.last
+	cmd.ToggleFlying, cmd.Throw, cmd.Break, cmd.Place = false, false, false, AirID
+	if len(s.commands) > 0 {
+		cmd = s.commands[0]
+		s.commands = s.commands[1:]
+	}
+	s.last = cmd
+
+	for _, e := range s.entities.all {
+		if m, ok := e.controller.(*mob); ok {
+			m.blocksChanged(s.changed, s.changedEverything)
+		}
+	}
+	s.changed, s.changedEverything = nil, false
+
+	p := s.player
+	p.cmd = cmd
+	if cmd.ToggleFlying {
+		p.flying = !p.flying
+	}
+	if cmd.Throw {
+		look := lookDirection(cmd.Yaw, cmd.Pitch)
+		s.entities.spawn(newProjectile(p.position.Add(look.Mul(0.5)), p.velocity.Add(look.Mul(PROJECTILE_SPEED)), p.id))
+	}
+	edits := s.editBlocks(cmd, world)
+	if s.tick%MOB_SPAWN_INTERVAL == 0 {
+		s.spawnMobs(world)
+	}
+	s.entities.step(world)
+	s.tick++
+	return edits
+}
+
+// editBlocks breaks the block the player looks at and drops it as an item, or places a block against it unless
+// the block would end up inside the player or another solid entity. Breaking wins when a command asks for both.
+func (s *Simulation) editBlocks(cmd InputCommand, world BlockReader) []blockEdit {
+	if !cmd.Break && cmd.Place == AirID {
+		return nil
+	}
+	hit, ok := s.player.targetBlock(cmd, world)
+	if !ok {
+		return nil
+	}
+	var edit blockEdit
+	if cmd.Break {
+		blockType, _ := world.BlockAt(hit.block[0], hit.block[1], hit.block[2])
+		edit = blockEdit{hit.block, AirID}
+		s.entities.spawn(newDroppedItem(mgl32.Vec3{float32(hit.block[0]), float32(hit.block[1]), float32(hit.block[2])}, blockType))
+	} else {
+		existing, loaded := world.BlockAt(hit.adjacent[0], hit.adjacent[1], hit.adjacent[2])
+		if !loaded || BlockProperties[existing].IsSolid || s.entities.collidesWithPlacedBlock(hit.adjacent) {
+			return nil
+		}
+		edit = blockEdit{hit.adjacent, cmd.Place}
+	}
+	s.changed = append(s.changed, edit.block)
+	return []blockEdit{edit}
+}
+
+// Interpolated returns the player position alpha of the way from the step before the last to the last one.
+func (s *Simulation) Interpolated(alpha float32) mgl32.Vec3 {
+	return s.player.interpolated(alpha)
+}
+
+// update runs the player through one tick of the command it was given.
+func (p *playerState) update(e *Entity, world BlockReader, entities *Entities) {
+	p.steer(p.cmd, p.settings)
+	p.damp(0.35)
+	if !p.flying {
+		p.physics(p.cmd, world)
+	}
+	if p.jumpCooldown > 0 {
+		p.jumpCooldown--
+	}
+}
+
+// lookDirection returns the unit vector a yaw and pitch in degrees look along.
+func lookDirection(yaw, pitch float32) mgl32.Vec3 {
+	y, p := float64(mgl32.DegToRad(yaw)), float64(mgl32.DegToRad(pitch))
+	return mgl32.Vec3{float32(math.Cos(y) * math.Cos(p)), float32(math.Sin(p)), float32(math.Sin(y) * math.Cos(p))}
+}
+
+// steer turns the movement keys of a command into velocity.
+func (p *playerState) steer(cmd InputCommand, settings EngineSettings) {
+	const dt = TICK_UPDATE_RATE
+	speed := settings.WalkingSpeed
+	if p.flying {
+		speed = settings.FlyingSpeed
+		if cmd.Jump {
+			if cmd.Sprint {
+				p.velocity[1] -= speed * dt
+			} else {
+				p.velocity[1] += speed * dt
+			}
+		}
+	}
+
+	p.sprinting = cmd.Sprint
+	if p.sprinting {
+		speed *= settings.RunningSpeed
+	}
+	if p.crouching {
+		speed *= crouchSpeedMultiplier
+	}
+	if p.swimming() {
+		speed *= swimSpeedMultiplier
+	}
+
+	front := lookDirection(cmd.Yaw, 0)
+	right := mgl32.Vec3{-front[2], 0, front[0]}
+	direction := front.Mul(mgl32.Clamp(cmd.Forward, -1, 1)).Add(right.Mul(mgl32.Clamp(cmd.Right, -1, 1)))
+	if direction.Len() > 0 {
+		direction = direction.Normalize()
+	}
+	p.velocity = p.velocity.Add(direction.Mul(speed * dt))
+
+	if !cmd.Jump || p.flying {
+		return
+	}
+	if p.swimming() {
+		p.velocity[1] += swimUpSpeed * dt
+		return
+	}
+	if p.onGround && p.jumpCooldown == 0 {
+		p.jumpCooldown = jumpCooldownTicks
+		p.velocity[1] += settings.JumpHeight
+	}
+}
+
+// damp slows the player down, by the ground's friction when walking and by the liquid's when swimming.
+func (p *playerState) damp(damping float32) {
+	if p.swimming() {
+		// Liquids slow every direction alike
+		p.velocity = p.velocity.Mul(1 - BlockProperties[p.surroundings.liquid].Friction)
+		return
+	}
+	friction := float32(1)
+	if ground := BlockProperties[p.surroundings.ground]; p.onGround && !p.flying && ground.IsSolid {
+		friction = ground.Friction
+	}
+	dampenVert := (1.0 - damping)
+	dampenHoriz := (1.0 - damping*friction)
+	airMultiplier := float32(0.93) //In Air (while jumping, etc) horizontal resistance 7% decrease
+	sprintMultiplier := float32(2) // sprint jump = 14% decrease
+	if !p.onGround {
+		dampenHoriz = (1.0 - (damping * airMultiplier))
+		if p.sprinting {
+			dampenHoriz = 1.0 - (damping * (1 - ((1 - airMultiplier) * sprintMultiplier)))
+		}
+	}
+	p.velocity[0] *= dampenHoriz
+	p.velocity[2] *= dampenHoriz
+	if p.flying {
+		p.velocity[1] *= dampenVert
+	}
+}
+
+// collidable reports whether a block stops an entity, the world that isn't loaded yet does.
+func collidable(world BlockReader) func(x, y, z int32) bool {
+	return func(x, y, z int32) bool {
+		blockType, loaded := world.BlockAt(x, y, z)
+		return !loaded || BlockProperties[blockType].IsSolid
+	}
+}
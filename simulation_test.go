@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// editableWorld is a world with the blocks the steps edited made on top, as the main loop makes them in the
+// loaded world.
+type editableWorld struct {
+	BlockReader
+	edits map[[3]int32]uint16
+}
+
+func newEditableWorld(world BlockReader) *editableWorld {
+	return &editableWorld{BlockReader: world, edits: make(map[[3]int32]uint16)}
+}
+
+func (w *editableWorld) BlockAt(x, y, z int32) (uint16, bool) {
+	if blockType, ok := w.edits[[3]int32{x, y, z}]; ok {
+		return blockType, true
+	}
+	return w.BlockReader.BlockAt(x, y, z)
+}
+
+// step steps sim through the world and makes the edits it hands back.
+func (w *editableWorld) step(sim *Simulation) []blockEdit {
+	edits := sim.Step(w)
+	for _, e := range edits {
+		w.edits[e.block] = e.blockType
+	}
+	return edits
+}
+
+// meadow is grass mobs spawn on, with a wall and a pond, loaded 40 blocks around the origin.
+type meadow struct{}
+
+func (meadow) BlockAt(x, y, z int32) (uint16, bool) {
+	if abs32(x) > 40 || abs32(z) > 40 {
+		return AirID, false
+	}
+	switch {
+	case y <= 0:
+		return GrassID, true
+	case x == 10 && y <= 3 && z > -5:
+		return StoneID, true
+	case y == 1 && x >= -6 && x <= -2 && z >= 4 && z <= 8:
+		return WaterID, true
+	}
+	return AirID, true
+}
+
+// scriptedCommands walks, jumps, crouches, flies, throws, breaks and places in a fixed pattern.
+func scriptedCommands(n int) []InputCommand {
+	var cmds []InputCommand
+	for i := range n {
+		cmd := InputCommand{Forward: 1, Right: float32(i%3 - 1), Yaw: float32(i%90) * 4, Pitch: -float32(i%50) - 20}
+		cmd.Jump = i%17 == 0
+		cmd.Sprint = i%50 < 20
+		cmd.Crouch = i%80 > 70
+		cmd.ToggleFlying = i == 0 || i == 300 || i == 330
+		cmd.Throw = i%40 == 5
+		cmd.Break = i%23 == 7
+		if i%19 == 3 {
+			cmd.Place = DirtID
+		}
+		cmds = append(cmds, cmd)
+	}
+	return cmds
+}
+
+// simulationState writes down everything about the entities of a simulation a replay has to repeat.
+func simulationState(s *Simulation) string {
+	var state strings.Builder
+	fmt.Fprintf(&state, "tick %d player %v %v %v\n", s.tick, s.player.flying, s.player.crouching, s.player.surroundings)
+	for _, e := range s.entities.all {
+		fmt.Fprintf(&state, "%d %v %v %v %d\n", e.id, e.position, e.velocity, e.onGround, e.age)
+		if m, ok := e.controller.(*mob); ok {
+			fmt.Fprintf(&state, "  %d %d %v %v %d\n", m.state, m.stateTicks, m.goal, m.path != nil, m.next)
+		}
+	}
+	return state.String()
+}
+
+// replay runs commands through a fresh simulation and meadow, returning the state at the end and every edit.
+func replay(cmds []InputCommand) (string, []blockEdit) {
+	sim := NewSimulation(mgl32.Vec3{0, 5, 0}, defaultEngineSettings())
+	world := newEditableWorld(meadow{})
+	var edits []blockEdit
+	for _, cmd := range cmds {
+		sim.Queue(cmd)
+		edits = append(edits, world.step(sim)...)
+	}
+	return simulationState(sim), edits
+}
+
+func TestSimulationReplays(t *testing.T) {
+	// The same commands give the same run, the blocks the player edited and the mobs walking around them included
+	cmds := scriptedCommands(600)
+	state, edits := replay(cmds)
+	for range 3 {
+		again, againEdits := replay(cmds)
+		if again != state {
+			t.Fatalf("a replay ended in\n%s\nthe first run in\n%s", again, state)
+		}
+		if fmt.Sprint(againEdits) != fmt.Sprint(edits) {
+			t.Fatal("a replay edited other blocks than the first run")
+		}
+	}
+	broken, placed := 0, 0
+	for _, e := range edits {
+		if e.blockType == AirID {
+			broken++
+		} else {
+			placed++
+		}
+	}
+	if broken == 0 || placed == 0 {
+		t.Errorf("the script broke %d and placed %d blocks", broken, placed)
+	}
+	if !strings.Contains(state, "\n  ") {
+		t.Error("no mobs spawned during the script")
+	}
+
+	// Edits logged outside the simulation don't reach it unless they are passed in
+	logBlockEdit(0, 0, 0)
+	if again, _ := replay(cmds); again != state {
+		t.Error("a block edit logged outside the simulation changed the replay")
+	}
+	takeBlockEdits()
+}
+
+func TestSimulationEditsBlocks(t *testing.T) {
+	// Standing on the meadow, looking straight down at the grass under the feet
+	sim := NewSimulation(mgl32.Vec3{0.2, 2, -0.1}, defaultEngineSettings())
+	world := newEditableWorld(meadow{})
+	sim.Queue(InputCommand{ToggleFlying: true})
+	for range 30 {
+		world.step(sim)
+	}
+
+	// Placing a block where the player stands does nothing
+	sim.Queue(InputCommand{Pitch: -90, Place: DirtID})
+	if edits := world.step(sim); len(edits) != 0 {
+		t.Errorf("placed %v inside the player", edits)
+	}
+
+	// Breaking hands the block back to be removed and drops it as an item, without changing the world itself
+	sim.Queue(InputCommand{Pitch: -90, Break: true})
+	items := len(sim.entities.all)
+	edits := sim.Step(world)
+	if len(edits) != 1 || edits[0] != (blockEdit{[3]int32{0, 0, 0}, AirID}) {
+		t.Fatalf("breaking the grass underfoot edited %v", edits)
+	}
+	if len(sim.entities.all) != items+1 {
+		t.Error("breaking a block dropped no item")
+	}
+	if blockType, _ := world.BlockAt(0, 0, 0); blockType != GrassID {
+		t.Error("the step broke the block itself")
+	}
+
+	// Break and place are one-off actions, a step without a command doesn't repeat them
+	if edits := sim.Step(world); len(edits) != 0 {
+		t.Errorf("a step without a command edited %v", edits)
+	}
+
+	// Against the side of the wall, the block goes on the face looked at
+	sim = NewSimulation(mgl32.Vec3{7, 2.4, 0}, defaultEngineSettings())
+	sim.Queue(InputCommand{Place: StoneID})
+	if edits := world.step(sim); len(edits) != 1 || edits[0] != (blockEdit{[3]int32{9, 2, 0}, StoneID}) {
+		t.Errorf("placing against the wall edited %v", edits)
+	}
+	sim.Queue(InputCommand{Yaw: 180, Place: StoneID})
+	if edits := world.step(sim); len(edits) != 0 {
+		t.Errorf("placing with nothing in reach edited %v", edits)
+	}
+}
+
+func TestSimulationPassesEditsToMobs(t *testing.T) {
+	// A sheep with a path along the meadow, which only blocks passed to the simulation make it find again
+	sim := NewSimulation(mgl32.Vec3{0, 30, 0}, defaultEngineSettings())
+	world := newEditableWorld(meadow{})
+	sheep := sim.entities.spawn(newMob(mgl32.Vec3{2, 0.5 + 1.1, -10}, mobKinds[0]))
+	m := sheep.controller.(*mob)
+	m.state, m.stateTicks = mobWander, 100
+	m.setGoal([3]int32{8, 1, -10})
+	sim.tick = 1
+	world.step(sim)
+	if m.path == nil {
+		t.Fatal("the sheep found no path")
+	}
+
+	logBlockEdit(5, 1, -10)
+	sim.BlocksChanged([][3]int32{{30, 1, 30}}, false)
+	world.step(sim)
+	if m.path == nil {
+		t.Fatal("an edit far from the path dropped it")
+	}
+	sim.BlocksChanged([][3]int32{{5, 1, -10}}, false)
+	world.step(sim)
+	if m.path != nil && m.cooldown < mobSearchCooldown-1 {
+		t.Error("an edit on the path kept it")
+	}
+	takeBlockEdits()
+}
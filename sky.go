@@ -13,31 +13,31 @@ var (
 	skyInit    bool
 )
 
+// Cube from -1 to 1 (positions only), 36 vertices (12 triangles)
+// Winding is standard CCW; culling is disabled while drawing it.
+var cubeVertices = []float32{
+	// +X
+	1, -1, -1, 1, 1, -1, 1, 1, 1,
+	1, -1, -1, 1, 1, 1, 1, -1, 1,
+	// -X
+	-1, -1, -1, -1, -1, 1, -1, 1, 1,
+	-1, -1, -1, -1, 1, 1, -1, 1, -1,
+	// +Y
+	-1, 1, -1, 1, 1, -1, 1, 1, 1,
+	-1, 1, -1, 1, 1, 1, -1, 1, 1,
+	// -Y
+	-1, -1, -1, -1, -1, 1, 1, -1, 1,
+	-1, -1, -1, 1, -1, 1, 1, -1, -1,
+	// +Z
+	-1, -1, 1, 1, -1, 1, 1, 1, 1,
+	-1, -1, 1, 1, 1, 1, -1, 1, 1,
+	// -Z
+	-1, -1, -1, 1, -1, -1, 1, 1, -1,
+	-1, -1, -1, 1, 1, -1, -1, 1, -1,
+}
+
 // initSky sets up the cube VAO/VBO and compiles the sky shaders.
 func initSky() {
-	// Unit cube (positions only), 36 vertices (12 triangles)
-	// Winding is standard CCW; we will disable culling while drawing the sky.
-	cubeVertices := []float32{
-		// +X
-		1, -1, -1, 1, 1, -1, 1, 1, 1,
-		1, -1, -1, 1, 1, 1, 1, -1, 1,
-		// -X
-		-1, -1, -1, -1, -1, 1, -1, 1, 1,
-		-1, -1, -1, -1, 1, 1, -1, 1, -1,
-		// +Y
-		-1, 1, -1, 1, 1, -1, 1, 1, 1,
-		-1, 1, -1, 1, 1, 1, -1, 1, 1,
-		// -Y
-		-1, -1, -1, -1, -1, 1, 1, -1, 1,
-		-1, -1, -1, 1, -1, 1, 1, -1, -1,
-		// +Z
-		-1, -1, 1, 1, -1, 1, 1, 1, 1,
-		-1, -1, 1, 1, 1, 1, -1, 1, 1,
-		// -Z
-		-1, -1, -1, 1, -1, -1, 1, 1, -1,
-		-1, -1, -1, 1, 1, -1, -1, 1, -1,
-	}
-
 	// Create VAO/VBO
 	gl.GenVertexArrays(1, &skyVAO)
 	gl.BindVertexArray(skyVAO)
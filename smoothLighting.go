@@ -0,0 +1,140 @@
+package main
+
+// Per vertex smooth lighting and ambient occlusion, used by the mesher while AmbientOcclusion is on.
+
+// Ambient occlusion strength for 0 to 3 visible neighbors around a vertex
+var aoCurve = [4]float32{0.5, 0.7, 0.85, 1.0}
+
+// Directional shading per face, indexed by FACE_MAP
+var faceShade = [6]float32{
+	0.7, // FRONT
+	0.7, // BACK
+	0.6, // LEFT
+	0.6, // RIGHT
+	0.8, // UP
+	0.5, // DOWN
+}
+
+var faceNormals = [6]Vec3Int8{
+	{0, 0, 1},  // FRONT
+	{0, 0, -1}, // BACK
+	{-1, 0, 0}, // LEFT
+	{1, 0, 0},  // RIGHT
+	{0, 1, 0},  // UP
+	{0, -1, 0}, // DOWN
+}
+
+// The 3x3x3 blocks around a block being meshed
+type neighborhood struct {
+	blocks [3][3][3]Block
+	loaded [3][3][3]bool
+}
+
+func sampleNeighborhood(world map[PillarPos]*Pillar, ch *Chunk, key blockPosition, chunkPos ChunkPosition) neighborhood {
+	var n neighborhood
+	for dx := int8(-1); dx <= 1; dx++ {
+		for dy := int8(-1); dy <= 1; dy++ {
+			for dz := int8(-1); dz <= 1; dz++ {
+				x, y, z := int8(key.x)+dx, int8(key.y)+dy, int8(key.z)+dz
+				if x >= 0 && x < int8(CHUNK_SIZE) && y >= 0 && y < int8(CHUNK_SIZE) && z >= 0 && z < int8(CHUNK_SIZE) {
+					n.blocks[dx+1][dy+1][dz+1] = ch.getBlock(uint8(x), uint8(y), uint8(z))
+					n.loaded[dx+1][dy+1][dz+1] = true
+					continue
+				}
+				n.blocks[dx+1][dy+1][dz+1], n.loaded[dx+1][dy+1][dz+1] = getBlockRelative(world, chunkPos, key, Vec3Int8{dx, dy, dz})
+			}
+		}
+	}
+	return n
+}
+
+// getBlockRelative looks up the block at a -1..1 offset from key in world, which may lie in a neighboring chunk.
+func getBlockRelative(world map[PillarPos]*Pillar, chunkPos ChunkPosition, key blockPosition, dir Vec3Int8) (Block, bool) {
+	cP, bP := calculateCrossChunkNeighbor(chunkPos, key.x, key.y, key.z, dir)
+	pillar := world[cP.pillarPos]
+	if pillar == nil {
+		return Block{}, false
+	}
+	ch := pillar.chunkOrSky(cP.y)
+	if ch == nil {
+		return Block{}, false
+	}
+	return ch.getBlock(bP.x, bP.y, bP.z), true
+}
+
+func (n *neighborhood) at(d Vec3Int8) (Block, bool) {
+	return n.blocks[d.x+1][d.y+1][d.z+1], n.loaded[d.x+1][d.y+1][d.z+1]
+}
+
+func (n *neighborhood) occludes(d Vec3Int8) bool {
+	block, ok := n.at(d)
+	return ok && block.isSolid()
+}
+
+func (n *neighborhood) sampleLight(d Vec3Int8) float32 {
+	block, ok := n.at(d)
+	if !ok {
+		return float32(maxLightLevel)
+	}
+	return float32(block.lightLevel())
+}
+
+// vertexLight averages the light of the 4 cells touching the face corner at offset vx, vy, vz (each ±0.5)
+// and darkens it by the face direction and the classic side1/side2/corner ambient occlusion.
+func (n *neighborhood) vertexLight(face uint8, vx, vy, vz float32) float32 {
+	sign := func(v float32) int8 {
+		if v > 0 {
+			return 1
+		}
+		return -1
+	}
+	normal := faceNormals[face]
+
+	// The two in plane directions pointing towards this corner
+	var a1, a2 Vec3Int8
+	switch face {
+	case FACE_MAP.FRONT, FACE_MAP.BACK:
+		a1, a2 = Vec3Int8{sign(vx), 0, 0}, Vec3Int8{0, sign(vy), 0}
+	case FACE_MAP.LEFT, FACE_MAP.RIGHT:
+		a1, a2 = Vec3Int8{0, 0, sign(vz)}, Vec3Int8{0, sign(vy), 0}
+	default:
+		a1, a2 = Vec3Int8{sign(vx), 0, 0}, Vec3Int8{0, 0, sign(vz)}
+	}
+	add := func(a, b Vec3Int8) Vec3Int8 {
+		return Vec3Int8{a.x + b.x, a.y + b.y, a.z + b.z}
+	}
+	side1Pos := add(normal, a1)
+	side2Pos := add(normal, a2)
+	cornerPos := add(side1Pos, a2)
+
+	side1, side2, corner := n.occludes(side1Pos), n.occludes(side2Pos), n.occludes(cornerPos)
+
+	ao := 3
+	if side1 && side2 {
+		ao = 0
+	} else {
+		for _, occluded := range []bool{side1, side2, corner} {
+			if occluded {
+				ao--
+			}
+		}
+	}
+
+	// Only open cells carry light; the corner can't be seen through two solid sides
+	light := n.sampleLight(normal)
+	count := float32(1)
+	if !side1 {
+		light += n.sampleLight(side1Pos)
+		count++
+	}
+	if !side2 {
+		light += n.sampleLight(side2Pos)
+		count++
+	}
+	if !corner && !(side1 && side2) {
+		light += n.sampleLight(cornerPos)
+		count++
+	}
+
+	return light / count * faceShade[face] * aoCurve[ao]
+}
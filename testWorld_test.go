@@ -0,0 +1,102 @@
+package main
+
+import "testing"
+
+/*
+ * Hand-built worlds for the tests: pillars of whole chunks put straight into the loaded pillars, with no
+ * generator, streaming or OpenGL involved.
+ */
+
+// useWorld loads a hand-built set of pillars in place of the world for the length of a test.
+func useWorld(t *testing.T, world map[PillarPos]*Pillar) {
+	t.Helper()
+	pillarsMu.Lock()
+	saved := pillars
+	pillars = world
+	pillarsMu.Unlock()
+	t.Cleanup(func() {
+		pillarsMu.Lock()
+		pillars = saved
+		pillarsMu.Unlock()
+		lightJobsMu.Lock()
+		lightJobs = nil
+		lightJobsMu.Unlock()
+		takeBlockEdits()
+		fluids = newFluidSim()
+	})
+}
+
+// groundPillar builds a pillar of stone chunks from chunk Y bottom up to ground, with air chunks above it up to
+// top. The light is left dark, see lightLoadedWorld.
+func groundPillar(pos PillarPos, bottom, ground, top int32) *Pillar {
+	pillar := &Pillar{pos: pos, bottom: bottom}
+	for y := bottom; y <= top; y++ {
+		if y <= ground {
+			pillar.chunks = append(pillar.chunks, newChunk(StoneID))
+		} else {
+			pillar.chunks = append(pillar.chunks, newChunk(AirID))
+		}
+	}
+	return pillar
+}
+
+// groundWorld builds the pillars from -radius to radius on both axes as groundPillar does.
+func groundWorld(radius, bottom, ground, top int32) map[PillarPos]*Pillar {
+	world := make(map[PillarPos]*Pillar)
+	for x := -radius; x <= radius; x++ {
+		for z := -radius; z <= radius; z++ {
+			world[PillarPos{x, z}] = groundPillar(PillarPos{x, z}, bottom, ground, top)
+		}
+	}
+	return world
+}
+
+// lightLoadedWorld lights every loaded pillar as the lighting worker lights freshly generated ones.
+func lightLoadedWorld(t *testing.T) {
+	t.Helper()
+	pillarsMu.RLock()
+	var positions []PillarPos
+	for pos := range pillars {
+		positions = append(positions, pos)
+	}
+	pillarsMu.RUnlock()
+	for _, pos := range positions {
+		processLightJob(lightJob{kind: lightPillarJob, pillar: pos})
+	}
+	runLightJobs()
+}
+
+// runLightJobs works through the queued light jobs, as the lighting worker would.
+func runLightJobs() {
+	for {
+		job, ok := nextLightJob()
+		if !ok {
+			return
+		}
+		processLightJob(job)
+	}
+}
+
+// setTestBlock edits the block at a world position the way the player does.
+func setTestBlock(t *testing.T, x, y, z int32, blockType uint16) {
+	t.Helper()
+	pos := worldToChunkBlock(x, y, z)
+	setBlockAndRelight(pos.blockPos, pos.chunkPos, blockType)
+}
+
+// testBlock returns the block at a world position.
+func testBlock(t *testing.T, x, y, z int32) Block {
+	t.Helper()
+	pos := worldToChunkBlock(x, y, z)
+	pillarsMu.RLock()
+	defer pillarsMu.RUnlock()
+	pillar := pillars[pos.chunkPos.pillarPos]
+	if pillar == nil {
+		t.Fatalf("block %d,%d,%d isn't loaded", x, y, z)
+	}
+	ch := pillar.chunkOrSky(pos.chunkPos.y)
+	if ch == nil {
+		t.Fatalf("block %d,%d,%d is below the pillar", x, y, z)
+	}
+	return ch.getBlock(pos.blockPos.x, pos.blockPos.y, pos.blockPos.z)
+}
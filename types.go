@@ -1,32 +1,135 @@
 package main
 
-import "github.com/go-gl/mathgl/mgl32"
+import (
+	"sync/atomic"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
 
 /*
  * Chunks: 16x16x16 blocks
- * Pillars: arrays of chunks, summing up to 1024 blocks in height
- * Accesing a chunk is really easy, we just need to get the X,Z pos of the pillar, and div the Y pos by 16 to get the chunk index.
+ * Pillars: a column of chunks, found by the X,Z pos of the pillar; the Y pos floor divided by 16 is the chunk Y.
+ * A pillar only holds the chunks from its bottom up to the highest one with anything but air in it. Everything
+ * above that is open sky: air in full sunlight, read through skyChunk and only allocated once something is put
+ * there. Below the bottom is ground that isn't generated yet: digging out a block at the bottom grows the pillar
+ * a chunk further down, so the world reaches as deep as it is dug.
  */
 
 type Pillar struct {
-	chunks [64]*Chunk // 64 chunks per pillar
+	chunks []*Chunk // chunks[i] is at chunk Y bottom+i
+	bottom int32
 	pos    PillarPos
+	dirty  atomic.Bool // modified since it was last saved to its region file
+	lit    atomic.Bool // holds computed light, either loaded from disk or committed by the lighting worker
+
+	meshReady atomic.Bool  // first meshes queued, see queueReadyPillarMeshes
+	lod       atomic.Int32 // level of detail its meshes are built at, see lod.go
 }
 
 /*
- * To access a chunk, you would find the pillarPosition and access the chunk by its pillar index
+ * To access a chunk, you would find the pillarPosition and access the chunk by its chunk Y
  */
 type ChunkPosition struct {
 	pillarPos PillarPos
-	index     uint8
+	y         int32 // world Y of the chunk's bottom divided by CHUNK_SIZE
 }
 
 func (c ChunkPosition) getWorldY() int32 {
-	return int32(c.index*CHUNK_SIZE) - 32
+	return c.y * CHUNK_SIZE_i32
+}
+
+// chunkYFromWorldY returns the chunk Y of the chunk holding a world height.
+func chunkYFromWorldY(y int32) int32 {
+	return floorDiv(y, CHUNK_SIZE_i32)
+}
+
+// worldToChunkBlock finds the chunk and block of a world position.
+func worldToChunkBlock(x, y, z int32) ChunkBlockPositions {
+	return ChunkBlockPositions{
+		ChunkPosition{PillarPos{floorDiv(x, CHUNK_SIZE_i32), floorDiv(z, CHUNK_SIZE_i32)}, chunkYFromWorldY(y)},
+		blockPosition{uint8(floorMod(x, CHUNK_SIZE_i32)), uint8(floorMod(y, CHUNK_SIZE_i32)), uint8(floorMod(z, CHUNK_SIZE_i32))},
+	}
+}
+
+// worldPos returns the world coordinates of a block.
+func (p ChunkBlockPositions) worldPos() (x, y, z int32) {
+	return p.chunkPos.getWorldX() + int32(p.blockPos.x), p.chunkPos.getWorldY() + int32(p.blockPos.y), p.chunkPos.getWorldZ() + int32(p.blockPos.z)
+}
+
+// chunk returns the chunk at chunk Y y, nil below the bottom and in the open sky above the top.
+func (p *Pillar) chunk(y int32) *Chunk {
+	i := y - p.bottom
+	if i < 0 || i >= int32(len(p.chunks)) {
+		return nil
+	}
+	return p.chunks[i]
+}
+
+// top returns the chunk Y of the pillar's highest chunk.
+func (p *Pillar) top() int32 {
+	return p.bottom + int32(len(p.chunks)) - 1
+}
+
+// chunkOrSky is chunk, except that it returns skyChunk above the top. It is only for reading.
+func (p *Pillar) chunkOrSky(y int32) *Chunk {
+	if y > p.top() {
+		return skyChunk
+	}
+	return p.chunk(y)
 }
-func getWorldYFromIndex(cI uint8) int32 {
-	return int32(cI*CHUNK_SIZE) - 32
+
+// ensureChunk returns the chunk at chunk Y y, first growing the pillar up to it with sunlit air chunks when y
+// is in the sky. Below the bottom it returns nil, growPillarDown generates the chunks there. The caller must hold
+// pillarsMu for writing.
+func (p *Pillar) ensureChunk(y int32) *Chunk {
+	for y > p.top() {
+		p.chunks = append(p.chunks, newSkyChunk())
+	}
+	return p.chunk(y)
+}
+
+// snapshot copies the blocks and light of the pillar, for reading them without holding pillarsMu. The caller
+// must hold pillarsMu.
+func (p *Pillar) snapshot() *Pillar {
+	copied := &Pillar{pos: p.pos, bottom: p.bottom, chunks: make([]*Chunk, len(p.chunks))}
+	for i, ch := range p.chunks {
+		copied.chunks[i] = ch.snapshot()
+	}
+	return copied
+}
+
+// window copies the chunks from chunk Y y-1 to y+1 along with the level of detail, everything meshing chunk y
+// reads from the pillar. The caller must hold pillarsMu.
+func (p *Pillar) window(y int32) *Pillar {
+	bottom, top := max(y-1, p.bottom), min(y+1, p.top())
+	copied := &Pillar{pos: p.pos, bottom: bottom}
+	for cy := bottom; cy <= top; cy++ {
+		copied.chunks = append(copied.chunks, p.chunk(cy).snapshot())
+	}
+	copied.lod.Store(p.lod.Load())
+	return copied
+}
+
+// trimSky drops the air chunks at the top of the pillar, they are open sky.
+func (p *Pillar) trimSky() {
+	for len(p.chunks) > 0 {
+		blockType, ok := p.chunks[len(p.chunks)-1].isUniform()
+		if !ok || blockType != AirID {
+			break
+		}
+		p.chunks = p.chunks[:len(p.chunks)-1]
+	}
 }
+
+// Stands in for every chunk above a pillar's top, must never be written to
+var skyChunk = newSkyChunk()
+
+func newSkyChunk() *Chunk {
+	ch := newChunk(AirID)
+	ch.light.uniform = maxLightLevel << 4
+	return ch
+}
+
 func (c ChunkPosition) getWorldX() int32 {
 	return c.pillarPos.x * CHUNK_SIZE_i32
 }
@@ -41,10 +144,16 @@ func (p PillarPos) getWorldZ() int32 {
 }
 
 type Chunk struct {
-	blocksData   [CHUNK_SIZE][CHUNK_SIZE][CHUNK_SIZE]*Block
-	lightSources []blockPosition
-	vao          uint32
-	trisCount    int32
+	blocks          blockStorage // palette compressed block types, see chunkStorage.go
+	light           lightStorage
+	lightSources    []blockPosition
+	vao             uint32
+	vbo             uint32
+	indexCount      int32
+	waterVao        uint32 // translucent water faces, drawn after every opaque chunk
+	waterVbo        uint32
+	waterIndexCount int32
+	blockedFaces    faceConnectivity // faces that can't see each other, updated with the mesh
 }
 
 type Block struct {
@@ -53,6 +162,10 @@ type Block struct {
 	sunLight   uint8  // sunlight level of the block
 }
 
+func (c *Chunk) hasMesh() bool {
+	return c.indexCount > 0 || c.waterIndexCount > 0
+}
+
 func (block Block) isSolid() bool {
 	return BlockProperties[block.blockType].IsSolid
 }
@@ -91,10 +204,6 @@ type text struct {
 	FontSize float64
 	Content  interface{}
 }
-type collider struct {
-	Time   float32
-	Normal []int
-}
 type ChunkBlockPositions struct {
 	chunkPos ChunkPosition
 	blockPos blockPosition
@@ -0,0 +1,134 @@
+package main
+
+import "testing"
+
+func TestWorldToChunkBlock(t *testing.T) {
+	for _, c := range []struct {
+		x, y, z int32
+		want    ChunkBlockPositions
+	}{
+		{0, 0, 0, ChunkBlockPositions{ChunkPosition{PillarPos{0, 0}, 0}, blockPosition{0, 0, 0}}},
+		{-1, -1, -1, ChunkBlockPositions{ChunkPosition{PillarPos{-1, -1}, -1}, blockPosition{15, 15, 15}}},
+		{17, -16, -16, ChunkBlockPositions{ChunkPosition{PillarPos{1, -1}, -1}, blockPosition{1, 0, 0}}},
+		// Far below where pillars are generated down to
+		{5, -1000, 40, ChunkBlockPositions{ChunkPosition{PillarPos{0, 2}, -63}, blockPosition{5, 8, 8}}},
+	} {
+		got := worldToChunkBlock(c.x, c.y, c.z)
+		if got != c.want {
+			t.Errorf("%d,%d,%d is in %v, want %v", c.x, c.y, c.z, got, c.want)
+		}
+		if x, y, z := got.worldPos(); x != c.x || y != c.y || z != c.z {
+			t.Errorf("%d,%d,%d comes back as %d,%d,%d", c.x, c.y, c.z, x, y, z)
+		}
+	}
+}
+
+func TestEnsureChunkGrowsUp(t *testing.T) {
+	pillar := groundPillar(PillarPos{3, -2}, -1, 0, 2)
+
+	// Upwards it grows with sky, below the bottom there is nothing to return
+	if ch := pillar.ensureChunk(4); pillar.top() != 4 || ch != pillar.chunk(4) || ch.light.uniform != maxLightLevel<<4 {
+		t.Errorf("grown up to %d with light %x", pillar.top(), ch.light.uniform)
+	}
+	if ch := pillar.ensureChunk(-2); ch != nil || pillar.bottom != -1 {
+		t.Errorf("ensureChunk below the bottom returned %v and moved the bottom to %d", ch, pillar.bottom)
+	}
+}
+
+func TestGrowPillarDown(t *testing.T) {
+	world := groundWorld(0, -1, 0, 1)
+	useWorld(t, world)
+	pillar := world[PillarPos{0, 0}]
+	existing := pillar.chunk(-1)
+
+	// The generated ground goes under the chunks the pillar had
+	growPillarDown(PillarPos{0, 0}, -1)
+	if pillar.bottom != -2 || len(pillar.chunks) != 4 || pillar.chunk(-1) != existing {
+		t.Fatalf("grown down to %d with %d chunks", pillar.bottom, len(pillar.chunks))
+	}
+	generated := worldGenerator.GenerateChunk(ChunkPosition{pillar.pos, -2})
+	for i := range chunkVolume {
+		if pillar.chunk(-2).blocks.get(i) != generated.blocks.get(i) {
+			t.Fatal("chunk -2 isn't the generated ground")
+		}
+	}
+
+	// Once the bottom moved on, a chunk generated for the old one is left out
+	growPillarDown(PillarPos{0, 0}, -1)
+	if pillar.bottom != -2 || len(pillar.chunks) != 4 {
+		t.Errorf("growing from a bottom the pillar left behind grew it down to %d", pillar.bottom)
+	}
+}
+
+func TestDiggingThroughTheBottom(t *testing.T) {
+	// Stone down to chunk -1 under pillar 0,0, with the sky above chunk 0
+	world := groundWorld(1, -1, 0, 1)
+	useWorld(t, world)
+	lightLoadedWorld(t)
+	pillar := world[PillarPos{0, 0}]
+
+	// Whatever is below the bottom isn't there to collide with until it is generated
+	if _, loaded := (loadedWorld{}).BlockAt(3, -17, 3); loaded {
+		t.Error("ground under the bottom of a pillar counts as loaded")
+	}
+
+	// A hole in the bottom layer brings the ground under it, another one at the new bottom goes further
+	setTestBlock(t, 3, -15, 3, AirID)
+	if pillar.bottom != -1 {
+		t.Fatalf("digging above the bottom layer grew the pillar down to %d", pillar.bottom)
+	}
+	setTestBlock(t, 3, -16, 3, AirID)
+	if pillar.bottom != -2 {
+		t.Fatalf("digging the bottom layer grew the pillar down to %d", pillar.bottom)
+	}
+	if _, loaded := (loadedWorld{}).BlockAt(3, -17, 3); !loaded {
+		t.Error("the ground under the hole isn't loaded")
+	}
+	for y := int32(15); y >= -32; y-- {
+		setTestBlock(t, 3, y, 3, AirID)
+	}
+	if pillar.bottom != -3 {
+		t.Fatalf("a shaft from the surface down to -32 grew the pillar down to %d", pillar.bottom)
+	}
+	if got := testBlock(t, 3, -32, 3).blockType; got != AirID {
+		t.Errorf("the bottom of the shaft is %d", got)
+	}
+	if !pillar.dirty.Load() {
+		t.Error("the grown pillar isn't marked for saving")
+	}
+
+	// Water poured down the shaft falls all the way into the grown chunks
+	fluids = newFluidSim()
+	setTestBlock(t, 3, 15, 3, WaterID)
+	func() {
+		pillarsMu.Lock()
+		defer pillarsMu.Unlock()
+		settle(t, pillars, fluids, 200)
+	}()
+	if level := fluidLevel(testBlock(t, 3, -32, 3).blockType); level != maxFlowLevel {
+		t.Errorf("water at the bottom of the shaft has level %d", level)
+	}
+}
+
+func TestPillarWindow(t *testing.T) {
+	pillar := groundPillar(PillarPos{0, 0}, -1, 0, 2)
+	pillar.lod.Store(2)
+
+	// Only the chunks around y are copied, reading above the top still finds the sky and below the bottom nothing
+	w := pillar.window(2)
+	if w.bottom != 1 || w.top() != 2 || w.lod.Load() != 2 {
+		t.Fatalf("window around 2 spans %d to %d at level %d", w.bottom, w.top(), w.lod.Load())
+	}
+	if w.chunkOrSky(3) != skyChunk {
+		t.Error("the window has no sky over its top")
+	}
+	if w = pillar.window(-1); w.bottom != -1 || w.top() != 0 || w.chunkOrSky(-2) != nil {
+		t.Errorf("window around the bottom spans %d to %d", w.bottom, w.top())
+	}
+
+	// Edits made after the copy don't show up in it
+	pillar.chunk(0).setBlockType(1, 2, 3, AirID)
+	if got := w.chunk(0).getBlockType(1, 2, 3); got != StoneID {
+		t.Errorf("the window picked up an edit made after it, %d", got)
+	}
+}
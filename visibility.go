@@ -0,0 +1,170 @@
+package main
+
+import "math"
+
+/*
+ * Cave culling with a chunk visibility graph. When a chunk is meshed, a flood fill through its see through
+ * cells records which pairs of its 6 faces can see each other. At render time a BFS starts in the camera's
+ * chunk and only steps into a neighbor through a face that is reachable from the face it entered by, never
+ * turning back towards the camera. Chunks the BFS doesn't reach are hidden behind solid ground.
+ */
+
+// Bit a*6+b is set when faces a and b (FACE_MAP order) can NOT see each other through the chunk.
+// The zero value means fully open, which is what unmeshed chunks should count as.
+type faceConnectivity uint64
+
+const allFacesBlocked faceConnectivity = 1<<36 - 1
+
+func (c faceConnectivity) connected(a, b uint8) bool {
+	return c&(1<<(a*6+b)) == 0
+}
+
+func (c *faceConnectivity) connect(a, b uint8) {
+	*c &^= 1<<(a*6+b) | 1<<(b*6+a)
+}
+
+var oppositeFace = [6]uint8{
+	FACE_MAP.BACK, FACE_MAP.FRONT, // FRONT, BACK
+	FACE_MAP.RIGHT, FACE_MAP.LEFT, // LEFT, RIGHT
+	FACE_MAP.DOWN, FACE_MAP.UP, // UP, DOWN
+}
+
+func isSeeThrough(blockType uint16) bool {
+	return !BlockProperties[blockType].IsSolid || BlockProperties[blockType].IsTransparent
+}
+
+// touchedFaces returns the chunk faces a cell lies on, as a bitmask in FACE_MAP order.
+func touchedFaces(x, y, z uint8) uint8 {
+	var faces uint8
+	last := CHUNK_SIZE - 1
+	if z == last {
+		faces |= 1 << FACE_MAP.FRONT
+	}
+	if z == 0 {
+		faces |= 1 << FACE_MAP.BACK
+	}
+	if x == 0 {
+		faces |= 1 << FACE_MAP.LEFT
+	}
+	if x == last {
+		faces |= 1 << FACE_MAP.RIGHT
+	}
+	if y == last {
+		faces |= 1 << FACE_MAP.UP
+	}
+	if y == 0 {
+		faces |= 1 << FACE_MAP.DOWN
+	}
+	return faces
+}
+
+// computeConnectivity flood fills every see through region of the chunk and connects all the faces each
+// region touches.
+func computeConnectivity(ch *Chunk) faceConnectivity {
+	if blockType, ok := ch.isUniform(); ok {
+		if isSeeThrough(blockType) {
+			return 0
+		}
+		return allFacesBlocked
+	}
+
+	blocked := allFacesBlocked
+	var visited [chunkVolume]bool
+	var queue []blockPosition
+	for start := range chunkVolume {
+		if visited[start] {
+			continue
+		}
+		sx, sy, sz := uint8(start>>8), uint8(start>>4&0xF), uint8(start&0xF)
+		if !isSeeThrough(ch.getBlockType(sx, sy, sz)) {
+			continue
+		}
+
+		var faces uint8
+		visited[start] = true
+		queue = append(queue[:0], blockPosition{sx, sy, sz})
+		for head := 0; head < len(queue); head++ {
+			cur := queue[head]
+			faces |= touchedFaces(cur.x, cur.y, cur.z)
+			for _, dir := range CardinalDirections {
+				x, y, z := int(cur.x)+int(dir.x), int(cur.y)+int(dir.y), int(cur.z)+int(dir.z)
+				if x < 0 || y < 0 || z < 0 || x >= int(CHUNK_SIZE) || y >= int(CHUNK_SIZE) || z >= int(CHUNK_SIZE) {
+					continue
+				}
+				i := blockIndex(uint8(x), uint8(y), uint8(z))
+				if visited[i] || !isSeeThrough(ch.getBlockType(uint8(x), uint8(y), uint8(z))) {
+					continue
+				}
+				visited[i] = true
+				queue = append(queue, blockPosition{uint8(x), uint8(y), uint8(z)})
+			}
+		}
+
+		for a := range uint8(6) {
+			for b := range uint8(6) {
+				if faces&(1<<a) != 0 && faces&(1<<b) != 0 {
+					blocked.connect(a, b)
+				}
+			}
+		}
+	}
+	return blocked
+}
+
+// chunkYFromPosition maps a camera height to the chunk Y of the chunk containing it.
+func chunkYFromPosition(y float32) int32 {
+	return int32(math.Floor(float64(y) / float64(CHUNK_SIZE)))
+}
+
+type visibilityStep struct {
+	pos       ChunkPosition
+	entered   int8  // face of this chunk the BFS came in through, -1 for the camera chunk
+	travelled uint8 // directions taken so far, as a FACE_MAP bitmask
+}
+
+// visibleChunks walks the visibility graph outwards from the camera chunk, returning every chunk that may be
+// seen and lies inside the frustum. The open sky above the pillars is walked through like empty chunks, up to
+// one chunk over the highest pillar. The caller must hold pillarsMu. ok is false when the camera isn't inside a
+// loaded pillar, in which case the graph can't be used.
+func visibleChunks(camera ChunkPosition, view *frustum) (visible []ChunkPosition, ok bool) {
+	if pillar := pillars[camera.pillarPos]; pillar == nil || camera.y < pillar.bottom {
+		return nil, false
+	}
+	ceiling := camera.y
+	for _, pillar := range pillars {
+		ceiling = max(ceiling, pillar.top()+1)
+	}
+
+	visited := map[ChunkPosition]bool{camera: true}
+	queue := []visibilityStep{{camera, -1, 0}}
+	for head := 0; head < len(queue); head++ {
+		step := queue[head]
+		ch := pillars[step.pos.pillarPos].chunk(step.pos.y)
+		if ch != nil {
+			visible = append(visible, step.pos)
+		}
+
+		for dir := range uint8(6) {
+			// Never walk back towards the camera, it only makes the search leak around corners
+			if step.travelled&(1<<oppositeFace[dir]) != 0 {
+				continue
+			}
+			if ch != nil && step.entered >= 0 && !ch.blockedFaces.connected(uint8(step.entered), dir) {
+				continue
+			}
+
+			normal := faceNormals[dir]
+			next := ChunkPosition{PillarPos{step.pos.pillarPos.x + int32(normal.x), step.pos.pillarPos.z + int32(normal.z)}, step.pos.y + int32(normal.y)}
+			if visited[next] || next.y > ceiling {
+				continue
+			}
+			pillar := pillars[next.pillarPos]
+			if pillar == nil || next.y < pillar.bottom || !view.intersectsAABB(chunkAABB(next)) {
+				continue
+			}
+			visited[next] = true
+			queue = append(queue, visibilityStep{next, int8(oppositeFace[dir]), step.travelled | 1<<dir})
+		}
+	}
+	return visible, true
+}
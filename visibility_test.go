@@ -0,0 +1,133 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+var faceNames = [6]string{"front", "back", "left", "right", "up", "down"}
+
+// assertConnected checks every pair of faces, want lists the pairs that see each other.
+func assertConnected(t *testing.T, name string, c faceConnectivity, want ...[2]uint8) {
+	t.Helper()
+	for a := range uint8(6) {
+		for b := range uint8(6) {
+			expected := false
+			for _, pair := range want {
+				if pair == [2]uint8{a, b} || pair == [2]uint8{b, a} {
+					expected = true
+				}
+			}
+			if got := c.connected(a, b); got != expected {
+				t.Errorf("%s: %s and %s connected %v, want %v", name, faceNames[a], faceNames[b], got, expected)
+			}
+		}
+	}
+}
+
+func TestComputeConnectivity(t *testing.T) {
+	f := FACE_MAP
+	var all [][2]uint8
+	for a := range uint8(6) {
+		for b := range uint8(6) {
+			all = append(all, [2]uint8{a, b})
+		}
+	}
+
+	assertConnected(t, "air", computeConnectivity(newChunk(AirID)), all...)
+	assertConnected(t, "stone", computeConnectivity(newChunk(StoneID)))
+	// Leaves are solid but can be seen through
+	assertConnected(t, "leaves", computeConnectivity(newChunk(LeavesID)), all...)
+
+	// A straight tunnel along x
+	ch := newChunk(StoneID)
+	for x := range CHUNK_SIZE {
+		ch.setBlockType(x, 5, 5, AirID)
+	}
+	assertConnected(t, "tunnel", computeConnectivity(ch), [2]uint8{f.LEFT, f.RIGHT}, [2]uint8{f.LEFT, f.LEFT}, [2]uint8{f.RIGHT, f.RIGHT})
+
+	// Bending upwards halfway, with a separate pocket that touches no face and a dead end into the back face
+	ch = newChunk(StoneID)
+	for x := range uint8(8) {
+		ch.setBlockType(x, 5, 5, AirID)
+	}
+	for y := uint8(5); y < CHUNK_SIZE; y++ {
+		ch.setBlockType(7, y, 5, AirID)
+	}
+	ch.setBlockType(10, 10, 10, AirID)
+	for z := range uint8(3) {
+		ch.setBlockType(12, 2, z, AirID)
+	}
+	assertConnected(t, "bend", computeConnectivity(ch),
+		[2]uint8{f.LEFT, f.UP}, [2]uint8{f.LEFT, f.LEFT}, [2]uint8{f.UP, f.UP}, [2]uint8{f.BACK, f.BACK})
+
+	// A wall splits an air chunk into a front and a back half, which both see the four other faces
+	ch = newChunk(AirID)
+	for x := range CHUNK_SIZE {
+		for y := range CHUNK_SIZE {
+			ch.setBlockType(x, y, 8, StoneID)
+		}
+	}
+	var split [][2]uint8
+	for _, pair := range all {
+		if pair != [2]uint8{f.FRONT, f.BACK} && pair != [2]uint8{f.BACK, f.FRONT} {
+			split = append(split, pair)
+		}
+	}
+	assertConnected(t, "wall", computeConnectivity(ch), split...)
+
+	// A leaves block in the wall lets the halves see each other again
+	ch.setBlockType(3, 3, 8, LeavesID)
+	assertConnected(t, "window", computeConnectivity(ch), all...)
+
+	// One open corner cell touches three faces
+	ch = newChunk(StoneID)
+	ch.setBlockType(CHUNK_SIZE-1, CHUNK_SIZE-1, 0, AirID)
+	var corner [][2]uint8
+	for _, a := range []uint8{f.RIGHT, f.UP, f.BACK} {
+		for _, b := range []uint8{f.RIGHT, f.UP, f.BACK} {
+			corner = append(corner, [2]uint8{a, b})
+		}
+	}
+	assertConnected(t, "corner", computeConnectivity(ch), corner...)
+}
+
+func TestVisibleChunksStopAtSolidGround(t *testing.T) {
+	// Solid ground up to chunk Y 0 with the camera in an air chunk cut out of it, open to the sky
+	world := groundWorld(3, -1, 0, 1)
+	world[PillarPos{0, 0}].chunks[1] = newChunk(AirID)
+	for _, pillar := range world {
+		for _, ch := range pillar.chunks {
+			ch.blockedFaces = computeConnectivity(ch)
+		}
+	}
+	useWorld(t, world)
+	pillarsMu.RLock()
+	defer pillarsMu.RUnlock()
+
+	// Looking straight down sees the chunk under the camera, but nothing under the stone around it
+	eye := mgl32.Vec3{8, 8, 8}
+	view := mgl32.LookAtV(eye, eye.Add(mgl32.Vec3{0, -1, 0}), mgl32.Vec3{0, 0, -1})
+	f := extractFrustum(mgl32.Perspective(mgl32.DegToRad(70), 1, 0.1, 350).Mul4(view))
+	visible, ok := visibleChunks(ChunkPosition{PillarPos{0, 0}, 0}, &f)
+	if !ok {
+		t.Fatal("camera chunk isn't loaded")
+	}
+	seen := make(map[ChunkPosition]bool)
+	for _, cP := range visible {
+		seen[cP] = true
+	}
+	if !seen[ChunkPosition{PillarPos{0, 0}, -1}] {
+		t.Error("the chunk below the camera is hidden")
+	}
+	for _, cP := range visible {
+		if cP.y == -1 && cP.pillarPos != (PillarPos{0, 0}) {
+			t.Errorf("chunk %v under solid ground is visible", cP)
+		}
+	}
+
+	if _, ok := visibleChunks(ChunkPosition{PillarPos{9, 9}, 0}, &f); ok {
+		t.Error("visibility graph used from outside the loaded pillars")
+	}
+}
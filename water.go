@@ -0,0 +1,149 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+)
+
+/*
+ * Water. A source is WaterID; flowing water is one block type per level, FlowingWaterID+level-1 for levels 1 to
+ * maxFlowLevel, so the level is kept in the block palette like any other block and saved with it.
+ * Water is drawn in its own translucent pass after the opaque chunks. Faces between two water cells or between
+ * water and a solid block are never emitted, so only the surface and the sides against air are drawn.
+ */
+
+const (
+	FlowingWaterID uint16 = 0x100
+
+	maxFlowLevel uint8 = 7 // flowing water right next to a source, or falling
+	sourceLevel  uint8 = maxFlowLevel + 1
+)
+
+func init() {
+	for level := uint8(1); level <= maxFlowLevel; level++ {
+		BlockProperties[flowingWater(level)] = BlockProperties[WaterID]
+	}
+}
+
+func flowingWater(level uint8) uint16 {
+	return FlowingWaterID + uint16(level) - 1
+}
+
+func isFlowingWater(blockType uint16) bool {
+	return blockType >= FlowingWaterID && blockType < FlowingWaterID+uint16(maxFlowLevel)
+}
+
+func isWater(blockType uint16) bool {
+	return blockType == WaterID || isFlowingWater(blockType)
+}
+
+// fluidLevel returns sourceLevel for a source, 1..maxFlowLevel for flowing water and 0 for anything else.
+func fluidLevel(blockType uint16) uint8 {
+	switch {
+	case blockType == WaterID:
+		return sourceLevel
+	case isFlowingWater(blockType):
+		return uint8(blockType-FlowingWaterID) + 1
+	}
+	return 0
+}
+
+// SeaLevelFill fills the open cells above the terrain up to Level with water sources.
+type SeaLevelFill struct {
+	Level int32
+}
+
+func (s *SeaLevelFill) reach(surface int32) int32 {
+	return max(surface, s.Level)
+}
+
+func (s *SeaLevelFill) spread() int32 {
+	return 0
+}
+
+func (s *SeaLevelFill) Apply(ch *Chunk, ctx *generationContext) {
+	if ctx.pos.getWorldY() > s.Level {
+		return
+	}
+	for x := range CHUNK_SIZE {
+		for z := range CHUNK_SIZE {
+			height := ctx.heights[x][z]
+			for y := range CHUNK_SIZE {
+				_, worldY, _ := ctx.worldPos(x, y, z)
+				if worldY > height && worldY <= s.Level && ch.getBlockType(x, y, z) == AirID {
+					ch.setBlockType(x, y, z, WaterID)
+				}
+			}
+		}
+	}
+}
+
+// waterFaceHidden reports whether a water face looking into neighbor is covered.
+func waterFaceHidden(neighbor uint16) bool {
+	return BlockProperties[neighbor].IsSolid || isWater(neighbor)
+}
+
+// waterMeshChunk builds the translucent mesh of a chunk in world.
+func waterMeshChunk(world map[PillarPos]*Pillar, _Chunk *Chunk, chunkPos ChunkPosition) *[]uint32 {
+	var verts []uint32
+	if blockType, ok := _Chunk.isUniform(); ok && !isWater(blockType) {
+		return &verts
+	}
+
+	faces := greedyFacesPool.Get().(*greedyFaces)
+	defer greedyFacesPool.Put(faces)
+	*faces = greedyFaces{}
+
+	for x := range CHUNK_SIZE {
+		for y := range CHUNK_SIZE {
+			for z := range CHUNK_SIZE {
+				if !isWater(_Chunk.getBlockType(x, y, z)) {
+					continue
+				}
+
+				key := blockPosition{x, y, z}
+				for face := range uint8(len(faces)) {
+					result := getAdjBlockFromFace(world, key, chunkPos, face)
+					if result.ok && waterFaceHidden(result.Block.blockType) {
+						continue
+					}
+
+					light := float32(maxLightLevel)
+					if result.ok {
+						light = float32(result.Block.lightLevel())
+					}
+					if AmbientOcclusion {
+						light *= faceShade[face]
+					}
+					cornerLight := [4]float32{light, light, light, light}
+
+					// Sources and every flowing level share one texture, so they all merge
+					if GreedyMeshing {
+						slice, a, b := sliceCoords(face, key)
+						faces[face][slice][a][b] = greedyFace{set: true, blockType: WaterID, tint: noTint, light: cornerLight}
+					} else {
+						appendQuad(&verts, face, WaterID, noTint, cornerLight, key, key)
+					}
+				}
+			}
+		}
+	}
+
+	if GreedyMeshing {
+		verts = mergeGreedyFaces(faces, CHUNK_SIZE, 1)
+	}
+	return &verts
+}
+
+// waterDrawOrder sorts the chunks with water furthest first, so nearer water blends over the water behind it.
+func waterDrawOrder(chunks []ChunkPosition) {
+	distance := func(cP ChunkPosition) float32 {
+		dx := float32(cP.getWorldX()+CHUNK_SIZE_i32/2) - cameraPositionLerped[0]
+		dy := float32(cP.getWorldY()+CHUNK_SIZE_i32/2) - cameraPositionLerped[1]
+		dz := float32(cP.getWorldZ()+CHUNK_SIZE_i32/2) - cameraPositionLerped[2]
+		return dx*dx + dy*dy + dz*dz
+	}
+	slices.SortFunc(chunks, func(a, b ChunkPosition) int {
+		return cmp.Compare(distance(b), distance(a))
+	})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"text/tabwriter"
+)
+
+/*
+ * Headless world statistics for tuning generation. Run with -worldstats N to generate N chunks without opening a
+ * window and print how much of every 16 block height band each block type takes up. Chunks are generated pillar
+ * by pillar outwards from the origin, statsChunksPerPillar from the bottom new pillars are generated down to, so
+ * a multiple of 64 covers whole pillars.
+ */
+
+// Chunks sampled per pillar, reaching far above any generated terrain
+const statsChunksPerPillar = 64
+
+func runWorldStats(chunkCount int, out io.Writer) error {
+	generator := newBiomeWorldGenerator(worldSettings.Seed, NewBiomeSource(worldSettings.Seed))
+	side := int32(math.Ceil(math.Sqrt(float64((chunkCount + statsChunksPerPillar - 1) / statsChunksPerPillar))))
+
+	counts := make([]map[uint16]int, statsChunksPerPillar)
+	totals := make(map[uint16]int)
+	for i := range chunkCount {
+		pillar := int32(i / statsChunksPerPillar)
+		index := i % statsChunksPerPillar
+		pos := ChunkPosition{PillarPos{pillar%side - side/2, pillar/side - side/2}, GENERATION_BOTTOM_CHUNK_Y + int32(index)}
+		ch := generator.GenerateChunk(pos)
+
+		band := counts[index]
+		if band == nil {
+			band = make(map[uint16]int)
+			counts[index] = band
+		}
+		for x := range CHUNK_SIZE {
+			for y := range CHUNK_SIZE {
+				for z := range CHUNK_SIZE {
+					blockType := ch.getBlockType(x, y, z)
+					band[blockType]++
+					totals[blockType]++
+				}
+			}
+		}
+	}
+
+	blockTypes := slices.Sorted(maps.Keys(totals))
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "%d chunks, %% of blocks per height band\t", chunkCount)
+	for _, blockType := range blockTypes {
+		fmt.Fprintf(w, "%s\t", blockName(blockType))
+	}
+	fmt.Fprintln(w)
+
+	for index, band := range counts {
+		// Bands of open sky are left out
+		if band == nil || len(band) == 1 && band[AirID] > 0 {
+			continue
+		}
+		var blocks int
+		for _, count := range band {
+			blocks += count
+		}
+		bottom := (GENERATION_BOTTOM_CHUNK_Y + int32(index)) * CHUNK_SIZE_i32
+		fmt.Fprintf(w, "y %d..%d\t", bottom, bottom+CHUNK_SIZE_i32-1)
+		for _, blockType := range blockTypes {
+			fmt.Fprintf(w, "%s\t", formatShare(band[blockType], blocks))
+		}
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintf(w, "blocks\t")
+	for _, blockType := range blockTypes {
+		fmt.Fprintf(w, "%d\t", totals[blockType])
+	}
+	fmt.Fprintln(w)
+	return w.Flush()
+}
+
+func blockName(blockType uint16) string {
+	if name := BlockProperties[blockType].Name; name != "" {
+		return name
+	}
+	return fmt.Sprintf("#%d", blockType)
+}
+
+func formatShare(count, total int) string {
+	if count == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.3f", 100*float64(count)/float64(total))
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+/*
+ * World streaming: every tick the missing pillars around the camera are queued for the chunk workers, which
+ * load them nearest first, and pillars that drifted past UNLOAD_DISTANCE_i32 are saved and dropped. The gap
+ * between the generation and the unload distance keeps pillars from being loaded and evicted over and over.
+ * GL objects can only be deleted on the render thread, so evicted meshes are handed to ProcessChunks.
+ */
+
+type streamingCounters struct {
+	loaded  atomic.Int64 // pillars currently in memory
+	queued  atomic.Int64 // pillars in range still waiting to be loaded
+	evicted atomic.Int64 // pillars unloaded since start
+}
+
+var streamingStats streamingCounters
+
+func (c *streamingCounters) String() string {
+	return fmt.Sprintf("Pillars: %d loaded, %d queued, %d evicted", c.loaded.Load(), c.queued.Load(), c.evicted.Load())
+}
+
+type chunkMesh struct {
+	vao, vbo uint32
+}
+
+var freedMeshes []chunkMesh
+var freedMeshesMu sync.Mutex
+
+// spiralOffsets lists every pillar offset within radius, ordered from the center outwards.
+func spiralOffsets(radius int32) []PillarPos {
+	var offsets []PillarPos
+	for x := -radius; x <= radius; x++ {
+		for z := -radius; z <= radius; z++ {
+			offsets = append(offsets, PillarPos{x, z})
+		}
+	}
+	slices.SortStableFunc(offsets, func(a, b PillarPos) int {
+		return int(a.x*a.x+a.z*a.z) - int(b.x*b.x+b.z*b.z)
+	})
+	return offsets
+}
+
+func cameraPillar() PillarPos {
+	return PillarPos{
+		int32(math.Floor(float64(cameraPosition[0] / float32(CHUNK_SIZE)))),
+		int32(math.Floor(float64(cameraPosition[2] / float32(CHUNK_SIZE)))),
+	}
+}
+
+func streamWorld() {
+	offsets := spiralOffsets(GENERATION_DISTANCE_i32)
+	lastCenter := cameraPillar()
+
+	ticker := time.NewTicker(time.Duration(TICK_UPDATE_RATE * float32(time.Second)))
+	for range ticker.C {
+		center := cameraPillar()
+		if center != lastCenter {
+			chunkJobs.reprioritize(center)
+			updatePillarLODs(center)
+			lastCenter = center
+		}
+		loadPillarsAround(center, offsets)
+		unloadPillarsAround(center)
+	}
+}
+
+// loadPillarsAround queues generation of every missing pillar in range; the worker pool runs them nearest first.
+func loadPillarsAround(center PillarPos, offsets []PillarPos) {
+	queued := 0
+	for _, offset := range offsets {
+		pos := PillarPos{center.x + offset.x, center.z + offset.z}
+
+		pillarsMu.RLock()
+		_, exists := pillars[pos]
+		pillarsMu.RUnlock()
+		if exists {
+			continue
+		}
+
+		queued++
+		queuePillarGeneration(pos)
+	}
+	streamingStats.queued.Store(int64(queued))
+}
+
+func unloadPillarsAround(center PillarPos) {
+	var far []PillarPos
+	pillarsMu.RLock()
+	for pos := range pillars {
+		if max(abs32(pos.x-center.x), abs32(pos.z-center.z)) > UNLOAD_DISTANCE_i32 {
+			far = append(far, pos)
+		}
+	}
+	pillarsMu.RUnlock()
+
+	for i, pos := range far {
+		if i >= MAX_PILLAR_UNLOADS_PER_TICK {
+			break
+		}
+		unloadPillar(pos)
+	}
+}
+
+// unloadPillar removes a pillar from the world, saving it first if it was modified.
+func unloadPillar(pos PillarPos) {
+	pillarsMu.Lock()
+	pillar := pillars[pos]
+	if pillar == nil {
+		pillarsMu.Unlock()
+		return
+	}
+	// A pillar that was never lit would be stored dark, it's simply generated again next time
+	save := worldStore != nil && pillar.dirty.Load() && pillar.lit.Load()
+	var saveNumber uint64
+	if save {
+		saveNumber = beginPillarSave(pos)
+	}
+	delete(pillars, pos)
+	var meshes []chunkMesh
+	for _, ch := range pillar.chunks {
+		if ch != nil {
+			meshes = append(meshes, chunkMesh{ch.vao, ch.vbo}, chunkMesh{ch.waterVao, ch.waterVbo})
+		}
+	}
+	pillarsMu.Unlock()
+
+	freedMeshesMu.Lock()
+	freedMeshes = append(freedMeshes, meshes...)
+	freedMeshesMu.Unlock()
+
+	if save {
+		if err := savePillarCopy(pillar, saveNumber); err != nil {
+			log.Printf("saving pillar %d,%d: %v", pos.x, pos.z, err)
+		}
+	}
+
+	streamingStats.loaded.Add(-1)
+	streamingStats.evicted.Add(1)
+}
+
+// deleteFreedMeshes releases the GL objects of unloaded chunks. Must be called from the render thread.
+func deleteFreedMeshes() {
+	freedMeshesMu.Lock()
+	meshes := freedMeshes
+	freedMeshes = nil
+	freedMeshesMu.Unlock()
+
+	for _, mesh := range meshes {
+		deleteChunkMesh(mesh)
+	}
+}
+
+func deleteChunkMesh(mesh chunkMesh) {
+	if mesh.vao != 0 {
+		gl.DeleteVertexArrays(1, &mesh.vao)
+	}
+	if mesh.vbo != 0 {
+		gl.DeleteBuffers(1, &mesh.vbo)
+	}
+}
+
+func abs32(v int32) int32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
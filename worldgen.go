@@ -0,0 +1,435 @@
+package main
+
+import (
+	"math"
+
+	"github.com/ojrac/opensimplex-go"
+)
+
+/*
+ * World generation pipeline. A StagedGenerator runs an ordered list of stages over every chunk: heightmap,
+ * surface rules, fluids, carvers, ores and decorators. Each stage is its own seeded type and only touches the chunk it
+ * is handed, so a stage can be run and checked on a single ChunkPosition.
+ */
+
+type WorldGenerator interface {
+	GenerateChunk(pos ChunkPosition) *Chunk
+	// HighestBlock returns a world height no block of the pillar is generated above, chunks over it are sky
+	HighestBlock(pos PillarPos) int32
+}
+
+// The generator used for every new chunk
+var worldGenerator WorldGenerator = newBiomeWorldGenerator(worldSettings.Seed, worldBiomes)
+
+// Data passed along the stages of one chunk
+type generationContext struct {
+	pos     ChunkPosition
+	heights [CHUNK_SIZE][CHUNK_SIZE]int32   // world height of the surface block of each column, set by the heightmap
+	biomes  [CHUNK_SIZE][CHUNK_SIZE]BiomeID // biome of each column, set by a heightmap with biomes
+}
+
+type GenerationStage interface {
+	Apply(ch *Chunk, ctx *generationContext)
+}
+
+// A heightmap that knows the surface height and biome of a column without generating a chunk
+type columnHeightSource interface {
+	Height(worldX, worldZ int32) int32
+	Biome(worldX, worldZ int32) BiomeID
+}
+
+// Surface rules that know the surface block of a column without generating a chunk
+type surfaceSource interface {
+	top(biome BiomeID) uint16
+}
+
+// A carver that can tell whether it removes one block without generating its chunk. Carvers that can't, like
+// underground lakes, must stay clear of the surface.
+type blockCarver interface {
+	carves(worldX, worldY, worldZ, height int32) bool
+}
+
+// What the surface of any column ends up as, for decorators growing over the border of their chunk
+type columnSource interface {
+	Height(worldX, worldZ int32) int32
+	Ground(worldX, worldZ, height int32) (BiomeID, uint16)
+}
+
+// A stage that places blocks above the surface, reporting how high it may go over the highest surface within
+// spread blocks of the pillar
+type reachingStage interface {
+	reach(surface int32) int32
+	spread() int32
+}
+
+type StagedGenerator struct {
+	Heightmap  GenerationStage
+	Surface    GenerationStage
+	Fluids     GenerationStage
+	Carvers    []GenerationStage
+	Ores       []GenerationStage
+	Decorators []GenerationStage
+}
+
+func (g *StagedGenerator) stages() []GenerationStage {
+	var stages []GenerationStage
+	for _, stage := range []GenerationStage{g.Heightmap, g.Surface, g.Fluids} {
+		if stage != nil {
+			stages = append(stages, stage)
+		}
+	}
+	stages = append(stages, g.Carvers...)
+	stages = append(stages, g.Ores...)
+	return append(stages, g.Decorators...)
+}
+
+func (g *StagedGenerator) GenerateChunk(pos ChunkPosition) *Chunk {
+	ch := newChunk(AirID)
+	ctx := &generationContext{pos: pos}
+	for _, stage := range g.stages() {
+		stage.Apply(ch, ctx)
+	}
+	ch.compact()
+	return ch
+}
+
+// Used for pillars of a heightmap that can't tell its heights up front: the height of the old 64 chunk pillars
+const defaultHighestBlock = (GENERATION_BOTTOM_CHUNK_Y+64)*CHUNK_SIZE_i32 - 1
+
+func (g *StagedGenerator) HighestBlock(pos PillarPos) int32 {
+	heights, ok := g.Heightmap.(columnHeightSource)
+	if !ok {
+		return defaultHighestBlock
+	}
+	// The pillar's own surface, and the highest one around it that a stage may spill over from
+	var spread int32
+	for _, stage := range g.stages() {
+		if reaching, ok := stage.(reachingStage); ok {
+			spread = max(spread, reaching.spread())
+		}
+	}
+	surface, around := int32(math.MinInt32), int32(math.MinInt32)
+	for x := -spread; x < CHUNK_SIZE_i32+spread; x++ {
+		for z := -spread; z < CHUNK_SIZE_i32+spread; z++ {
+			height := heights.Height(pos.getWorldX()+x, pos.getWorldZ()+z)
+			around = max(around, height)
+			if x >= 0 && x < CHUNK_SIZE_i32 && z >= 0 && z < CHUNK_SIZE_i32 {
+				surface = max(surface, height)
+			}
+		}
+	}
+	highest := surface
+	for _, stage := range g.stages() {
+		if reaching, ok := stage.(reachingStage); ok {
+			highest = max(highest, reaching.reach(around))
+		}
+	}
+	return highest
+}
+
+// Height returns the surface height of a column, math.MinInt32 for heightmaps that can't tell.
+func (g *StagedGenerator) Height(worldX, worldZ int32) int32 {
+	if heights, ok := g.Heightmap.(columnHeightSource); ok {
+		return heights.Height(worldX, worldZ)
+	}
+	return math.MinInt32
+}
+
+// Ground returns the biome of a column and the block its surface at height ends up as after every stage.
+// Ores only replace stone and fluids only fill over the surface, so the surface rules and carvers decide it.
+func (g *StagedGenerator) Ground(worldX, worldZ, height int32) (BiomeID, uint16) {
+	var biome BiomeID
+	if heights, ok := g.Heightmap.(columnHeightSource); ok {
+		biome = heights.Biome(worldX, worldZ)
+	}
+	ground := StoneID
+	if heightmap, ok := g.Heightmap.(*HeightmapStage); ok {
+		ground = heightmap.Block
+	}
+	if surface, ok := g.Surface.(surfaceSource); ok {
+		ground = surface.top(biome)
+	}
+	for _, stage := range g.Carvers {
+		if carver, ok := stage.(blockCarver); ok && carver.carves(worldX, height, worldZ, height) {
+			return biome, AirID
+		}
+	}
+	return biome, ground
+}
+
+// The original terrain: a 2D noise heightmap of stone and dirt
+func newClassicWorldGenerator(seed int64) *StagedGenerator {
+	return &StagedGenerator{
+		Heightmap: NewHeightmapStage(seed),
+		Surface:   &SurfaceRules{Top: StoneID, Filler: DirtID, FillerFloor: 0},
+	}
+}
+
+// The original terrain with cheese and spaghetti caves carved out of it
+func newCaveWorldGenerator(seed int64) *StagedGenerator {
+	g := newClassicWorldGenerator(seed)
+	g.Carvers = []GenerationStage{NewNoiseCaveCarver(seed)}
+	return g
+}
+
+// Biome shaped terrain with seas, cheese and spaghetti caves, underground lakes and ores, trees and plants
+func newBiomeWorldGenerator(seed int64, biomes *BiomeSource) *StagedGenerator {
+	heightmap := NewHeightmapStage(seed)
+	heightmap.Biomes = biomes
+	carver := NewNoiseCaveCarver(seed)
+	carver.SeaLevel = SEA_LEVEL
+	g := &StagedGenerator{
+		Heightmap: heightmap,
+		Surface:   BiomeSurface{},
+		Fluids:    &SeaLevelFill{Level: SEA_LEVEL},
+		Carvers:   []GenerationStage{carver, NewLakeStage(seed)},
+		Ores:      []GenerationStage{NewOreStage(seed)},
+	}
+	vegetation := NewVegetationDecorator(seed, g)
+	vegetation.SeaLevel = SEA_LEVEL
+	g.Decorators = []GenerationStage{vegetation}
+	return g
+}
+
+// worldPos returns the world coordinates of block x, y, z of the chunk being generated.
+func (ctx *generationContext) worldPos(x, y, z uint8) (int32, int32, int32) {
+	return ctx.pos.getWorldX() + int32(x), ctx.pos.getWorldY() + int32(y), ctx.pos.getWorldZ() + int32(z)
+}
+
+// The hills of a heightmap without biomes reach this far over and under its base height at amplitude 1
+const classicHillHeight = 10
+
+type HeightmapStage struct {
+	noise       opensimplex.Noise32
+	Amplitude   float32 // scales the hills, classicHillHeight or the biomes' height variation at 1
+	Scale       float32
+	Octaves     int
+	Lacunarity  float32
+	Persistence float32
+	BaseHeight  int32
+	Block       uint16       // the terrain is filled with this, surface rules replace it afterwards
+	Biomes      *BiomeSource // when set, the blended biome height profiles replace BaseHeight
+}
+
+func NewHeightmapStage(seed int64) *HeightmapStage {
+	return &HeightmapStage{
+		noise:       opensimplex.New32(seed),
+		Amplitude:   worldSettings.TerrainAmplitude,
+		Scale:       worldSettings.TerrainScale,
+		Octaves:     2,
+		Lacunarity:  1.5,
+		Persistence: 0.5,
+		BaseHeight:  40,
+		Block:       StoneID,
+	}
+}
+
+func (s *HeightmapStage) Height(worldX, worldZ int32) int32 {
+	if s.Biomes == nil {
+		return fractalNoise(s.noise, worldX, worldZ, s.Amplitude*classicHillHeight, s.Octaves, s.Lacunarity, s.Persistence, s.Scale) + s.BaseHeight
+	}
+	baseHeight, variation := s.Biomes.heightProfile(worldX, worldZ)
+	detail := fractalNoise2D(s.noise, worldX, worldZ, s.Octaves, s.Lacunarity, s.Persistence, s.Scale)
+	return int32(math.Floor(float64(baseHeight + detail*variation*s.Amplitude)))
+}
+
+// Biome returns the biome of a column, the first one when the heightmap has no biomes.
+func (s *HeightmapStage) Biome(worldX, worldZ int32) BiomeID {
+	if s.Biomes == nil {
+		return 0
+	}
+	return s.Biomes.BiomeAt(worldX, worldZ)
+}
+
+func (s *HeightmapStage) Apply(ch *Chunk, ctx *generationContext) {
+	for x := range CHUNK_SIZE {
+		for z := range CHUNK_SIZE {
+			worldX, chunkY, worldZ := ctx.worldPos(x, 0, z)
+			height := s.Height(worldX, worldZ)
+			ctx.heights[x][z] = height
+			ctx.biomes[x][z] = s.Biome(worldX, worldZ)
+			for y := range CHUNK_SIZE {
+				if chunkY+int32(y) <= height {
+					ch.setBlockType(x, y, z, s.Block)
+				}
+			}
+		}
+	}
+}
+
+// SurfaceRules replaces the top of each heightmap column.
+type SurfaceRules struct {
+	Top         uint16 // the surface block
+	Filler      uint16 // the blocks just under the surface
+	FillerDepth int32  // how far the filler reaches under the surface, 0 for all the way down to FillerFloor
+	FillerFloor int32  // no filler below this world height
+}
+
+func (s *SurfaceRules) Apply(ch *Chunk, ctx *generationContext) {
+	for x := range CHUNK_SIZE {
+		for z := range CHUNK_SIZE {
+			s.applyColumn(ch, ctx, x, z)
+		}
+	}
+}
+
+func (s *SurfaceRules) top(BiomeID) uint16 {
+	return s.Top
+}
+
+func (s *SurfaceRules) applyColumn(ch *Chunk, ctx *generationContext, x, z uint8) {
+	height := ctx.heights[x][z]
+	fillerBottom := s.FillerFloor
+	if s.FillerDepth > 0 {
+		fillerBottom = max(fillerBottom, height-s.FillerDepth)
+	}
+	for y := range CHUNK_SIZE {
+		_, worldY, _ := ctx.worldPos(x, y, z)
+		switch {
+		case worldY == height:
+			ch.setBlockType(x, y, z, s.Top)
+		case worldY < height && worldY >= fillerBottom:
+			ch.setBlockType(x, y, z, s.Filler)
+		}
+	}
+}
+
+// BiomeSurface applies the surface rules of each column's biome.
+type BiomeSurface struct{}
+
+func (BiomeSurface) top(biome BiomeID) uint16 {
+	return Biomes[biome].Surface.Top
+}
+
+func (BiomeSurface) Apply(ch *Chunk, ctx *generationContext) {
+	for x := range CHUNK_SIZE {
+		for z := range CHUNK_SIZE {
+			Biomes[ctx.biomes[x][z]].Surface.applyColumn(ch, ctx, x, z)
+		}
+	}
+}
+
+/*
+ * Two kinds of noise caves: cheese caves are big open pockets wherever one 3D noise is high, spaghetti caves
+ * are long tunnels where two other 3D noises are both close to zero.
+ */
+type NoiseCaveCarver struct {
+	cheese, spaghettiA, spaghettiB opensimplex.Noise32
+
+	CheeseScale     float32
+	CheeseThreshold float32
+	SpaghettiScale  float32
+	SpaghettiWidth  float32
+	SurfaceMargin   int32 // cheese caves stay this many blocks under the surface
+	FloorY          int32 // nothing is carved at or below this world height
+	SeaLevel        int32 // columns under the sea keep SurfaceMargin blocks of ground so no water hangs over a cave
+}
+
+func NewNoiseCaveCarver(seed int64) *NoiseCaveCarver {
+	return &NoiseCaveCarver{
+		cheese:          opensimplex.New32(seed + 1),
+		spaghettiA:      opensimplex.New32(seed + 2),
+		spaghettiB:      opensimplex.New32(seed + 3),
+		CheeseScale:     40,
+		CheeseThreshold: 0.55,
+		SpaghettiScale:  60,
+		SpaghettiWidth:  0.06,
+		SurfaceMargin:   6,
+		FloorY:          FEATURE_BOTTOM_Y + 2,
+		SeaLevel:        math.MinInt32,
+	}
+}
+
+func (c *NoiseCaveCarver) isCave(worldX, worldY, worldZ, height int32) bool {
+	x, y, z := float32(worldX), float32(worldY), float32(worldZ)
+	if worldY < height-c.SurfaceMargin {
+		// Squashed vertically so pockets are wider than they are tall
+		if fractalNoise3D(c.cheese, worldX, worldY*2, worldZ, 1, c.CheeseScale) > c.CheeseThreshold {
+			return true
+		}
+	}
+	a := c.spaghettiA.Eval3(x/c.SpaghettiScale, y/c.SpaghettiScale, z/c.SpaghettiScale)
+	b := c.spaghettiB.Eval3(x/c.SpaghettiScale, y/c.SpaghettiScale, z/c.SpaghettiScale)
+	return a > -c.SpaghettiWidth && a < c.SpaghettiWidth && b > -c.SpaghettiWidth && b < c.SpaghettiWidth
+}
+
+// carves reports whether the block at a world position is carved out, if it is solid, in a column whose surface
+// is at height.
+func (c *NoiseCaveCarver) carves(worldX, worldY, worldZ, height int32) bool {
+	if worldY <= c.FloorY || worldY > height {
+		return false
+	}
+	if height < c.SeaLevel && worldY > height-c.SurfaceMargin {
+		return false
+	}
+	return c.isCave(worldX, worldY, worldZ, height)
+}
+
+func (c *NoiseCaveCarver) Apply(ch *Chunk, ctx *generationContext) {
+	if blockType, ok := ch.isUniform(); ok && blockType == AirID {
+		return
+	}
+	for x := range CHUNK_SIZE {
+		for z := range CHUNK_SIZE {
+			height := ctx.heights[x][z]
+			for y := range CHUNK_SIZE {
+				worldX, worldY, worldZ := ctx.worldPos(x, y, z)
+				if ch.getBlock(x, y, z).isSolid() && c.carves(worldX, worldY, worldZ, height) {
+					ch.setBlockType(x, y, z, AirID)
+				}
+			}
+		}
+	}
+}
+
+func fractalNoise(noise opensimplex.Noise32, x int32, z int32, amplitude float32, octaves int, lacunarity float32, persistence float32, scale float32) int32 {
+	val := int32(0)
+	x1 := float32(x)
+	z1 := float32(z)
+
+	for i := 0; i < octaves; i++ {
+		val += int32(noise.Eval2(x1/scale, z1/scale) * amplitude)
+		z1 *= lacunarity
+		x1 *= lacunarity
+		amplitude *= persistence
+	}
+
+	return val
+
+}
+
+// fractalNoise2D sums octaves of 2D noise, normalized back to roughly -1..1.
+func fractalNoise2D(noise opensimplex.Noise32, x int32, z int32, octaves int, lacunarity float32, persistence float32, scale float32) float32 {
+	var val, total float32
+	x1 := float32(x) / scale
+	z1 := float32(z) / scale
+	amplitude := float32(1)
+
+	for i := 0; i < octaves; i++ {
+		val += noise.Eval2(x1, z1) * amplitude
+		total += amplitude
+		x1 *= lacunarity
+		z1 *= lacunarity
+		amplitude *= persistence
+	}
+
+	return val / total
+}
+
+func fractalNoise3D(noise opensimplex.Noise32, x int32, y int32, z int32, amplitude float32, scale float32) float32 {
+	val := float32(0)
+	x1 := float32(x)
+	y1 := float32(y)
+	z1 := float32(z)
+
+	val += noise.Eval3(x1/scale, y1/scale, z1/scale) * amplitude
+
+	if val < -1 {
+		return -1
+	}
+	if val > 1 {
+		return 1
+	}
+	return val
+
+}
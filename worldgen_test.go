@@ -0,0 +1,288 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+const worldgenSeed int64 = 1234
+
+// applyStage runs a single stage over a chunk at pos, returning the context it left behind.
+func applyStage(stage GenerationStage, ch *Chunk, ctx *generationContext) *generationContext {
+	stage.Apply(ch, ctx)
+	return ctx
+}
+
+// flatContext is the context a heightmap with the same surface height for every column would leave.
+func flatContext(pos ChunkPosition, height int32) *generationContext {
+	ctx := &generationContext{pos: pos}
+	for x := range CHUNK_SIZE {
+		for z := range CHUNK_SIZE {
+			ctx.heights[x][z] = height
+		}
+	}
+	return ctx
+}
+
+// assertColumns checks every block of a chunk against want, given the block's world height.
+func assertColumns(t *testing.T, name string, ch *Chunk, pos ChunkPosition, want func(x, z uint8, worldY int32) uint16) {
+	t.Helper()
+	for x := range CHUNK_SIZE {
+		for z := range CHUNK_SIZE {
+			for y := range CHUNK_SIZE {
+				worldY := pos.getWorldY() + int32(y)
+				if got, expected := ch.getBlockType(x, y, z), want(x, z, worldY); got != expected {
+					t.Fatalf("%s: block %d,%d,%d at world height %d is %d, want %d", name, x, y, z, worldY, got, expected)
+				}
+			}
+		}
+	}
+}
+
+func TestHeightmapStage(t *testing.T) {
+	// Without amplitude the terrain is flat at BaseHeight
+	flat := NewHeightmapStage(worldgenSeed)
+	flat.Amplitude = 0
+	flat.BaseHeight = 20
+	pos := ChunkPosition{PillarPos{-3, 5}, 1}
+	ch := newChunk(AirID)
+	ctx := applyStage(flat, ch, &generationContext{pos: pos})
+	if ctx.heights != flatContext(pos, 20).heights {
+		t.Error("flat heightmap left uneven heights behind")
+	}
+	assertColumns(t, "flat", ch, pos, func(x, z uint8, worldY int32) uint16 {
+		if worldY <= 20 {
+			return StoneID
+		}
+		return AirID
+	})
+
+	// The noise terrain fills each column up to the height it reports, on a chunk the surface runs through
+	stage := NewHeightmapStage(worldgenSeed)
+	pillar := PillarPos{4, -2}
+	pos = ChunkPosition{pillar, chunkYFromWorldY(stage.Height(pillar.getWorldX(), pillar.getWorldZ()))}
+	ch = newChunk(AirID)
+	ctx = applyStage(stage, ch, &generationContext{pos: pos})
+	assertColumns(t, "noise", ch, pos, func(x, z uint8, worldY int32) uint16 {
+		height := stage.Height(pos.getWorldX()+int32(x), pos.getWorldZ()+int32(z))
+		if ctx.heights[x][z] != height {
+			t.Fatalf("column %d,%d: context height %d, want %d", x, z, ctx.heights[x][z], height)
+		}
+		if worldY <= height {
+			return StoneID
+		}
+		return AirID
+	})
+
+	// The same seed always gives the same heights, another seed other ones
+	other := NewHeightmapStage(worldgenSeed + 1)
+	same, differs := NewHeightmapStage(worldgenSeed), false
+	for x := int32(-100); x < 100; x += 7 {
+		if same.Height(x, -x) != stage.Height(x, -x) {
+			t.Fatalf("height at %d,%d changed between two heightmaps of the same seed", x, -x)
+		}
+		differs = differs || other.Height(x, -x) != stage.Height(x, -x)
+	}
+	if !differs {
+		t.Error("heightmaps of two seeds are the same")
+	}
+}
+
+func TestHeightmapAmplitudeScalesBiomes(t *testing.T) {
+	// With biomes the amplitude scales how far each column strays from its biome's base height
+	stages := make(map[float32]*HeightmapStage)
+	for _, amplitude := range []float32{0, 1, 2} {
+		stages[amplitude] = NewHeightmapStage(worldgenSeed)
+		stages[amplitude].Amplitude = amplitude
+		stages[amplitude].Biomes = NewBiomeSource(worldgenSeed)
+	}
+	var strayed, doubled float64
+	for x := int32(-300); x < 300; x += 13 {
+		base, _ := stages[0].Biomes.heightProfile(x, 2*x)
+		if got, want := stages[0].Height(x, 2*x), int32(math.Floor(float64(base))); got != want {
+			t.Fatalf("without amplitude the height at %d,%d is %d, want the base height %d", x, 2*x, got, want)
+		}
+		strayed += math.Abs(float64(stages[1].Height(x, 2*x)) - float64(base))
+		doubled += math.Abs(float64(stages[2].Height(x, 2*x)) - float64(base))
+	}
+	if strayed == 0 || math.Abs(doubled-2*strayed) > 0.2*strayed {
+		t.Errorf("columns stray %v blocks from the base height at amplitude 1, %v at amplitude 2", strayed, doubled)
+	}
+}
+
+func TestSurfaceRules(t *testing.T) {
+	pos := ChunkPosition{PillarPos{0, 0}, 0}
+	stoneUpTo := func(ctx *generationContext) *Chunk {
+		ch := newChunk(AirID)
+		for x := range CHUNK_SIZE {
+			for z := range CHUNK_SIZE {
+				for y := range CHUNK_SIZE {
+					if int32(y) <= ctx.heights[x][z] {
+						ch.setBlockType(x, y, z, StoneID)
+					}
+				}
+			}
+		}
+		return ch
+	}
+
+	// Three blocks of dirt under grass, with one column lower than the others
+	ctx := flatContext(pos, 10)
+	ctx.heights[3][4] = 2
+	ch := stoneUpTo(ctx)
+	applyStage(&SurfaceRules{Top: GrassID, Filler: DirtID, FillerDepth: 3}, ch, ctx)
+	assertColumns(t, "depth", ch, pos, func(x, z uint8, worldY int32) uint16 {
+		height := ctx.heights[x][z]
+		switch {
+		case worldY == height:
+			return GrassID
+		case worldY > height:
+			return AirID
+		case worldY >= height-3:
+			return DirtID
+		}
+		return StoneID
+	})
+
+	// Filler all the way down to a floor, which the low column is under
+	ch = stoneUpTo(ctx)
+	applyStage(&SurfaceRules{Top: SandID, Filler: GravelID, FillerFloor: 5}, ch, ctx)
+	assertColumns(t, "floor", ch, pos, func(x, z uint8, worldY int32) uint16 {
+		height := ctx.heights[x][z]
+		switch {
+		case worldY == height:
+			return SandID
+		case worldY > height:
+			return AirID
+		case worldY >= 5:
+			return GravelID
+		}
+		return StoneID
+	})
+
+	// A chunk entirely over the surface is left alone
+	ch = newChunk(AirID)
+	applyStage(&SurfaceRules{Top: GrassID, Filler: DirtID}, ch, flatContext(ChunkPosition{PillarPos{0, 0}, 3}, 10))
+	if blockType, ok := ch.isUniform(); !ok || blockType != AirID {
+		t.Error("surface rules placed blocks over the surface")
+	}
+}
+
+func TestNoiseCaveCarver(t *testing.T) {
+	// A stone chunk under a flat surface at 40, spanning world heights 32 to 47
+	pos := ChunkPosition{PillarPos{2, -1}, 2}
+	stone := func() (*Chunk, *generationContext) {
+		ctx := flatContext(pos, 40)
+		ch := newChunk(AirID)
+		applyStage(&SurfaceRules{Top: StoneID, Filler: StoneID, FillerFloor: math.MinInt32}, ch, ctx)
+		return ch, ctx
+	}
+
+	// Cheese caves everywhere and no spaghetti: all of it goes, but the margin under the surface and the floor
+	carver := NewNoiseCaveCarver(worldgenSeed)
+	carver.CheeseThreshold = -2
+	carver.SpaghettiWidth = 0
+	carver.FloorY = 32
+	ch, ctx := stone()
+	applyStage(carver, ch, ctx)
+	assertColumns(t, "cheese", ch, pos, func(x, z uint8, worldY int32) uint16 {
+		if worldY > 40 || (worldY > 32 && worldY < 40-carver.SurfaceMargin) {
+			return AirID
+		}
+		return StoneID
+	})
+
+	// Spaghetti everywhere and no cheese: tunnels reach the surface, except under the sea
+	carver.CheeseThreshold = 2
+	carver.SpaghettiWidth = 2
+	ch, ctx = stone()
+	applyStage(carver, ch, ctx)
+	assertColumns(t, "spaghetti", ch, pos, func(x, z uint8, worldY int32) uint16 {
+		if worldY > 32 {
+			return AirID
+		}
+		return StoneID
+	})
+	carver.SeaLevel = 50
+	ch, ctx = stone()
+	applyStage(carver, ch, ctx)
+	assertColumns(t, "under the sea", ch, pos, func(x, z uint8, worldY int32) uint16 {
+		if worldY > 40 || (worldY > 32 && worldY <= 40-carver.SurfaceMargin) {
+			return AirID
+		}
+		return StoneID
+	})
+
+	// The real noise only carves solid blocks where isCave says so, the same way for the same seed
+	carver = NewNoiseCaveCarver(worldgenSeed)
+	carved := 0
+	for i := range int32(16) {
+		pos := ChunkPosition{PillarPos{i % 4, i / 4}, i%4 - 1}
+		first, second := newChunk(AirID), newChunk(AirID)
+		for _, ch := range []*Chunk{first, second} {
+			ctx := flatContext(pos, 40)
+			applyStage(&SurfaceRules{Top: StoneID, Filler: StoneID, FillerFloor: math.MinInt32}, ch, ctx)
+			applyStage(NewNoiseCaveCarver(worldgenSeed), ch, ctx)
+		}
+		assertColumns(t, "noise", first, pos, func(x, z uint8, worldY int32) uint16 {
+			if worldY > 40 {
+				return AirID
+			}
+			if worldY > carver.FloorY && carver.isCave(pos.getWorldX()+int32(x), worldY, pos.getWorldZ()+int32(z), 40) {
+				carved++
+				return AirID
+			}
+			return StoneID
+		})
+		for i := range chunkVolume {
+			if first.blocks.get(i) != second.blocks.get(i) {
+				t.Fatalf("chunk %v carved two different ways with the same seed", pos)
+			}
+		}
+	}
+	if carved == 0 {
+		t.Error("no caves carved in 16 chunks")
+	}
+}
+
+func TestCaveConfiguration(t *testing.T) {
+	// The caves are the classic terrain with holes: nothing else changes and nothing solid is added
+	classic, caves := newClassicWorldGenerator(worldgenSeed), newCaveWorldGenerator(worldgenSeed)
+	carved := 0
+	for chunkY := GENERATION_BOTTOM_CHUNK_Y; chunkY <= 3; chunkY++ {
+		pos := ChunkPosition{PillarPos{-6, 3}, chunkY}
+		plain, holed := classic.GenerateChunk(pos), caves.GenerateChunk(pos)
+		for i := range chunkVolume {
+			switch before, after := plain.blocks.get(i), holed.blocks.get(i); {
+			case before == after:
+			case after == AirID:
+				carved++
+			default:
+				t.Fatalf("chunk %v: cave terrain turned block %d from %d into %d", pos, i, before, after)
+			}
+		}
+	}
+	if carved == 0 {
+		t.Error("the cave configuration carved no caves")
+	}
+}
+
+func TestHighestBlock(t *testing.T) {
+	// Nothing is generated over the height a pillar reports, trees and seas included
+	for x := int32(-30); x < 30; x += 6 {
+		generator := newBiomeWorldGenerator(worldgenSeed, NewBiomeSource(worldgenSeed))
+		pos := PillarPos{x, -2 * x}
+		highest := generator.HighestBlock(pos)
+		for chunkY := GENERATION_BOTTOM_CHUNK_Y; chunkY <= chunkYFromWorldY(highest)+2; chunkY++ {
+			cP := ChunkPosition{pos, chunkY}
+			ch := generator.GenerateChunk(cP)
+			assertColumns(t, fmt.Sprint(pos), ch, cP, func(x, z uint8, worldY int32) uint16 {
+				if worldY > highest {
+					return AirID
+				}
+				return ch.getBlockType(x, uint8(worldY-cP.getWorldY()), z)
+			})
+		}
+	}
+}